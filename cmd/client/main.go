@@ -4,29 +4,52 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/febriandani/backend-user-service/internal/infra"
 	"github.com/febriandani/backend-user-service/protogen/golang/users"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	serviceName            = "backend-user-gateway"
+	probeTimeout           = 2 * time.Second
+	defaultShutdownTimeout = 20 * time.Second
 )
 
 func main() {
-	viper.SetConfigName("config/app")
-	viper.AddConfigPath(".")
-	err := viper.ReadInConfig()
+	// same layers as the server: defaults, config/app.yaml, environment variables then _FILE secrets
+	conf, err := infra.LoadConfig("")
 	if err != nil {
-		log.Fatalf("could not connect to user service: %v", err)
+		log.Fatalf("failed to read config: %v", err)
+	}
+	userServiceAddr := fmt.Sprintf("0.0.0.0:%s", conf.App.Port)
+
+	// the trace of a request starts here and goes on to the user service through the grpc metadata
+	shutdownTracing, err := infra.InitTracing(conf.Tracing, serviceName)
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
 	}
-	userServiceAddr := fmt.Sprintf("0.0.0.0:%s", viper.GetString("APP.PORT"))
 
 	// Set up a connection to the user server.
 	fmt.Println("Connecting to user service via", userServiceAddr)
-	conn, err := grpc.Dial(userServiceAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(userServiceAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
 		log.Fatalf("could not connect to user service: %v", err)
 	}
@@ -34,15 +57,103 @@ func main() {
 
 	// Register gRPC server endpoint
 	// Note: Make sure the gRPC server is running properly and accessible
-	mux := runtime.NewServeMux()
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+	)
 	if err = users.RegisterUsersHandler(context.Background(), mux, conn); err != nil {
 		log.Fatalf("failed to register the user server: %v", err)
 	}
 
-	addr := fmt.Sprintf("0.0.0.0:%s", viper.GetString("APP.PORT_CLIENT"))
+	// probes of the orchestrator, /healthz is the server itself and /readyz the Users service with its databases
+	healthClient := healthpb.NewHealthClient(conn)
+	if err = mux.HandlePath(http.MethodGet, "/healthz", healthHandler(healthClient, "")); err != nil {
+		log.Fatalf("failed to register /healthz: %v", err)
+	}
+	if err = mux.HandlePath(http.MethodGet, "/readyz", healthHandler(healthClient, users.Users_ServiceDesc.ServiceName)); err != nil {
+		log.Fatalf("failed to register /readyz: %v", err)
+	}
+
+	addr := fmt.Sprintf("0.0.0.0:%s", conf.App.PortClient)
+	handler := otelhttp.NewHandler(mux, "gateway", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	// stop on SIGTERM of the orchestrator or ctrl-c
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// start listening to requests from the gateway server
-	fmt.Println("API gateway server is running on " + addr)
-	if err = http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal("gateway server closed abruptly: ", err)
+	go func() {
+		fmt.Println("API gateway server is running on " + addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("gateway server closed abruptly: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	timeout := time.Duration(conf.Shutdown.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	// the in-flight requests are answered before the connection to the user service is closed
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Println("API gateway server is shutting down")
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("gateway server still serving after the shutdown timeout: %v", err)
+		server.Close()
+	}
+
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("failed to flush the spans: %v", err)
+	}
+}
+
+// incomingHeaderMatcher forwards the admin key & id, api key & request id headers on top of the default grpc-gateway headers
+func incomingHeaderMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "x-admin-key", "x-admin-id", "x-api-key", "x-request-id":
+		return strings.ToLower(key), true
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeaderMatcher returns the rate limit & request id headers without the Grpc-Metadata- prefix given to the other grpc headers
+func outgoingHeaderMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "x-ratelimit-limit", "x-ratelimit-remaining", "x-ratelimit-reset", "retry-after", "x-request-id":
+		return key, true
+	}
+
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// healthHandler answers a probe with the grpc health status of service, 503 unless it is SERVING
+func healthHandler(client healthpb.HealthClient, service string) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		status := healthpb.HealthCheckResponse_UNKNOWN
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err == nil {
+			status = res.GetStatus()
+		}
+
+		code := http.StatusServiceUnavailable
+		if status == healthpb.HealthCheckResponse_SERVING {
+			code = http.StatusOK
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "{\"status\":%q}\n", status.String())
 	}
 }
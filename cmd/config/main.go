@@ -0,0 +1,64 @@
+// ./cmd/config/main.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	infra "github.com/febriandani/backend-user-service/internal/infra"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `usage: config <command> [flags]
+
+commands:
+  print [-file F] [-redacted]  print the configuration after the env & _FILE overrides
+  validate [-file F]           check the configuration like the server does on startup
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	file := flags.String("file", "", "YAML file, CONFIG_FILE or "+infra.DefaultConfigFile+" by default")
+	redacted := flags.Bool("redacted", false, "mask the passwords, keys & secrets")
+	flags.Parse(os.Args[2:])
+
+	conf, err := infra.ReadConfig(*file)
+	if err != nil {
+		log.Fatalf("failed to read config: %v", err)
+	}
+
+	switch command {
+	case "print":
+		out, err := yaml.Marshal(conf.Settings(*redacted))
+		if err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+
+		fmt.Printf("# %s\n%s", conf.File(), out)
+	case "validate":
+		appService, err := conf.AppService()
+		if err != nil {
+			log.Fatalf("failed to read config: %v", err)
+		}
+
+		err = appService.Validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s is invalid:\n%v\n", conf.File(), err)
+			os.Exit(1)
+		}
+
+		fmt.Println(conf.File(), "is valid")
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,108 @@
+// ./cmd/migrate/main.go
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	infra "github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/migrate"
+)
+
+const usage = `usage: migrate <command> [flags]
+
+commands:
+  up [-steps N]           apply pending migrations, all of them by default
+  down [-steps N]         revert applied migrations, the latest one by default
+  status                  list migrations and when they were applied
+  create [-dir D] <name>  write a new empty up & down migration
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	steps := flags.Int("steps", 0, "number of migrations to apply or revert")
+	dir := flags.String("dir", migrate.DefaultDir, "directory where create writes new migrations")
+	flags.Parse(os.Args[2:])
+
+	if command == "create" {
+		if flags.NArg() != 1 {
+			log.Fatalf("create needs exactly one migration name")
+		}
+
+		up, down, err := migrate.Create(*dir, flags.Arg(0))
+		if err != nil {
+			log.Fatalf("failed to create migration: %v", err)
+		}
+
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return
+	}
+
+	conf, err := infra.LoadConfig("")
+	if err != nil {
+		log.Fatalf("failed to read config: %v", err)
+	}
+
+	logger := infra.NewLogger(conf)
+
+	// migrations always run on the write database
+	dbWrite := infra.NewDB(logger)
+	dbWrite.ConnectDB(&conf.DatabaseUser.Write)
+	if dbWrite.Err != nil {
+		log.Fatalf("failed to connect database: %v", dbWrite.Err)
+	}
+	defer dbWrite.Close()
+
+	migrator, err := migrate.New(&dbWrite, logger)
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx, *steps)
+		if err != nil {
+			log.Fatalf("failed to migrate up after %d migrations: %v", applied, err)
+		}
+
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			log.Fatalf("failed to migrate down after %d migrations: %v", reverted, err)
+		}
+
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("failed to get migration status: %v", err)
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%06d  %-40s  %s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
@@ -3,150 +3,309 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/febriandani/backend-user-service/internal/api"
+	"github.com/febriandani/backend-user-service/internal/cache"
 	database "github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/event"
+	"github.com/febriandani/backend-user-service/internal/health"
 	infra "github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/job"
+	"github.com/febriandani/backend-user-service/internal/metrics"
+	"github.com/febriandani/backend-user-service/internal/middleware"
+	"github.com/febriandani/backend-user-service/internal/migrate"
+	"github.com/febriandani/backend-user-service/internal/notify"
+	"github.com/febriandani/backend-user-service/internal/ratelimit"
+	"github.com/febriandani/backend-user-service/internal/utils"
 	"github.com/febriandani/backend-user-service/protogen/golang/users"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+const serviceName = "backend-user-service"
+
 func main() {
-	conf, err := getConfigKey()
+	// defaults, config/app.yaml, environment variables then _FILE secrets, see infra.SectionService
+	config, err := infra.ReadConfig("")
+	if err != nil {
+		panic(err)
+	}
+
+	conf, err := config.AppService()
 	if err != nil {
 		panic(err)
 	}
 
+	err = conf.Validate()
+	if err != nil {
+		panic(fmt.Errorf("invalid config:\n%w", err))
+	}
+
 	db, log, dblist, err := newDbContext(conf)
 	if err != nil {
 		panic(err)
 	}
 
+	// the log level, token durations, rate limits & user settings are reloaded on SIGHUP or when the config file changes
+	configStore := infra.NewConfigStore(config, conf, log)
+
+	// spans of the rpc, the sql queries & bcrypt are exported to TRACING.EXPORTER
+	shutdownTracing, err := infra.InitTracing(conf.Tracing, serviceName)
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
+	}
+
+	// refuse to serve on a schema older than the embedded migrations
+	if dblist != nil && conf.DatabaseUser.Migration.RequireLatest {
+		err = checkSchema(dblist, log)
+		if err != nil {
+			log.Fatalf("database schema check failed: %v", err)
+		}
+	}
+
+	// redis is shared by the user cache and the rate limit buckets, both work without it
+	redisClient, err := infra.NewRedisClient(conf.Redis, log)
+	if err != nil {
+		log.Errorf("running without redis: %v", err)
+	}
+
+	// users read by id go through the cache selected by CACHE.DRIVER
+	userCache, err := cache.New(conf.Cache, redisClient)
+	if err != nil {
+		log.Errorf("running without user cache: %v", err)
+	}
+	if userCache != nil {
+		db = database.NewCachedRepository(db, userCache, time.Duration(conf.Cache.UserTTL)*time.Second, log)
+	}
+
+	// Init JWT signing keys & durations, revoked sessions are rejected through the session store
+	err = infra.InitJWTConfig(conf.Authorization.JWT)
+	if err != nil {
+		log.Fatalf("failed to load jwt keys: %v", err)
+	}
+	infra.InitSessionValidator(db)
+
 	// create a TCP listener on the specified port
-	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", viper.GetString("APP.PORT")))
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", conf.App.Port))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	// metrics of the rpc, the database pools and the logins, served on their own port
+	var appMetrics *metrics.Metrics
+	var metricsServer *http.Server
+	if conf.Metrics.Enabled {
+		appMetrics = metrics.New()
+		if dblist != nil {
+			appMetrics.AddDBPool("read", dblist.Backend.Read.Stats)
+			appMetrics.AddDBPool("write", dblist.Backend.Write.Stats)
+		}
+
+		metricsServer = newMetricsServer(conf.Metrics, appMetrics)
+		go func() {
+			log.Printf("metrics listening at %v", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	// the lockouts & rate limits key on the client ip, X-Forwarded-For is believed only from these proxies
+	err = utils.SetTrustedProxies(conf.App.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
+
+	// every rpc needs an access token except the public ones
+	auth := middleware.NewAuth(conf, log, api.PublicMethods, api.AdminMethods, api.SharedMethods)
+
+	// rate limit buckets are shared through redis when configured, each instance limits on its own otherwise
+	var limiter ratelimit.Limiter = ratelimit.NewLocalLimiter()
+	if redisClient != nil {
+		limiter = ratelimit.NewRedisLimiter(redisClient, limiter, log)
+	}
+	rateLimit := middleware.NewRateLimit(conf.RateLimit, limiter, log)
+
+	rpcMetrics := middleware.NewMetrics(appMetrics)
+	requestID := middleware.NewRequestID()
+
 	// create a gRPC server instance
-	server := grpc.NewServer()
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(requestID.UnaryInterceptor(), rpcMetrics.UnaryInterceptor(), rateLimit.UnaryInterceptor(), auth.UnaryInterceptor(), rateLimit.UserUnaryInterceptor()),
+		grpc.ChainStreamInterceptor(requestID.StreamInterceptor(), rpcMetrics.StreamInterceptor(), rateLimit.StreamInterceptor(), auth.StreamInterceptor(), rateLimit.UserStreamInterceptor()),
+	)
+
+	// messages to users, e.g. password reset links
+	notifier, err := notify.New(conf.Notify, log)
+	if err != nil {
+		log.Fatalf("failed to create notifier: %v", err)
+	}
 
-	userService := api.NewUserService(db, log, dblist, conf)
+	userService := api.NewUserService(db, notifier, appMetrics, log, configStore)
 
 	// register the user service with the grpc server
 	users.RegisterUsersServer(server, &userService)
 
+	// the Users service is ready only while the databases and redis answer, the server itself stays SERVING
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	checker := health.NewChecker(healthServer, []string{users.Users_ServiceDesc.ServiceName}, conf.Health, log)
+	if dblist != nil {
+		checker.Add("postgres-read", dblist.Backend.Read.PingContext)
+		checker.Add("postgres-write", dblist.Backend.Write.PingContext)
+	}
+	if redisClient != nil {
+		checker.Add("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+
+	configStore.OnReload(func(conf *infra.AppService) {
+		log.SetLevel(infra.LogLevel(conf))
+		infra.SetJWTDurations(conf.Authorization.JWT)
+		rateLimit.Update(conf.RateLimit)
+	})
+
+	// hard delete users soft deleted longer than the retention period
+	retention := job.NewRetention(db, log, conf)
+
+	// domain events written to the outbox are published by the relay, e.g. to RabbitMQ
+	publisher, err := event.New(conf.Events, conf.AMQP, log)
+	if err != nil {
+		log.Fatalf("failed to create event publisher: %v", err)
+	}
+	relay := job.NewOutboxRelay(db, publisher, log, conf)
+
+	// background jobs are stopped before the connections they use are closed
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	for _, run := range []func(ctx context.Context){checker.Run, retention.Run, relay.Run, configStore.Run} {
+		jobs.Add(1)
+		go func(run func(ctx context.Context)) {
+			defer jobs.Done()
+			run(jobsCtx)
+		}(run)
+	}
+
+	// stop on SIGTERM of the orchestrator or ctrl-c
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// start listening to requests
-	log.Printf("server listening at %v", listener.Addr())
-	if err = server.Serve(listener); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("server listening at %v", listener.Addr())
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-serveErr:
+		log.Errorf("failed to serve: %v", err)
+	case <-ctx.Done():
+		log.Info("shutting down")
+	}
+	stop()
+
+	shutdownConf := shutdownConfig(conf.Shutdown)
+	deadline := time.Now().Add(shutdownConf.timeout)
+
+	// load balancers stop sending requests once the probes fail, the in-flight ones are drained after
+	healthServer.Shutdown()
+	time.Sleep(shutdownConf.drainDelay)
+
+	gracefulStop(server, time.Until(deadline), log)
+	shutdownHTTP(metricsServer, time.Until(deadline), log)
+
+	stopJobs()
+	if !waitTimeout(&jobs, time.Until(deadline)) {
+		log.Errorf("background jobs still running after the shutdown timeout")
+	}
+
+	closeConnections(dblist, redisClient, publisher, log)
+	shutdownTracer(shutdownTracing, time.Until(deadline), log)
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// newMetricsServer creates the HTTP server of the metrics, /metrics unless METRICS.PATH is set
+func newMetricsServer(conf infra.MetricsConfig, m *metrics.Metrics) *http.Server {
+	path := conf.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, m.Handler())
+
+	return &http.Server{
+		Addr:              fmt.Sprintf("0.0.0.0:%s", conf.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
 	}
 }
 
-func getConfigKey() (*infra.AppService, error) {
-	viper.SetConfigName("config/app")
-	viper.AddConfigPath(".")
-	err := viper.ReadInConfig()
+// checkSchema returns an error when some embedded migrations are not applied yet
+func checkSchema(dblist *infra.DatabaseList, log *logrus.Logger) error {
+	migrator, err := migrate.New(dblist.Backend.Write, log)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	conf := infra.AppService{
-		App: infra.AppUser{
-			Name:         viper.GetString("APP.NAME"),
-			Environtment: viper.GetString("APP.ENV"),
-			URL:          viper.GetString("APP.URL"),
-			Port:         viper.GetString("APP.PORT"),
-			SecretKey:    viper.GetString("APP.KEY"),
-		},
-		Route: infra.RouteUser{
-			Methods: viper.GetStringSlice("ROUTE.METHODS"),
-			Headers: viper.GetStringSlice("ROUTE.HEADERS"),
-			Origins: viper.GetStringSlice("ROUTE.ORIGIN"),
-		},
-		DatabaseUser: infra.DatabaseUser{
-			Read: infra.DBDetailUser{
-				Username:     viper.GetString("DATABASE.READ.USERNAME"),
-				Password:     viper.GetString("DATABASE.READ.PASSWORD"),
-				URL:          viper.GetString("DATABASE.READ.URL"),
-				Port:         viper.GetString("DATABASE.READ.PORT"),
-				DBName:       viper.GetString("DATABASE.READ.DB_NAME"),
-				MaxIdleConns: viper.GetInt("DATABASE.READ.MAXIDLECONNS"),
-				MaxOpenConns: viper.GetInt("DATABASE.READ.MAXOPENCONNS"),
-				MaxLifeTime:  viper.GetInt("DATABASE.READ.MAXLIFETIME"),
-				Timeout:      viper.GetString("DATABASE.READ.TIMEOUT"),
-				SSLMode:      viper.GetString("DATABASE.READ.SSL_MODE"),
-			},
-			Write: infra.DBDetailUser{
-				Username:     viper.GetString("DATABASE.WRITE.USERNAME"),
-				Password:     viper.GetString("DATABASE.WRITE.PASSWORD"),
-				URL:          viper.GetString("DATABASE.WRITE.URL"),
-				Port:         viper.GetString("DATABASE.WRITE.PORT"),
-				DBName:       viper.GetString("DATABASE.WRITE.DB_NAME"),
-				MaxIdleConns: viper.GetInt("DATABASE.WRITE.MAXIDLECONNS"),
-				MaxOpenConns: viper.GetInt("DATABASE.WRITE.MAXOPENCONNS"),
-				MaxLifeTime:  viper.GetInt("DATABASE.WRITE.MAXLIFETIME"),
-				Timeout:      viper.GetString("DATABASE.WRITE.TIMEOUT"),
-				SSLMode:      viper.GetString("DATABASE.WRITE.SSL_MODE"),
-			},
-		},
-		Redis: infra.RedisUser{
-			Username:     viper.GetString("REDIS.USERNAME"),
-			Password:     viper.GetString("REDIS.PASSWORD"),
-			URL:          viper.GetString("REDIS.URL"),
-			Port:         viper.GetInt("REDIS.PORT"),
-			MinIdleConns: viper.GetInt("REDIS.MINIDLECONNS"),
-			Timeout:      viper.GetString("REDIS.TIMEOUT"),
-		},
-		Authorization: infra.AuthUser{
-			JWT: infra.JWTCredential{
-				IsActive:              viper.GetBool("AUTHORIZATION.JWT.IS_ACTIVE"),
-				AccessTokenSecretKey:  viper.GetString("AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY"),
-				AccessTokenDuration:   viper.GetInt("AUTHORIZATION.JWT.ACCESS_TOKEN_DURATION"),
-				RefreshTokenSecretKey: viper.GetString("AUTHORIZATION.JWT.REFRESH_TOKEN_SECRET_KEY"),
-				RefreshTokenDuration:  viper.GetInt("AUTHORIZATION.JWT.REFRESH_TOKEN_DURATION"),
-			},
-			Public: infra.PublicCredential{
-				SecretKey: viper.GetString("AUTHORIZATION.PUBLIC.SECRET_KEY"),
-			},
-		},
-		KeyData: infra.KeyUser{
-			User: viper.GetString("KEY.USER"),
-		},
-		Minio: infra.MinioSecret{
-			BucketName: viper.GetString("MINIO.BUCKET_NAME"),
-			Endpoint:   viper.GetString("MINIO.ENDPOINT"),
-			Key:        viper.GetString("MINIO.KEY"),
-			Secret:     viper.GetString("MINIO.SECRET"),
-			Region:     viper.GetString("MINIO.REGION"),
-			TempFolder: viper.GetString("MINIO.TEMP_FOLDER"),
-			BaseURL:    viper.GetString("MINIO.BASE_URL"),
-		},
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
 	}
 
-	return &conf, nil
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations pending, latest is %06d_%s, run `go run ./cmd/migrate up`", len(pending), pending[len(pending)-1].Version, pending[len(pending)-1].Name)
+	}
+
+	return nil
 }
 
-func newDbContext(conf *infra.AppService) (*database.DB, *logrus.Logger, *infra.DatabaseList, error) {
+// newDbContext connects the repository selected by DATABASE.DRIVER, the database list is nil for the memory driver
+func newDbContext(conf *infra.AppService) (database.Repository, *logrus.Logger, *infra.DatabaseList, error) {
 	// Init Log
 	logger := infra.NewLogger(conf)
 
+	switch conf.DatabaseUser.Driver {
+	case infra.DriverMemory:
+		logger.Warn("Using the in-memory database, data is lost on restart")
+		return database.NewMemoryDB(logger), logger, nil, nil
+	case "", infra.DriverPostgres:
+	default:
+		return nil, logger, nil, fmt.Errorf("unknown database driver %s", conf.DatabaseUser.Driver)
+	}
+
 	// Init DB Read Connection.
 	dbRead := infra.NewDB(logger)
 	dbRead.ConnectDB(&conf.DatabaseUser.Read)
 	if dbRead.Err != nil {
-		return &database.DB{}, logger, nil, dbRead.Err
+		return nil, logger, nil, dbRead.Err
 	}
 
 	// Init DB Write Connection.
 	dbWrite := infra.NewDB(logger)
 	dbWrite.ConnectDB(&conf.DatabaseUser.Write)
 	if dbWrite.Err != nil {
-		return &database.DB{}, logger, nil, dbWrite.Err
+		return nil, logger, nil, dbWrite.Err
 	}
 
 	dbList := &infra.DatabaseList{
@@ -162,11 +321,6 @@ func newDbContext(conf *infra.AppService) (*database.DB, *logrus.Logger, *infra.
 	// 	return handler, logger, err
 	// }
 
-	// redis, err := infra.NewRedisClient(conf.Redis)
-	// if err != nil {
-	// 	return handler, logger, err
-	// }
-
 	db := database.NewDB(dbList, logger)
 
 	return db, logger, dbList, dbRead.Err
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/event"
+	infra "github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+const defaultShutdownTimeout = 20 * time.Second
+
+// shutdown is SHUTDOWN with the defaults applied
+type shutdown struct {
+	timeout    time.Duration
+	drainDelay time.Duration
+}
+
+func shutdownConfig(conf infra.ShutdownConfig) shutdown {
+	result := shutdown{
+		timeout:    time.Duration(conf.Timeout) * time.Second,
+		drainDelay: time.Duration(conf.DrainDelay) * time.Second,
+	}
+
+	if result.timeout <= 0 {
+		result.timeout = defaultShutdownTimeout
+	}
+	if result.drainDelay < 0 || result.drainDelay >= result.timeout {
+		result.drainDelay = 0
+	}
+
+	return result
+}
+
+// gracefulStop waits for the in-flight rpc until timeout, the remaining ones are cancelled after
+func gracefulStop(server *grpc.Server, timeout time.Duration, log *logrus.Logger) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Info("grpc server gracefully stopped")
+	case <-time.After(timeout):
+		log.Errorf("grpc server still serving after the shutdown timeout, cancelling the in-flight rpc")
+		server.Stop()
+	}
+}
+
+// shutdownHTTP finishes the in-flight requests of an HTTP server until timeout, server may be nil
+func shutdownHTTP(server *http.Server, timeout time.Duration, log *logrus.Logger) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("failed to shut down %s: %v", server.Addr, err)
+		server.Close()
+	}
+}
+
+// waitTimeout waits for wg until timeout, returns false when it timed out
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// closeConnections closes the databases, then redis, then the event broker, all of them may be nil
+func closeConnections(dblist *infra.DatabaseList, redisClient *redis.Client, publisher event.Publisher, log *logrus.Logger) {
+	if dblist != nil {
+		dblist.Backend.Read.Close()
+		dblist.Backend.Write.Close()
+	}
+
+	if redisClient != nil {
+		err := redisClient.Close()
+		if err != nil {
+			log.Errorf("failed to close redis: %v", err)
+		} else {
+			log.Info("redis connection closed")
+		}
+	}
+
+	if publisher != nil {
+		err := publisher.Close()
+		if err != nil {
+			log.Errorf("failed to close event publisher: %v", err)
+		} else {
+			log.Info("event publisher closed")
+		}
+	}
+}
+
+// shutdownTracer exports the spans still buffered until timeout
+func shutdownTracer(shutdown func(ctx context.Context) error, timeout time.Duration, log *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := shutdown(ctx)
+	if err != nil {
+		log.Errorf("failed to flush the spans: %v", err)
+	}
+}
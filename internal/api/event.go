@@ -0,0 +1,18 @@
+package api
+
+import (
+	"context"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/event"
+)
+
+// saveUserEvent writes a domain event about a user in tx, the outbox relay publishes it once tx is committed
+func (us *UserService) saveUserEvent(ctx context.Context, tx db.Tx, eventType string, data event.UserData) error {
+	outboxEvent, err := event.NewUserEvent(eventType, data)
+	if err != nil {
+		return err
+	}
+
+	return us.db.SaveOutboxEvent(ctx, tx, outboxEvent)
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize  = 20
+	maxPageSize      = 100
+	defaultBatchSize = 500
+)
+
+// userCursor is the position after the last user of a page, encoded in page_token
+// OrderBy & Descending are kept to reject a token reused with another sort
+type userCursor struct {
+	OrderBy    string `json:"o"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v,omitempty"`
+	UserID     uint64 `json:"i"`
+}
+
+// ListUsers implements the ListUsers method of the grpc UsersServer interface to fetch a page of users
+func (us *UserService) ListUsers(ctx context.Context, req *users.ListUsersRequest) (*users.ListUsersResponse, error) {
+	us.log.WithContext(ctx).Info("Received a list users request")
+
+	filter, err := newListUsersFilter(req)
+	if err != nil {
+		return &users.ListUsersResponse{
+			ResponseMap: map[string]string{
+				"en": "Request data not valid, " + err.Error(),
+				"id": "Data request tidak valid, " + err.Error(),
+			},
+		}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	pageSize := filter.Limit
+	//fetch one more user to know whether there is a next page
+	filter.Limit++
+
+	result, err := us.db.ListUsers(ctx, filter)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", req).WithError(err).Errorf("ListUsers | Failed to get data users")
+		return &users.ListUsersResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	var nextPageToken string
+	if len(result) > pageSize {
+		result = result[:pageSize]
+		nextPageToken = encodeUserCursor(filter.OrderBy, filter.Descending, result[pageSize-1])
+	}
+
+	return &users.ListUsersResponse{
+		Users:         result,
+		NextPageToken: nextPageToken,
+		ResponseMap: map[string]string{
+			"en": "Successfully retrieved data",
+			"id": "Berhasil menampilkan data",
+		},
+	}, nil
+}
+
+// newListUsersFilter validates the request and converts it into the db filter
+func newListUsersFilter(req *users.ListUsersRequest) (db.ListUsersFilter, error) {
+	filter := db.ListUsersFilter{
+		CreatedBy:  req.GetCreatedBy(),
+		Search:     req.GetSearch(),
+		OrderBy:    req.GetOrderBy(),
+		Descending: req.GetDescending(),
+		Limit:      int(req.GetPageSize()),
+	}
+
+	if filter.OrderBy == "" {
+		filter.OrderBy = "user_id"
+	}
+
+	if _, ok := db.SortableUserColumns[filter.OrderBy]; !ok {
+		return filter, errors.New("order_by must be one of user_id, username, email, created_at")
+	}
+
+	if filter.Limit < 0 || filter.Limit > maxPageSize {
+		return filter, errors.New("page_size must be between 1 and 100")
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultPageSize
+	}
+
+	if req.IsActive != nil {
+		isActive := req.GetIsActive()
+		filter.IsActive = &isActive
+	}
+
+	if req.GetCreatedFrom() != nil {
+		createdFrom := req.GetCreatedFrom().AsTime()
+		filter.CreatedFrom = &createdFrom
+	}
+
+	if req.GetCreatedTo() != nil {
+		createdTo := req.GetCreatedTo().AsTime()
+		filter.CreatedTo = &createdTo
+	}
+
+	if req.GetPageToken() != "" {
+		cursor, err := decodeUserCursor(req.GetPageToken())
+		if err != nil || cursor.OrderBy != filter.OrderBy || cursor.Descending != filter.Descending {
+			return filter, errors.New("page_token is invalid")
+		}
+
+		filter.AfterUserID = cursor.UserID
+		filter.AfterValue = cursor.Value
+
+		if filter.OrderBy == "created_at" {
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return filter, errors.New("page_token is invalid")
+			}
+
+			filter.AfterValue = createdAt
+		}
+	}
+
+	return filter, nil
+}
+
+func encodeUserCursor(orderBy string, descending bool, last *users.User) string {
+	cursor := userCursor{
+		OrderBy:    orderBy,
+		Descending: descending,
+		UserID:     last.GetUserId(),
+	}
+
+	switch orderBy {
+	case "username":
+		cursor.Value = last.GetUsername()
+	case "email":
+		cursor.Value = last.GetEmail()
+	case "created_at":
+		cursor.Value = last.GetCreatedAt().AsTime().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(token string) (*userCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor userCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.UserID == 0 {
+		return nil, errors.New("cursor user id is empty")
+	}
+
+	return &cursor, nil
+}
+
+// BatchGetUsers implements the BatchGetUsers method of the grpc UsersServer interface to fetch many users at once
+func (us *UserService) BatchGetUsers(ctx context.Context, req *users.BatchGetUsersRequest) (*users.BatchGetUsersResponse, error) {
+	us.log.WithContext(ctx).Info("Received a batch get users request")
+
+	maxSize := us.conf().User.Batch.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultBatchSize
+	}
+
+	//keep the request order without duplicated ids
+	userIDs := make([]uint64, 0, len(req.GetUserIds()))
+	seen := make(map[uint64]bool, len(req.GetUserIds()))
+	for _, userID := range req.GetUserIds() {
+		if seen[userID] {
+			continue
+		}
+
+		seen[userID] = true
+		userIDs = append(userIDs, userID)
+	}
+
+	if len(userIDs) == 0 || len(userIDs) > maxSize {
+		return &users.BatchGetUsersResponse{
+			ResponseMap: map[string]string{
+				"en": fmt.Sprintf("User ids must contain between 1 and %d ids", maxSize),
+				"id": fmt.Sprintf("User ids harus berisi antara 1 dan %d id", maxSize),
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	result, err := us.db.GetUserByIDs(ctx, userIDs)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", req).WithError(err).Errorf("BatchGetUsers | Failed to get data users")
+		return &users.BatchGetUsersResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	byID := make(map[uint64]*users.User, len(result))
+	for _, user := range result {
+		byID[user.GetUserId()] = user
+	}
+
+	found := make([]*users.User, 0, len(result))
+	missing := make([]uint64, 0)
+	for _, userID := range userIDs {
+		user, ok := byID[userID]
+		if !ok {
+			missing = append(missing, userID)
+			continue
+		}
+
+		found = append(found, user)
+	}
+
+	return &users.BatchGetUsersResponse{
+		Users:          found,
+		MissingUserIds: missing,
+		ResponseMap: map[string]string{
+			"en": "Successfully retrieved data",
+			"id": "Berhasil menampilkan data",
+		},
+	}, nil
+}
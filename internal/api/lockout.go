@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLockoutThreshold   = 5
+	defaultLockoutIPThreshold = 20
+	defaultLockoutBaseDelay   = 30 * time.Second
+	defaultLockoutMaxDelay    = time.Hour
+	defaultLockoutWindow      = 15 * time.Minute
+)
+
+// lockoutPolicy is USER.LOCKOUT with the defaults applied
+type lockoutPolicy struct {
+	threshold   int
+	ipThreshold int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	window      time.Duration
+}
+
+func (us *UserService) lockoutPolicy() lockoutPolicy {
+	conf := us.conf().User.Lockout
+
+	policy := lockoutPolicy{
+		threshold:   conf.Threshold,
+		ipThreshold: conf.IPThreshold,
+		baseDelay:   time.Duration(conf.BaseDelay) * time.Second,
+		maxDelay:    time.Duration(conf.MaxDelay) * time.Second,
+		window:      time.Duration(conf.Window) * time.Minute,
+	}
+
+	if policy.threshold <= 0 {
+		policy.threshold = defaultLockoutThreshold
+	}
+	if policy.ipThreshold <= 0 {
+		policy.ipThreshold = defaultLockoutIPThreshold
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = defaultLockoutBaseDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = defaultLockoutMaxDelay
+	}
+	if policy.window <= 0 {
+		policy.window = defaultLockoutWindow
+	}
+
+	return policy
+}
+
+// loginSubject is an account or a client ip whose failed logins are counted
+type loginSubject struct {
+	scope     string
+	subject   string
+	threshold int
+}
+
+// lockDelay returns how long a subject is locked after its failures, doubled by every failure past the threshold
+func (p lockoutPolicy) lockDelay(failures, threshold int) time.Duration {
+	delay := p.baseDelay
+	for i := threshold; i < failures && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+
+	return delay
+}
+
+// UnlockUser implements the UnlockUser method of the grpc UsersServer interface to clear the lock of an account
+func (us *UserService) UnlockUser(ctx context.Context, req *users.PayloadWithUserID) (*users.UnlockUserResponse, error) {
+	us.log.WithContext(ctx).Info("Received an unlock user request")
+
+	if req.GetUserId() == 0 {
+		return &users.UnlockUserResponse{
+			ResponseMap: map[string]string{
+				"en": "User id cannot be empty",
+				"id": "User id tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	isCleared, err := us.db.ClearLoginAttempt(ctx, db.LoginAttemptScopeUser, userSubject(req.GetUserId()))
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("UnlockUser | Failed to clear login attempts")
+		return &users.UnlockUserResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if !isCleared {
+		return &users.UnlockUserResponse{
+			ResponseMap: map[string]string{
+				"en": "User has no failed login.",
+				"id": "Pengguna tidak memiliki login yang gagal.",
+			},
+		}, nil
+	}
+
+	us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).Infof("UnlockUser | Login attempts cleared")
+
+	return &users.UnlockUserResponse{
+		ResponseMap: map[string]string{
+			"en": "User successfully unlocked.",
+			"id": "Pengguna berhasil dibuka kuncinya.",
+		},
+	}, nil
+}
+
+// checkLoginLock returns the refusal message when logins of a subject are locked, or nil
+func (us *UserService) checkLoginLock(ctx context.Context, scope, subject string) (map[string]string, error) {
+	if subject == "" {
+		return nil, nil
+	}
+
+	attempt, err := us.db.GetLoginAttempt(ctx, scope, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	retryIn := time.Until(attempt.LockedUntil.Time)
+	if !attempt.LockedUntil.Valid || retryIn <= 0 {
+		return nil, nil
+	}
+
+	minutes := int(math.Ceil(retryIn.Minutes()))
+
+	return map[string]string{
+		"en": fmt.Sprintf("Too many failed login attempts, please try again in %d minutes.", minutes),
+		"id": fmt.Sprintf("Terlalu banyak percobaan login yang gagal, silakan coba lagi dalam %d menit.", minutes),
+	}, nil
+}
+
+// refuseLockedLogin returns the login response refusing a locked subject, or nil when the subject can login
+func (us *UserService) refuseLockedLogin(ctx context.Context, scope, subject string) (*users.LoginResponse, error) {
+	message, err := us.checkLoginLock(ctx, scope, subject)
+	if err != nil {
+		us.log.WithContext(ctx).WithField(scope, subject).WithError(err).Errorf("LoginUser | Failed to check login lock")
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if message == nil {
+		return nil, nil
+	}
+
+	us.log.WithContext(ctx).WithField(scope, subject).Errorf("LoginUser | Failed to login, too many failed logins")
+	return &users.LoginResponse{
+		ResponseMap: message,
+	}, status.Error(codes.ResourceExhausted, "too many failed logins")
+}
+
+// recordLoginFailure counts a failed login of the account, when known, and of the client ip and locks them past their threshold
+// Failures are only logged, they must not change the response of the login
+func (us *UserService) recordLoginFailure(ctx context.Context, userID uint64, ip string) {
+	policy := us.lockoutPolicy()
+	now := time.Now().UTC()
+
+	subjects := []loginSubject{{db.LoginAttemptScopeIP, ip, policy.ipThreshold}}
+	if userID != 0 {
+		subjects = append(subjects, loginSubject{db.LoginAttemptScopeUser, userSubject(userID), policy.threshold})
+	}
+
+	for _, s := range subjects {
+		if s.subject == "" {
+			continue
+		}
+
+		attempt, err := us.db.RecordLoginFailure(ctx, s.scope, s.subject, now.Add(-policy.window))
+		if err != nil {
+			us.log.WithContext(ctx).WithField(s.scope, s.subject).WithError(err).Errorf("LoginUser | Failed to record login failure")
+			continue
+		}
+
+		if attempt.Failures < s.threshold {
+			continue
+		}
+
+		lockedUntil := now.Add(policy.lockDelay(attempt.Failures, s.threshold))
+
+		err = us.db.LockLoginAttempt(ctx, s.scope, s.subject, lockedUntil)
+		if err != nil {
+			us.log.WithContext(ctx).WithField(s.scope, s.subject).WithError(err).Errorf("LoginUser | Failed to lock login")
+			continue
+		}
+
+		us.log.WithContext(ctx).WithField(s.scope, s.subject).WithField("failures", attempt.Failures).Infof("LoginUser | Login locked until %s", lockedUntil.Format(time.RFC3339))
+	}
+}
+
+// clearLoginFailures forgets the failed logins of an account after a successful login, failures are only logged
+func (us *UserService) clearLoginFailures(ctx context.Context, userID uint64) {
+	_, err := us.db.ClearLoginAttempt(ctx, db.LoginAttemptScopeUser, userSubject(userID))
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("LoginUser | Failed to clear login attempts")
+	}
+}
+
+// userSubject is the subject of the login attempts of an account
+func userSubject(userID uint64) string {
+	return strconv.FormatUint(userID, 10)
+}
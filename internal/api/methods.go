@@ -0,0 +1,62 @@
+package api
+
+// Full method names of the Users grpc service
+const (
+	MethodRegistrationUser = "/Users/RegistrationUser"
+	MethodLoginV1          = "/Users/LoginV1"
+	MethodVerifyMFA        = "/Users/VerifyMFA"
+	MethodRefreshToken     = "/Users/RefreshToken"
+	MethodRequestReset     = "/Users/RequestPasswordReset"
+	MethodConfirmReset     = "/Users/ConfirmPasswordReset"
+	MethodVerifyEmail      = "/Users/VerifyEmail"
+	MethodResendVerify     = "/Users/ResendVerification"
+	MethodEnrollTOTP       = "/Users/EnrollTOTP"
+	MethodConfirmTOTP      = "/Users/ConfirmTOTP"
+	MethodLogout           = "/Users/Logout"
+	MethodLogoutAllDevices = "/Users/LogoutAllDevices"
+	MethodListSessions     = "/Users/ListSessions"
+	MethodGetJWKS          = "/Users/GetJWKS"
+	MethodListUsers        = "/Users/ListUsers"
+	MethodBatchGetUsers    = "/Users/BatchGetUsers"
+	MethodUnlockUser       = "/Users/UnlockUser"
+	MethodRevokeSessions   = "/Users/RevokeUserSessions"
+	MethodGetUser          = "/Users/GetUser"
+	MethodUpdateUser       = "/Users/UpdateUser"
+	MethodRemoveUser       = "/Users/RemoveUser"
+	MethodRestoreUser      = "/Users/RestoreUser"
+)
+
+// Full method names of the grpc health service, called by the probes without credential
+const (
+	MethodHealthCheck = "/grpc.health.v1.Health/Check"
+	MethodHealthWatch = "/grpc.health.v1.Health/Watch"
+)
+
+// PublicMethods can be called without an access token
+var PublicMethods = []string{
+	MethodRegistrationUser,
+	MethodLoginV1,
+	MethodVerifyMFA,
+	MethodRefreshToken,
+	MethodRequestReset,
+	MethodConfirmReset,
+	MethodVerifyEmail,
+	MethodResendVerify,
+	MethodGetJWKS,
+	MethodHealthCheck,
+	MethodHealthWatch,
+}
+
+// AdminMethods are called by support staff with the admin key instead of an access token
+var AdminMethods = []string{
+	MethodListUsers,
+	MethodBatchGetUsers,
+	MethodUnlockUser,
+	MethodRevokeSessions,
+	MethodRestoreUser,
+}
+
+// SharedMethods are called by an user on itself with an access token, or by support staff on anyone with the admin key
+var SharedMethods = []string{
+	MethodUpdateUser,
+}
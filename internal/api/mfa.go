@@ -0,0 +1,445 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/metrics"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	recoveryCodeCount = 10
+	defaultMFAIssuer  = "backend-user-service"
+)
+
+// EnrollTOTP implements the EnrollTOTP method of the grpc UsersServer interface to start the TOTP enrollment of the caller
+//
+// The secret stays pending until a first code is confirmed with ConfirmTOTP, enrolling again replaces a pending secret
+func (us *UserService) EnrollTOTP(ctx context.Context, _ *users.Empty) (*users.EnrollTOTPResponse, error) {
+	us.log.WithContext(ctx).Info("Received an enroll TOTP request")
+
+	credential, _, err := us.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	alreadyEnabled := &users.EnrollTOTPResponse{
+		ResponseMap: map[string]string{
+			"en": "Two-factor authentication is already enabled.",
+			"id": "Autentikasi dua faktor sudah aktif.",
+		},
+	}
+
+	totp, err := us.db.GetTOTP(ctx, credential.GetId())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("EnrollTOTP | Failed to get TOTP")
+		return &users.EnrollTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if err == nil && totp.EnabledAt.Valid {
+		return alreadyEnabled, status.Error(codes.FailedPrecondition, "totp already enabled")
+	}
+
+	secret := utils.GenerateTOTPSecret()
+
+	encrypted, err := utils.GetEncrypt([]byte(us.conf().KeyData.User), secret)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("EnrollTOTP | Failed to encrypt secret")
+		return &users.EnrollTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionEnrollTOTPDBBegin").WithError(err).Errorf("EnrollTOTP | Failed to txBegin")
+		return &users.EnrollTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	err = us.db.SaveTOTP(ctx, tx, credential.GetId(), encrypted)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return alreadyEnabled, status.Error(codes.FailedPrecondition, "totp already enabled")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("EnrollTOTP | Failed to save TOTP")
+		return &users.EnrollTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionEnrollTOTPDBCommit").WithError(err).Errorf("EnrollTOTP | Failed to txCommit")
+		return &users.EnrollTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	issuer := us.conf().User.MFA.Issuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+
+	return &users.EnrollTOTPResponse{
+		Secret:     secret,
+		OtpauthUri: utils.TOTPURI(issuer, credential.GetEmail(), secret),
+		ResponseMap: map[string]string{
+			"en": "Scan the QR code with your authenticator app, then confirm the code it shows.",
+			"id": "Pindai kode QR dengan aplikasi autentikator Anda, lalu konfirmasi kode yang ditampilkan.",
+		},
+	}, nil
+}
+
+// ConfirmTOTP implements the ConfirmTOTP method of the grpc UsersServer interface to enable the pending TOTP secret of the caller
+//
+// The recovery codes are only returned here, they are stored hashed
+func (us *UserService) ConfirmTOTP(ctx context.Context, req *users.ConfirmTOTPRequest) (*users.ConfirmTOTPResponse, error) {
+	us.log.WithContext(ctx).Info("Received a confirm TOTP request")
+
+	credential, _, err := us.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetCode() == "" {
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "Code cannot be empty",
+				"id": "Kode tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	notPending := &users.ConfirmTOTPResponse{
+		ResponseMap: map[string]string{
+			"en": "There is no pending two-factor authentication, please enroll first.",
+			"id": "Tidak ada autentikasi dua faktor yang menunggu konfirmasi, silakan daftar terlebih dahulu.",
+		},
+	}
+
+	totp, err := us.db.GetTOTP(ctx, credential.GetId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notPending, status.Error(codes.FailedPrecondition, "totp not enrolled")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("ConfirmTOTP | Failed to get TOTP")
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if totp.EnabledAt.Valid {
+		return notPending, status.Error(codes.FailedPrecondition, "totp not enrolled")
+	}
+
+	counter, isValid, err := us.checkTOTP(totp, req.GetCode())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("ConfirmTOTP | Failed to check code")
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if !isValid {
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "Code is incorrect.",
+				"id": "Kode salah.",
+			},
+		}, status.Error(codes.InvalidArgument, "totp code invalid")
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	codeHashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := utils.GenerateRecoveryCode()
+		recoveryCodes = append(recoveryCodes, code)
+		codeHashes = append(codeHashes, utils.Hash256(utils.NormalizeRecoveryCode(code)))
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionConfirmTOTPDBBegin").WithError(err).Errorf("ConfirmTOTP | Failed to txBegin")
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	err = us.db.EnableTOTP(ctx, tx, credential.GetId(), counter)
+	if err == nil {
+		err = us.db.SaveRecoveryCodes(ctx, tx, credential.GetId(), codeHashes)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return notPending, status.Error(codes.FailedPrecondition, "totp not enrolled")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("ConfirmTOTP | Failed to enable TOTP")
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionConfirmTOTPDBCommit").WithError(err).Errorf("ConfirmTOTP | Failed to txCommit")
+		return &users.ConfirmTOTPResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+		ResponseMap: map[string]string{
+			"en": "Two-factor authentication enabled, keep the recovery codes in a safe place.",
+			"id": "Autentikasi dua faktor aktif, simpan kode pemulihan di tempat yang aman.",
+		},
+	}, nil
+}
+
+// VerifyMFA implements the VerifyMFA method of the grpc UsersServer interface to finish the login of a user with TOTP
+//
+// The mfa_token returned by LoginV1 is exchanged with a TOTP code or an unused recovery code for the token pair
+func (us *UserService) VerifyMFA(ctx context.Context, req *users.VerifyMFARequest) (*users.LoginResponse, error) {
+	us.log.WithContext(ctx).Info("Received a verify MFA request")
+
+	// counted as a login, the outcome is set before every return, what is left is a system error
+	result, reason := metrics.ResultFailure, metrics.ReasonError
+	defer func() { us.metrics.Login(result, reason) }()
+
+	if req.GetMfaToken() == "" || (req.GetCode() == "" && req.GetRecoveryCode() == "") {
+		reason = metrics.ReasonInvalidRequest
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "MFA token and code cannot be empty",
+				"id": "Token MFA dan kode tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	loginAgain := &users.LoginResponse{
+		ResponseMap: map[string]string{
+			"en": "Login session expired, please login again.",
+			"id": "Sesi login sudah kedaluwarsa, silakan login kembali.",
+		},
+	}
+
+	userID, err := infra.ParseMFAToken(req.GetMfaToken())
+	if err != nil {
+		reason = metrics.ReasonInvalidToken
+		return loginAgain, status.Error(codes.Unauthenticated, "mfa token invalid")
+	}
+
+	//the code can be guessed like a password, it shares the locks of LoginV1
+	ip := utils.GetClientIP(ctx)
+	refusal, err := us.refuseLockedLogin(ctx, db.LoginAttemptScopeIP, ip)
+	if refusal != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			reason = metrics.ReasonLocked
+		}
+		return refusal, err
+	}
+
+	refusal, err = us.refuseLockedLogin(ctx, db.LoginAttemptScopeUser, userSubject(userID))
+	if refusal != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			reason = metrics.ReasonLocked
+		}
+		return refusal, err
+	}
+
+	userData, err := us.db.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			reason = metrics.ReasonInvalidToken
+			return loginAgain, status.Error(codes.Unauthenticated, "mfa token invalid")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("VerifyMFA | Failed to get data user")
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if !userData.IsActive {
+		us.log.WithContext(ctx).WithField("user_id", userID).Errorf("VerifyMFA | Failed to login, user status not active")
+		reason = metrics.ReasonInactive
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "Login Failed, status not active.",
+				"id": "Gagal login, status tidak aktif.",
+			},
+		}, status.Error(codes.PermissionDenied, "user not active")
+	}
+
+	totp, err := us.db.GetTOTP(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("VerifyMFA | Failed to get TOTP")
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if err != nil || !totp.EnabledAt.Valid {
+		reason = metrics.ReasonInvalidToken
+		return loginAgain, status.Error(codes.Unauthenticated, "mfa token invalid")
+	}
+
+	var isValid bool
+	if req.GetCode() != "" {
+		var counter int64
+		counter, isValid, err = us.checkTOTP(totp, req.GetCode())
+		if err == nil && isValid {
+			// a code is accepted once, even inside its validity window
+			isValid, err = us.db.UseTOTPCounter(ctx, userID, counter)
+		}
+	} else {
+		isValid, err = us.db.UseRecoveryCode(ctx, userID, utils.Hash256(utils.NormalizeRecoveryCode(req.GetRecoveryCode())))
+	}
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("VerifyMFA | Failed to check code")
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if !isValid {
+		us.recordLoginFailure(ctx, userID, ip)
+		us.log.WithContext(ctx).WithField("user_id", userID).Errorf("VerifyMFA | Failed to login, code is incorrect")
+		reason = metrics.ReasonWrongCode
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "Login Failed, code is incorrect.",
+				"id": "Gagal login, kode salah.",
+			},
+		}, status.Error(codes.Unauthenticated, "mfa code invalid")
+	}
+
+	tokenPair, err := us.createSession(ctx, userData)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("VerifyMFA | Failed to login, failed to create session")
+		return &users.LoginResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	us.clearLoginFailures(ctx, userID)
+	result, reason = metrics.ResultSuccess, metrics.ReasonNone
+
+	return &users.LoginResponse{
+		UserId:    userData.GetUserId(),
+		Username:  userData.GetUsername(),
+		Email:     userData.GetEmail(),
+		JwtAccess: newJWTAccess(tokenPair),
+		ResponseMap: map[string]string{
+			"en": "Login Successfully.",
+			"id": "Berhasil Login",
+		},
+	}, nil
+}
+
+// mfaChallenge returns the LoginV1 response of a user with TOTP enabled, or nil when the password is enough
+func (us *UserService) mfaChallenge(ctx context.Context, user *users.User) (*users.LoginResponse, error) {
+	totp, err := us.db.GetTOTP(ctx, user.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !totp.EnabledAt.Valid {
+		return nil, nil
+	}
+
+	mfaToken, expiresAt, err := infra.GenerateMFAToken(user.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &users.LoginResponse{
+		UserId:          user.GetUserId(),
+		Username:        user.GetUsername(),
+		Email:           user.GetEmail(),
+		MfaRequired:     true,
+		MfaToken:        mfaToken,
+		MfaTokenExpired: expiresAt.Format(time.RFC3339),
+		ResponseMap: map[string]string{
+			"en": "Enter the code of your authenticator app to finish the login.",
+			"id": "Masukkan kode dari aplikasi autentikator Anda untuk menyelesaikan login.",
+		},
+	}, nil
+}
+
+// checkTOTP decrypts the secret of a user and checks a code, it returns the time step of the code
+func (us *UserService) checkTOTP(totp *db.TOTP, code string) (int64, bool, error) {
+	secret, err := utils.GetDecrypt([]byte(us.conf().KeyData.User), totp.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return utils.ValidateTOTP(secret, code, time.Now().UTC())
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enrollTOTP registers alice with TOTP enabled and returns her secret, the current time step and her recovery codes
+func enrollTOTP(t *testing.T, us *UserService) (string, int64, []string) {
+	t.Helper()
+
+	register(t, us, "alice", "alice@example.com")
+	userID := login(t, us, "alice@example.com", testPassword).GetUserId()
+	ctx := userContext(userID)
+
+	enrolled, err := us.EnrollTOTP(ctx, &users.Empty{})
+	if err != nil {
+		t.Fatalf("EnrollTOTP(): %v", err)
+	}
+
+	counter := utils.TOTPCounter(time.Now().UTC())
+	code, err := utils.GenerateTOTP(enrolled.GetSecret(), counter)
+	if err != nil {
+		t.Fatalf("GenerateTOTP(): %v", err)
+	}
+
+	confirmed, err := us.ConfirmTOTP(ctx, &users.ConfirmTOTPRequest{Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP(): %v", err)
+	}
+
+	return enrolled.GetSecret(), counter, confirmed.GetRecoveryCodes()
+}
+
+// mfaToken logs alice in and returns the mfa_token of the challenge
+func mfaToken(t *testing.T, us *UserService) string {
+	t.Helper()
+
+	res := login(t, us, "alice@example.com", testPassword)
+	if !res.GetMfaRequired() || res.GetMfaToken() == "" || res.GetJwtAccess() != nil {
+		t.Fatalf("LoginV1() = %v, want a MFA challenge without token pair", res)
+	}
+
+	return res.GetMfaToken()
+}
+
+func newMFATestService(t *testing.T) *UserService {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, _ := newTestService(t, conf)
+
+	return us
+}
+
+func TestVerifyMFARefusesReplayedCode(t *testing.T) {
+	us := newMFATestService(t)
+	secret, counter, _ := enrollTOTP(t, us)
+
+	// the code of the confirmation is already used
+	confirmCode, _ := utils.GenerateTOTP(secret, counter)
+	_, err := us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), Code: confirmCode})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("VerifyMFA() with the confirmation code error = %v, want Unauthenticated", err)
+	}
+
+	// the code of the next time step is accepted once
+	code, _ := utils.GenerateTOTP(secret, counter+1)
+	res, err := us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), Code: code})
+	if err != nil || res.GetJwtAccess().GetAccessToken() == "" {
+		t.Fatalf("VerifyMFA() = %v, %v, want a token pair", res, err)
+	}
+
+	_, err = us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), Code: code})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("VerifyMFA() with a replayed code error = %v, want Unauthenticated", err)
+	}
+
+	// nor is a code older than the last one used
+	_, err = us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), Code: confirmCode})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("VerifyMFA() with an older code error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestVerifyMFARefusesReusedRecoveryCode(t *testing.T) {
+	us := newMFATestService(t)
+	_, _, recoveryCodes := enrollTOTP(t, us)
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("ConfirmTOTP() returned %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+
+	res, err := us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), RecoveryCode: recoveryCodes[0]})
+	if err != nil || res.GetJwtAccess().GetAccessToken() == "" {
+		t.Fatalf("VerifyMFA() = %v, %v, want a token pair", res, err)
+	}
+
+	_, err = us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), RecoveryCode: recoveryCodes[0]})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("VerifyMFA() with a reused recovery code error = %v, want Unauthenticated", err)
+	}
+
+	// the other codes stay valid, typed in upper case too
+	res, err = us.VerifyMFA(context.Background(), &users.VerifyMFARequest{MfaToken: mfaToken(t, us), RecoveryCode: strings.ToUpper(recoveryCodes[1])})
+	if err != nil || res.GetJwtAccess().GetAccessToken() == "" {
+		t.Errorf("VerifyMFA() with another recovery code = %v, %v, want a token pair", res, err)
+	}
+}
+
+func TestVerifyMFARefusesWrongCode(t *testing.T) {
+	us := newMFATestService(t)
+	enrollTOTP(t, us)
+
+	tests := []struct {
+		name string
+		req  *users.VerifyMFARequest
+		want codes.Code
+	}{
+		{name: "wrong code", req: &users.VerifyMFARequest{Code: "000000"}, want: codes.Unauthenticated},
+		{name: "unknown recovery code", req: &users.VerifyMFARequest{RecoveryCode: "aaaaa-bbbbb"}, want: codes.Unauthenticated},
+		{name: "no code", req: &users.VerifyMFARequest{}, want: codes.InvalidArgument},
+		{name: "forged mfa token", req: &users.VerifyMFARequest{MfaToken: "not-a-jwt", Code: "000000"}, want: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.req.MfaToken == "" {
+				tt.req.MfaToken = mfaToken(t, us)
+			}
+
+			res, err := us.VerifyMFA(context.Background(), tt.req)
+			if status.Code(err) != tt.want || res.GetJwtAccess() != nil {
+				t.Errorf("VerifyMFA() = %v, %v, want %v without token pair", res, err, tt.want)
+			}
+		})
+	}
+}
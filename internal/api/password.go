@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/notify"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	userValidate "github.com/febriandani/backend-user-service/internal/validate"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultPasswordResetTTL = 30 * time.Minute
+
+// RequestPasswordReset implements the RequestPasswordReset method of the grpc UsersServer interface to send a reset token to a user
+//
+// The response is the same whether the user exists or not, so it cannot be used to find registered emails
+func (us *UserService) RequestPasswordReset(ctx context.Context, req *users.PasswordResetRequest) (*users.PasswordResetResponse, error) {
+	us.log.WithContext(ctx).Info("Received a password reset request")
+
+	if req.GetLogin() == "" {
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "Email or username cannot be empty",
+				"id": "Email atau username tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	sent := &users.PasswordResetResponse{
+		ResponseMap: map[string]string{
+			"en": "If the account exists, a password reset link has been sent to its email.",
+			"id": "Jika akun terdaftar, tautan untuk mengatur ulang kata sandi telah dikirim ke emailnya.",
+		},
+	}
+
+	user, err := us.db.GetUserByEmailOrUsername(ctx, req.GetLogin())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			us.log.WithContext(ctx).WithError(err).Errorf("RequestPasswordReset | Failed to get data user")
+		}
+
+		return sent, nil
+	}
+
+	if !user.IsActive {
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).Errorf("RequestPasswordReset | User not active")
+		return sent, nil
+	}
+
+	ttl := time.Duration(us.conf().User.PasswordReset.TTL) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultPasswordResetTTL
+	}
+
+	token := utils.GenerateSecretToken()
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionPasswordResetDBBegin").WithError(err).Errorf("RequestPasswordReset | Failed to txBegin")
+		return sent, nil
+	}
+
+	// only the latest reset link is valid
+	err = us.db.InvalidateUserTokens(ctx, tx, user.UserId, db.TokenPurposePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("RequestPasswordReset | Failed to invalidate reset tokens")
+		return sent, nil
+	}
+
+	err = us.db.SaveUserToken(ctx, tx, &db.UserToken{
+		TokenHash: utils.Hash256(token),
+		UserID:    user.UserId,
+		Purpose:   db.TokenPurposePasswordReset,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("RequestPasswordReset | Failed to save reset token")
+		return sent, nil
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionPasswordResetDBCommit").WithError(err).Errorf("RequestPasswordReset | Failed to txCommit")
+		return sent, nil
+	}
+
+	err = us.notifier.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Open %s?token=%s to choose a new password. The link expires in %d minutes.", us.conf().User.PasswordReset.URL, url.QueryEscape(token), int(ttl.Minutes())),
+	})
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("RequestPasswordReset | Failed to send reset token")
+	}
+
+	return sent, nil
+}
+
+// ConfirmPasswordReset implements the ConfirmPasswordReset method of the grpc UsersServer interface to set a new password with a reset token
+//
+// Every session of the user is revoked once the password is changed
+func (us *UserService) ConfirmPasswordReset(ctx context.Context, req *users.ConfirmPasswordResetRequest) (*users.PasswordResetResponse, error) {
+	us.log.WithContext(ctx).Info("Received a confirm password reset request")
+
+	//validate input
+	message := userValidate.ValidatePasswordReset(req)
+	if message != nil {
+		return &users.PasswordResetResponse{
+			ResponseMap: message,
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	//generate password
+	password, err := utils.GeneratePassword(ctx, req.GetPassword())
+	if err != nil {
+		us.log.WithContext(ctx).WithError(err).Errorf("ConfirmPasswordReset | Failed to generate password")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There was an error changing the password",
+				"id": "Ada kesalahan dalam mengubah kata sandi",
+			},
+		}, err
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionPasswordResetDBBegin").WithError(err).Errorf("ConfirmPasswordReset | Failed to txBegin")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	userID, err := us.db.UseUserToken(ctx, tx, utils.Hash256(req.GetToken()), db.TokenPurposePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.PasswordResetResponse{
+				ResponseMap: map[string]string{
+					"en": "Reset token is invalid or expired, please request a new one.",
+					"id": "Token reset tidak valid atau sudah kedaluwarsa, silakan minta token baru.",
+				},
+			}, status.Error(codes.InvalidArgument, "reset token invalid")
+		}
+
+		us.log.WithContext(ctx).WithError(err).Errorf("ConfirmPasswordReset | Failed to use reset token")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	err = us.db.UpdatePassword(ctx, tx, userID, password)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.PasswordResetResponse{
+				ResponseMap: map[string]string{
+					"en": "Reset token is invalid or expired, please request a new one.",
+					"id": "Token reset tidak valid atau sudah kedaluwarsa, silakan minta token baru.",
+				},
+			}, status.Error(codes.InvalidArgument, "reset token invalid")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("ConfirmPasswordReset | Failed to update password")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	// the password may have been reset because the account was taken over, log out everywhere with the password change
+	_, err = us.db.RevokeAllSessions(ctx, tx, userID)
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("ConfirmPasswordReset | Failed to revoke sessions")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionPasswordResetDBCommit").WithError(err).Errorf("ConfirmPasswordReset | Failed to txCommit")
+		return &users.PasswordResetResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.PasswordResetResponse{
+		ResponseMap: map[string]string{
+			"en": "Password successfully changed, please login again.",
+			"id": "Kata sandi berhasil diubah, silakan login kembali.",
+		},
+	}, nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/notify"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const newPassword = "N3w-passw0rd-reset"
+
+// resetTokenPattern finds the token in the link of the reset message
+var resetTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// capturingNotifier keeps the messages sent instead of delivering them
+type capturingNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *capturingNotifier) Send(ctx context.Context, msg notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// newPasswordTestService returns the user service with alice registered & logged in, and the notifier of its messages
+func newPasswordTestService(t *testing.T) (*UserService, *db.MemoryDB, *capturingNotifier, *users.JWTAccess) {
+	t.Helper()
+
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, memory := newTestService(t, conf)
+	notifier := &capturingNotifier{}
+	us.notifier = notifier
+
+	register(t, us, "alice", "alice@example.com")
+	tokens := login(t, us, "alice@example.com", testPassword).GetJwtAccess()
+
+	return us, memory, notifier, tokens
+}
+
+// requestReset requests a password reset for login and returns the token of the message sent
+func requestReset(t *testing.T, us *UserService, notifier *capturingNotifier, login string) string {
+	t.Helper()
+
+	_, err := us.RequestPasswordReset(context.Background(), &users.PasswordResetRequest{Login: login})
+	if err != nil {
+		t.Fatalf("RequestPasswordReset(%s): %v", login, err)
+	}
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if len(notifier.messages) == 0 {
+		t.Fatalf("RequestPasswordReset(%s) sent no message", login)
+	}
+
+	match := resetTokenPattern.FindStringSubmatch(notifier.messages[len(notifier.messages)-1].Body)
+	if match == nil {
+		t.Fatalf("no reset token in %q", notifier.messages[len(notifier.messages)-1].Body)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("QueryUnescape(%s): %v", match[1], err)
+	}
+
+	return token
+}
+
+// confirmReset sets newPassword with token
+func confirmReset(us *UserService, token string) error {
+	_, err := us.ConfirmPasswordReset(context.Background(), &users.ConfirmPasswordResetRequest{
+		Token:      token,
+		Password:   newPassword,
+		Repassword: newPassword,
+	})
+
+	return err
+}
+
+// loginFails reports whether email cannot log in with password, a refused login returns no token pair
+func loginFails(us *UserService, email, password string) bool {
+	res, err := us.LoginV1(context.Background(), &users.PayloadWithSingleUser{User: &users.User{Email: email, Password: password}})
+	return err != nil || res.GetJwtAccess() == nil
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	us, _, notifier, tokens := newPasswordTestService(t)
+	token := requestReset(t, us, notifier, "alice@example.com")
+
+	err := confirmReset(us, token)
+	if err != nil {
+		t.Fatalf("ConfirmPasswordReset(): %v", err)
+	}
+
+	// the sessions opened with the old password are revoked with the change
+	_, err = infra.CheckAccessToken(context.Background(), tokens.GetAccessToken())
+	if err == nil {
+		t.Errorf("CheckAccessToken() accepted an access token issued before the reset")
+	}
+	_, err = us.RefreshToken(context.Background(), &users.RefreshTokenRequest{RenewToken: tokens.GetRenewToken()})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken() with a renew token issued before the reset error = %v, want Unauthenticated", err)
+	}
+
+	if !loginFails(us, "alice@example.com", testPassword) {
+		t.Errorf("LoginV1() accepted the old password")
+	}
+	login(t, us, "alice@example.com", newPassword)
+
+	// the token works once
+	err = confirmReset(us, token)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ConfirmPasswordReset() with a used token error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestConfirmPasswordResetRefusedToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(t *testing.T, us *UserService, memory *db.MemoryDB, notifier *capturingNotifier) string
+	}{
+		{
+			name: "expired",
+			token: func(t *testing.T, us *UserService, memory *db.MemoryDB, notifier *capturingNotifier) string {
+				token := utils.GenerateSecretToken()
+				err := memory.SaveUserToken(context.Background(), nil, &db.UserToken{
+					TokenHash: utils.Hash256(token),
+					UserID:    getUser(t, memory, "alice").UserId,
+					Purpose:   db.TokenPurposePasswordReset,
+					ExpiresAt: time.Now().UTC().Add(-time.Minute),
+				})
+				if err != nil {
+					t.Fatalf("SaveUserToken(): %v", err)
+				}
+
+				return token
+			},
+		},
+		{
+			name: "replaced by a later request",
+			token: func(t *testing.T, us *UserService, memory *db.MemoryDB, notifier *capturingNotifier) string {
+				token := requestReset(t, us, notifier, "alice@example.com")
+				requestReset(t, us, notifier, "alice")
+
+				return token
+			},
+		},
+		{
+			name: "unknown",
+			token: func(t *testing.T, us *UserService, memory *db.MemoryDB, notifier *capturingNotifier) string {
+				return utils.GenerateSecretToken()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			us, memory, notifier, tokens := newPasswordTestService(t)
+
+			err := confirmReset(us, tt.token(t, us, memory, notifier))
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("ConfirmPasswordReset() error = %v, want InvalidArgument", err)
+			}
+
+			// nothing changed, neither the password nor the sessions
+			_, err = infra.CheckAccessToken(context.Background(), tokens.GetAccessToken())
+			if err != nil {
+				t.Errorf("CheckAccessToken() after a refused reset: %v", err)
+			}
+			if !loginFails(us, "alice@example.com", newPassword) {
+				t.Errorf("LoginV1() accepted the password of a refused reset")
+			}
+		})
+	}
+}
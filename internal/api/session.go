@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/middleware"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Logout implements the Logout method of the grpc UsersServer interface to revoke the caller session
+func (us *UserService) Logout(ctx context.Context, _ *users.Empty) (*users.LogoutResponse, error) {
+	us.log.WithContext(ctx).Info("Received a logout request")
+
+	credential, sessionID, err := us.authorize(ctx)
+	if err != nil {
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "Unauthorized, please login again.",
+				"id": "Tidak memiliki akses, silakan login kembali.",
+			},
+		}, err
+	}
+
+	err = us.db.RevokeSession(ctx, credential.GetId(), sessionID)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("session", sessionID).WithError(err).Errorf("Logout | Failed to revoke session")
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.LogoutResponse{
+		RevokedSessions: 1,
+		ResponseMap: map[string]string{
+			"en": "Logout Successfully.",
+			"id": "Berhasil Logout.",
+		},
+	}, nil
+}
+
+// LogoutAllDevices implements the LogoutAllDevices method of the grpc UsersServer interface to revoke every session of the caller
+func (us *UserService) LogoutAllDevices(ctx context.Context, _ *users.Empty) (*users.LogoutResponse, error) {
+	us.log.WithContext(ctx).Info("Received a logout all devices request")
+
+	credential, _, err := us.authorize(ctx)
+	if err != nil {
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "Unauthorized, please login again.",
+				"id": "Tidak memiliki akses, silakan login kembali.",
+			},
+		}, err
+	}
+
+	revoked, err := us.db.RevokeAllSessions(ctx, nil, credential.GetId())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("LogoutAllDevices | Failed to revoke sessions")
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.LogoutResponse{
+		RevokedSessions: revoked,
+		ResponseMap: map[string]string{
+			"en": "Successfully logged out from all devices.",
+			"id": "Berhasil logout dari semua perangkat.",
+		},
+	}, nil
+}
+
+// RevokeUserSessions implements the RevokeUserSessions method of the grpc UsersServer interface to log another user out of every device
+func (us *UserService) RevokeUserSessions(ctx context.Context, req *users.PayloadWithUserID) (*users.LogoutResponse, error) {
+	us.log.WithContext(ctx).Info("Received a revoke user sessions request")
+
+	if req.GetUserId() == 0 {
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "User id cannot be empty",
+				"id": "User id tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	revoked, err := us.db.RevokeAllSessions(ctx, nil, req.GetUserId())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RevokeUserSessions | Failed to revoke sessions")
+		return &users.LogoutResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithField("admin_id", middleware.GetAdminID(ctx)).Infof("RevokeUserSessions | %d sessions revoked", revoked)
+
+	return &users.LogoutResponse{
+		RevokedSessions: revoked,
+		ResponseMap: map[string]string{
+			"en": "User successfully logged out from all devices.",
+			"id": "Pengguna berhasil dikeluarkan dari semua perangkat.",
+		},
+	}, nil
+}
+
+// ListSessions implements the ListSessions method of the grpc UsersServer interface to fetch the active sessions of the caller
+func (us *UserService) ListSessions(ctx context.Context, _ *users.Empty) (*users.ListSessionsResponse, error) {
+	us.log.WithContext(ctx).Info("Received a list sessions request")
+
+	credential, sessionID, err := us.authorize(ctx)
+	if err != nil {
+		return &users.ListSessionsResponse{
+			ResponseMap: map[string]string{
+				"en": "Unauthorized, please login again.",
+				"id": "Tidak memiliki akses, silakan login kembali.",
+			},
+		}, err
+	}
+
+	sessions, err := us.db.GetSessionsByUserID(ctx, credential.GetId())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).WithError(err).Errorf("ListSessions | Failed to get sessions")
+		return &users.ListSessionsResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	result := make([]*users.Session, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, &users.Session{
+			SessionId:  session.SessionID,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IPAddress,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+			ExpiresAt:  timestamppb.New(session.ExpiresAt),
+			Current:    session.SessionID == sessionID,
+		})
+	}
+
+	return &users.ListSessionsResponse{
+		Sessions: result,
+		ResponseMap: map[string]string{
+			"en": "Successfully retrieved data",
+			"id": "Berhasil menampilkan data",
+		},
+	}, nil
+}
+
+// createSession issues a token pair for a user and stores the session & its renew token
+func (us *UserService) createSession(ctx context.Context, user *users.User) (*infra.TokenPair, error) {
+	session, err := utils.GetEncrypt([]byte(us.conf().KeyData.User), utils.StructToString(users.CredentialData{
+		Id:       user.GetUserId(),
+		Username: user.GetUsername(),
+		Email:    user.GetEmail(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	tokenPair, err := infra.GenerateJWT(session)
+	if err != nil {
+		return nil, err
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = us.db.SaveSession(ctx, tx, &db.Session{
+		SessionID: tokenPair.Family,
+		UserID:    user.GetUserId(),
+		UserAgent: utils.GetUserAgent(ctx),
+		IPAddress: utils.GetClientIP(ctx),
+		ExpiresAt: tokenPair.RenewTokenExpired,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = us.db.SaveRefreshToken(ctx, tx, &db.RefreshToken{
+		TokenID:   tokenPair.RenewTokenID,
+		Family:    tokenPair.Family,
+		UserID:    user.GetUserId(),
+		ExpiresAt: tokenPair.RenewTokenExpired,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenPair, nil
+}
+
+// revokeSession revokes a session and its renew tokens, failures are only logged
+func (us *UserService) revokeSession(ctx context.Context, userID uint64, sessionID string) {
+	err := us.db.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("session", sessionID).WithError(err).Errorf("RevokeSession | Failed to revoke session")
+	}
+}
+
+// authorize returns the caller credential and session id put on the context by the auth interceptor
+func (us *UserService) authorize(ctx context.Context) (*users.CredentialData, string, error) {
+	credential, sessionID, ok := middleware.GetCredential(ctx)
+	if !ok || sessionID == "" {
+		return nil, "", status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	return credential, sessionID, nil
+}
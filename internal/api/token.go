@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/metrics"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RefreshToken implements the RefreshToken method of the grpc UsersServer interface to exchange a renew token for a new token pair
+//
+// Every renew token can be used once. Presenting a renew token that was already rotated revokes its whole family
+func (us *UserService) RefreshToken(ctx context.Context, req *users.RefreshTokenRequest) (*users.RefreshTokenResponse, error) {
+	us.log.WithContext(ctx).Info("Received a refresh token request")
+
+	// the outcome is set before every return, what is left is a system error
+	result, reason := metrics.ResultFailure, metrics.ReasonError
+	defer func() { us.metrics.TokenRefresh(result, reason) }()
+
+	if req.GetRenewToken() == "" {
+		reason = metrics.ReasonInvalidRequest
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "Renew token cannot be empty",
+				"id": "Renew token tidak boleh kosong",
+			},
+		}, errors.New("data not valid")
+	}
+
+	invalidToken := &users.RefreshTokenResponse{
+		ResponseMap: map[string]string{
+			"en": "Renew token is invalid or expired, please login again.",
+			"id": "Renew token tidak valid atau sudah kedaluwarsa, silakan login kembali.",
+		},
+	}
+
+	claims, err := infra.ParseRenewToken(req.GetRenewToken())
+	if err != nil {
+		us.log.WithContext(ctx).WithError(err).Errorf("RefreshToken | Failed to parse renew token")
+		reason = metrics.ReasonInvalidToken
+		return invalidToken, status.Error(codes.Unauthenticated, "renew token invalid")
+	}
+
+	stored, err := us.db.GetRefreshToken(ctx, claims.Id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			us.log.WithContext(ctx).WithField("family", claims.Family).Errorf("RefreshToken | Failed to refresh, renew token not found")
+			reason = metrics.ReasonInvalidToken
+			return invalidToken, status.Error(codes.Unauthenticated, "renew token invalid")
+		}
+
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to get renew token")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if stored.Family != claims.Family || stored.RevokedAt.Valid || time.Now().UTC().After(stored.ExpiresAt) {
+		us.log.WithContext(ctx).WithField("family", claims.Family).Errorf("RefreshToken | Failed to refresh, renew token revoked or expired")
+		reason = metrics.ReasonInvalidToken
+		return invalidToken, status.Error(codes.Unauthenticated, "renew token invalid")
+	}
+
+	user, err := us.db.GetUserByID(ctx, stored.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to get data user")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if user == nil || !user.IsActive {
+		us.revokeSession(ctx, stored.UserID, claims.Family)
+		us.log.WithContext(ctx).WithField("family", claims.Family).Errorf("RefreshToken | Failed to refresh, user not found or not active")
+		reason = metrics.ReasonInvalidToken
+		return invalidToken, status.Error(codes.Unauthenticated, "renew token invalid")
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionRefreshTokenDBBegin").WithError(err).Errorf("RefreshToken | Failed to txBegin")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	isUsed, err := us.db.UseRefreshToken(ctx, tx, claims.Id)
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to rotate renew token")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if !isUsed {
+		// the renew token was already rotated, somebody is replaying it
+		tx.Rollback()
+		us.revokeSession(ctx, stored.UserID, claims.Family)
+		us.log.WithContext(ctx).WithField("family", claims.Family).Warnf("RefreshToken | Renew token reuse detected, session revoked")
+		reason = metrics.ReasonTokenReused
+		return invalidToken, status.Error(codes.Unauthenticated, "renew token invalid")
+	}
+
+	tokenPair, err := infra.RenewAccessToken(claims)
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to generate jwt token")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	err = us.db.SaveRefreshToken(ctx, tx, &db.RefreshToken{
+		TokenID:   tokenPair.RenewTokenID,
+		Family:    tokenPair.Family,
+		UserID:    stored.UserID,
+		ExpiresAt: tokenPair.RenewTokenExpired,
+	})
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to save renew token")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	err = us.db.TouchSession(ctx, tx, tokenPair.Family, tokenPair.RenewTokenExpired)
+	if err != nil {
+		tx.Rollback()
+		us.log.WithContext(ctx).WithField("family", claims.Family).WithError(err).Errorf("RefreshToken | Failed to extend session")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionRefreshTokenDBCommit").WithError(err).Errorf("RefreshToken | Failed to txCommit")
+		return &users.RefreshTokenResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	result, reason = metrics.ResultSuccess, metrics.ReasonNone
+
+	return &users.RefreshTokenResponse{
+		JwtAccess: newJWTAccess(tokenPair),
+		ResponseMap: map[string]string{
+			"en": "Token successfully refreshed.",
+			"id": "Token berhasil diperbarui.",
+		},
+	}, nil
+}
+
+// GetJWKS implements the GetJWKS method of the grpc UsersServer interface to publish the access token verification keys
+func (us *UserService) GetJWKS(ctx context.Context, _ *users.Empty) (*httpbody.HttpBody, error) {
+	data, err := json.Marshal(infra.PublicJWKS())
+	if err != nil {
+		us.log.WithContext(ctx).WithError(err).Errorf("GetJWKS | Failed to marshal jwks")
+		return nil, err
+	}
+
+	return &httpbody.HttpBody{
+		ContentType: "application/json",
+		Data:        data,
+	}, nil
+}
+
+// newJWTAccess converts an issued token pair into the JWTAccess response
+func newJWTAccess(tokenPair *infra.TokenPair) *users.JWTAccess {
+	return &users.JWTAccess{
+		AccessToken:        tokenPair.AccessToken,
+		AccessTokenExpired: tokenPair.AccessTokenExpired.Format(time.RFC3339),
+		RenewToken:         tokenPair.RenewToken,
+		RenewTokenExpired:  tokenPair.RenewTokenExpired.Format(time.RFC3339),
+	}
+}
@@ -4,42 +4,57 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"log"
-	"time"
+	"slices"
+	"strconv"
 
 	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/event"
 	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/metrics"
+	"github.com/febriandani/backend-user-service/internal/middleware"
+	"github.com/febriandani/backend-user-service/internal/notify"
 	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
 	userValidate "github.com/febriandani/backend-user-service/internal/validate"
 	"github.com/febriandani/backend-user-service/protogen/golang/users"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // UserService should implement the UsersServer interface generated from grpc.
 //
 // UnimplementedUsersServer must be embedded to have forwarded compatible implementations.
 type UserService struct {
-	db     *db.DB
-	dbConn *infra.DatabaseList
-	log    *logrus.Logger
-	conf   *infra.AppService
+	db       db.Repository
+	notifier notify.Notifier
+	metrics  *metrics.Metrics
+	log      *logrus.Logger
+	config   *infra.ConfigStore
 	users.UnimplementedUsersServer
 }
 
-// NewUserService creates a new UserService
-func NewUserService(db *db.DB, logger *logrus.Logger, dbList *infra.DatabaseList, conf *infra.AppService) UserService {
+// NewUserService creates a new UserService, metrics may be nil
+func NewUserService(db db.Repository, notifier notify.Notifier, metrics *metrics.Metrics, logger *logrus.Logger, config *infra.ConfigStore) UserService {
 	return UserService{
-		db:     db,
-		log:    logger,
-		dbConn: dbList,
-		conf:   conf,
+		db:       db,
+		notifier: notifier,
+		metrics:  metrics,
+		log:      logger,
+		config:   config,
 	}
 }
 
+// conf returns the current config, the reloadable keys may differ from one rpc to the next
+func (us *UserService) conf() *infra.AppService {
+	return us.config.Load()
+}
+
 // RegistrationUser implements the RegistrationUser method of the grpc UsersServer interface to add a new user
 func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadWithSingleUser) (*users.RegistrationUserResponse, error) {
-	log.Printf("Received an add user request ")
+	us.log.WithContext(ctx).Info("Received an add user request")
 
 	//validate input
 	message := userValidate.ValidateUserRegistration(req.User)
@@ -50,9 +65,9 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	}
 
 	//start transaction db
-	txUser, err := us.dbConn.Backend.Write.Begin()
+	txUser, err := us.db.Begin(ctx)
 	if err != nil {
-		us.log.WithField("request: ", "transactionUserDBBegin").WithError(err).Errorf("AddUser | Failed to txUserBegin")
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBBegin").WithError(err).Errorf("AddUser | Failed to txUserBegin")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -64,7 +79,7 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	//check Username and email isexist
 	isExist, err := us.db.CheckIsExistUser(ctx, req.User)
 	if err != nil {
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to check is exist user")
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to check is exist user")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -74,7 +89,7 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	}
 
 	if isExist {
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to create user, username or email already exists")
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to create user, username or email already exists")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "Failed to create user, username or email already exists.",
@@ -85,7 +100,7 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 
 	//compare password and re-password
 	if req.User.GetPassword() != req.User.GetRepassword() {
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to create user, password not same")
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to create user, password not same")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "Password and re-password are not the same.",
@@ -95,9 +110,9 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	}
 
 	//generate password
-	password, err := utils.GeneratePassword(req.User.Password)
+	password, err := utils.GeneratePassword(ctx, req.User.Password)
 	if err != nil {
-		us.log.WithField("request", utils.StructToString(nil)).WithError(err).Errorf("AddUser | Failed to create user, failed generate password")
+		us.log.WithContext(ctx).WithField("request", utils.StructToString(nil)).WithError(err).Errorf("AddUser | Failed to create user, failed generate password")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "There was an error changing the password",
@@ -107,16 +122,38 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	}
 
 	//save into db
-	_, err = us.db.SaveUser(ctx, txUser, &users.User{
+	newUser := &users.User{
 		Username:  req.User.Username,
 		Email:     req.User.Email,
 		Password:  password,
 		IsActive:  true,
 		CreatedBy: "system",
+	}
+
+	userID, err := us.db.SaveUser(ctx, txUser, newUser)
+	if err != nil {
+		txUser.Rollback()
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to save user")
+		return &users.RegistrationUserResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//other services learn about the user once the transaction is committed
+	isActive := newUser.IsActive
+	err = us.saveUserEvent(ctx, txUser, event.TypeUserRegistered, event.UserData{
+		UserID:   uint64(userID),
+		Username: newUser.Username,
+		Email:    newUser.Email,
+		IsActive: &isActive,
+		By:       newUser.CreatedBy,
 	})
 	if err != nil {
 		txUser.Rollback()
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("AddUser | Failed to save user")
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("AddUser | Failed to save user event")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -128,7 +165,7 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 	//commit transaction db
 	err = txUser.Commit()
 	if err != nil {
-		us.log.WithField("request: ", "transactionUserDBCommit").WithError(err).Errorf("AddUser | Failed to txUserCommit")
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBCommit").WithError(err).Errorf("AddUser | Failed to txUserCommit")
 		return &users.RegistrationUserResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -137,10 +174,19 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 		}, err
 	}
 
+	us.metrics.Registration()
+
+	// the account works right away, the email is verified through the link sent here
+	newUser.UserId = uint64(userID)
+	err = us.sendVerification(ctx, newUser)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("AddUser | Failed to send verification")
+	}
+
 	return &users.RegistrationUserResponse{
 		ResponseMap: map[string]string{
-			"en": "Account successfully created",
-			"id": "Akun berhasil dibuat",
+			"en": "Account successfully created, please check your email to verify it.",
+			"id": "Akun berhasil dibuat, silakan cek email Anda untuk verifikasi.",
 		},
 	}, nil
 }
@@ -148,20 +194,35 @@ func (us *UserService) RegistrationUser(ctx context.Context, req *users.PayloadW
 // LoginV1 implements the LoginV1 method of the grpc UsersServer interface to login
 func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingleUser) (*users.LoginResponse, error) {
 
-	log.Printf("Received an add user login request")
+	us.log.WithContext(ctx).Info("Received an add user login request")
+
+	// the outcome is set before every return, what is left is a system error
+	result, reason := metrics.ResultFailure, metrics.ReasonError
+	defer func() { us.metrics.Login(result, reason) }()
 
 	//validate input
 	message := userValidate.ValidateUserLogin(req.User)
 	if message != nil {
+		reason = metrics.ReasonInvalidRequest
 		return &users.LoginResponse{
 			ResponseMap: message,
 		}, errors.New("data not valid")
 	}
 
+	//refuse client ips locked after too many failed logins
+	ip := utils.GetClientIP(ctx)
+	refusal, err := us.refuseLockedLogin(ctx, db.LoginAttemptScopeIP, ip)
+	if refusal != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			reason = metrics.ReasonLocked
+		}
+		return refusal, err
+	}
+
 	//check Username and email isexist
 	isExist, err := us.db.CheckIsExistUser(ctx, req.User)
 	if err != nil {
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("LoginUser | Failed to check is exist user")
+		us.log.WithContext(ctx).WithField("email", req.User.GetEmail()).WithError(err).Errorf("LoginUser | Failed to check is exist user")
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -171,7 +232,9 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 	}
 
 	if !isExist {
-		us.log.WithField("request: ", utils.StructToString(req.User)).WithError(err).Errorf("LoginUser | Failed to login, username or email not exists")
+		us.recordLoginFailure(ctx, 0, ip)
+		us.log.WithContext(ctx).WithField("email", req.User.GetEmail()).WithError(err).Errorf("LoginUser | Failed to login, username or email not exists")
+		reason = metrics.ReasonUserNotFound
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "Login Failed, username or email not exists.",
@@ -180,9 +243,9 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
-	userData, err := us.db.GetUserByEmailOrUsername(req.User.Email)
+	userData, err := us.db.GetUserByEmailOrUsername(ctx, req.User.Email)
 	if err != nil {
-		us.log.WithField("request", utils.StructToString(req.User.Email)).WithError(err).Errorf("LoginUser | Failed to login, error from db")
+		us.log.WithContext(ctx).WithField("request", utils.StructToString(req.User.Email)).WithError(err).Errorf("LoginUser | Failed to login, error from db")
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -191,8 +254,18 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
+	//refuse accounts locked after too many failed logins
+	refusal, err = us.refuseLockedLogin(ctx, db.LoginAttemptScopeUser, userSubject(userData.GetUserId()))
+	if refusal != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			reason = metrics.ReasonLocked
+		}
+		return refusal, err
+	}
+
 	if !userData.IsActive {
-		us.log.WithField("response: ", utils.StructToString(userData)).WithError(err).Errorf("LoginUser | Failed to login, user status not active")
+		us.log.WithContext(ctx).WithField("response: ", utils.StructToString(userData)).WithError(err).Errorf("LoginUser | Failed to login, user status not active")
+		reason = metrics.ReasonInactive
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "Login Failed, status not active.",
@@ -201,9 +274,9 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
-	isValid, err := utils.ComparePassword(userData.Password, req.User.Password)
+	isValid, err := utils.ComparePassword(ctx, userData.Password, req.User.Password)
 	if err != nil {
-		us.log.WithField("request: ", utils.StructToString(req)).WithError(err).Errorf("LoginUser | Failed to login, failed to compare password")
+		us.log.WithContext(ctx).WithField("user_id", userData.GetUserId()).WithError(err).Errorf("LoginUser | Failed to login, failed to compare password")
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -213,7 +286,9 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 	}
 
 	if !isValid {
-		us.log.WithField("request", utils.StructToString(req)).WithError(err).Errorf("LoginUser | Failed to login, password is incorrect")
+		us.recordLoginFailure(ctx, userData.GetUserId(), ip)
+		us.log.WithContext(ctx).WithField("user_id", userData.GetUserId()).WithError(err).Errorf("LoginUser | Failed to login, password is incorrect")
+		reason = metrics.ReasonWrongPassword
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "Login Failed, password is incorrect",
@@ -222,13 +297,19 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
-	session, err := utils.GetEncrypt([]byte(us.conf.KeyData.User), utils.StructToString(users.CredentialData{
-		Id:       userData.GetUserId(),
-		Username: userData.GetUsername(),
-		Email:    userData.GetEmail(),
-	}))
+	message = us.checkEmailVerified(userData)
+	if message != nil {
+		us.log.WithContext(ctx).WithField("user_id", userData.GetUserId()).Errorf("LoginUser | Failed to login, email not verified")
+		reason = metrics.ReasonEmailNotVerified
+		return &users.LoginResponse{
+			ResponseMap: message,
+		}, status.Error(codes.FailedPrecondition, "email not verified")
+	}
+
+	// users with TOTP get a challenge token instead of the token pair
+	challenge, err := us.mfaChallenge(ctx, userData)
 	if err != nil {
-		us.log.WithField("request: ", utils.StructToString(req)).WithError(err).Errorf("LoginUser | Failed to login, failed to encrypt jwt")
+		us.log.WithContext(ctx).WithField("user_id", userData.GetUserId()).WithError(err).Errorf("LoginUser | Failed to login, failed to create MFA challenge")
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -237,11 +318,14 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
-	generateTime := time.Now().UTC()
+	if challenge != nil {
+		result, reason = metrics.ResultMFARequired, metrics.ReasonNone
+		return challenge, nil
+	}
 
-	accessToken, renewToken, err := infra.GenerateJWT(session)
+	tokenPair, err := us.createSession(ctx, userData)
 	if err != nil {
-		us.log.WithField("request: ", utils.StructToString(req)).WithError(err).Errorf("LoginUser | Failed to login, failed to generate jwt token")
+		us.log.WithContext(ctx).WithField("user_id", userData.GetUserId()).WithError(err).Errorf("LoginUser | Failed to login, failed to create session")
 		return &users.LoginResponse{
 			ResponseMap: map[string]string{
 				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
@@ -250,17 +334,15 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 		}, err
 	}
 
+	us.clearLoginFailures(ctx, userData.GetUserId())
+	result, reason = metrics.ResultSuccess, metrics.ReasonNone
+
 	return &users.LoginResponse{
 		UserId:         userData.GetUserId(),
 		Username:       userData.GetUsername(),
 		Email:          userData.GetEmail(),
 		ProfilePicture: "",
-		JwtAccess: &users.JWTAccess{
-			AccessToken:        accessToken,
-			AccessTokenExpired: generateTime.Add(time.Duration(us.conf.Authorization.JWT.AccessTokenDuration) * time.Minute).Format(time.RFC3339),
-			RenewToken:         renewToken,
-			RenewTokenExpired:  generateTime.Add(time.Duration(us.conf.Authorization.JWT.RefreshTokenDuration) * time.Minute).Format(time.RFC3339),
-		},
+		JwtAccess:      newJWTAccess(tokenPair),
 		ResponseMap: map[string]string{
 			"en": "Login Successfully.",
 			"id": "Berhasil Login",
@@ -270,11 +352,11 @@ func (us *UserService) LoginV1(ctx context.Context, req *users.PayloadWithSingle
 
 // GetUser implements the GetUser method of the grpc UsersServer interface to fetch an user for a given userID
 func (us *UserService) GetUser(ctx context.Context, req *users.PayloadWithUserID) (*users.PayloadWithSingleUser, error) {
-	log.Printf("Received get user request")
+	us.log.WithContext(ctx).Info("Received get user request")
 
 	user, err := us.db.GetUserByID(ctx, req.GetUserId())
 	if err != nil {
-		us.log.WithField("request: ", req).WithError(err).Errorf("GetUser | Failed to get data user")
+		us.log.WithContext(ctx).WithField("request: ", req).WithError(err).Errorf("GetUser | Failed to get data user")
 		return &users.PayloadWithSingleUser{
 			User: user,
 			ResponseMap: map[string]string{
@@ -302,20 +384,374 @@ func (us *UserService) GetUser(ctx context.Context, req *users.PayloadWithUserID
 	}, nil
 }
 
-// UpdateUser implements the UpdateUser method of the grpc usersServer interface to update an user
-func (o *UserService) UpdateUser(_ context.Context, req *users.PayloadWithSingleUser) (*users.Empty, error) {
-	log.Printf("Received an update user request")
+// UpdateUser implements the UpdateUser method of the grpc usersServer interface to update the fields of an user listed in the update mask
+func (us *UserService) UpdateUser(ctx context.Context, req *users.UpdateUserRequest) (*users.PayloadWithSingleUser, error) {
+	us.log.WithContext(ctx).Info("Received an update user request")
 
-	o.db.UpdateUser(req.GetUser())
+	//validate input
+	message := userValidate.ValidateUserUpdate(req)
+	if message != nil {
+		return &users.PayloadWithSingleUser{
+			ResponseMap: message,
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
 
-	return &users.Empty{}, nil
+	fields := req.GetUpdateMask().GetPaths()
+
+	//support staff update anyone with the admin key, an user updates itself with its access token
+	var updatedBy string
+	if middleware.IsAdmin(ctx) {
+		updatedBy = middleware.GetAdminID(ctx)
+		if updatedBy == "" {
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "The admin updating the user is required.",
+					"id": "Admin yang mengubah pengguna wajib diisi.",
+				},
+			}, status.Errorf(codes.InvalidArgument, "%s is required", general.APIHeaderAdminID)
+		}
+	} else {
+		credential, _, err := us.authorize(ctx)
+		if err != nil {
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Unauthorized, please login again.",
+					"id": "Tidak memiliki akses, silakan login kembali.",
+				},
+			}, err
+		}
+
+		if credential.GetId() != req.User.GetUserId() {
+			us.log.WithContext(ctx).WithField("user_id", credential.GetId()).Errorf("UpdateUser | Failed to update user, user can only update itself")
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "You are not allowed to update this user.",
+					"id": "Anda tidak diizinkan mengubah pengguna ini.",
+				},
+			}, status.Error(codes.PermissionDenied, "not allowed to update user")
+		}
+
+		//an user cannot reactivate the account staff deactivated
+		if slices.Contains(fields, "is_active") {
+			us.log.WithContext(ctx).WithField("user_id", credential.GetId()).Errorf("UpdateUser | Failed to update user, is_active is only updated by an admin")
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "You are not allowed to change the status of this account.",
+					"id": "Anda tidak diizinkan mengubah status akun ini.",
+				},
+			}, status.Error(codes.PermissionDenied, "is_active is only updated by an admin")
+		}
+
+		updatedBy = strconv.FormatUint(credential.GetId(), 10)
+	}
+
+	user := &users.User{
+		UserId:    req.User.GetUserId(),
+		Version:   req.User.GetVersion(),
+		UpdatedBy: updatedBy,
+	}
+
+	//only the masked fields are taken from the request
+	for _, field := range fields {
+		switch field {
+		case "username":
+			user.Username = req.User.GetUsername()
+		case "email":
+			user.Email = req.User.GetEmail()
+		case "is_active":
+			user.IsActive = req.User.GetIsActive()
+		}
+	}
+
+	//check Username and email isexist on other user
+	if user.Username != "" || user.Email != "" {
+		isExist, err := us.db.CheckIsExistOtherUser(ctx, user)
+		if err != nil {
+			us.log.WithContext(ctx).WithField("request: ", utils.StructToString(user)).WithError(err).Errorf("UpdateUser | Failed to check is exist user")
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+					"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+				},
+			}, err
+		}
+
+		if isExist {
+			us.log.WithContext(ctx).WithField("request: ", utils.StructToString(user)).Errorf("UpdateUser | Failed to update user, username or email already exists")
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Failed to update user, username or email already exists.",
+					"id": "Gagal mengubah pengguna, nama pengguna atau email sudah ada.",
+				},
+			}, status.Error(codes.AlreadyExists, "username or email already exists")
+		}
+	}
+
+	//start transaction db
+	txUser, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBBegin").WithError(err).Errorf("UpdateUser | Failed to txUserBegin")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	_, err = us.db.UpdateUser(ctx, txUser, user, fields)
+	if err != nil {
+		txUser.Rollback()
+		if errors.Is(err, db.ErrStaleUser) {
+			us.log.WithContext(ctx).WithField("request: ", utils.StructToString(user)).WithError(err).Errorf("UpdateUser | Failed to update user, version is stale")
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Data has been changed by another request, please reload and try again.",
+					"id": "Data telah diubah oleh permintaan lain, silakan muat ulang dan coba lagi.",
+				},
+			}, status.Error(codes.Aborted, err.Error())
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Data not found.",
+					"id": "Data tidak ditemukan.",
+				},
+			}, status.Error(codes.NotFound, "user not found")
+		}
+
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(user)).WithError(err).Errorf("UpdateUser | Failed to update user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//only the updated fields are sent, an email change or a deactivation is seen from them
+	data := event.UserData{
+		UserID: user.UserId,
+		Fields: fields,
+		By:     user.UpdatedBy,
+	}
+	for _, field := range fields {
+		switch field {
+		case "username":
+			data.Username = user.Username
+		case "email":
+			data.Email = user.Email
+		case "is_active":
+			isActive := user.IsActive
+			data.IsActive = &isActive
+		}
+	}
+
+	err = us.saveUserEvent(ctx, txUser, event.TypeUserUpdated, data)
+	if err != nil {
+		txUser.Rollback()
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("UpdateUser | Failed to save user event")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = txUser.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBCommit").WithError(err).Errorf("UpdateUser | Failed to txUserCommit")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//a deactivated user cannot keep its sessions
+	if slices.Contains(fields, "is_active") && !user.IsActive {
+		_, err = us.db.RevokeAllSessions(ctx, nil, user.UserId)
+		if err != nil {
+			us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("UpdateUser | Failed to revoke sessions")
+		}
+	}
+
+	updated, err := us.db.GetUserByID(ctx, user.UserId)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", utils.StructToString(user)).WithError(err).Errorf("UpdateUser | Failed to get data user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.PayloadWithSingleUser{
+		User: updated,
+		ResponseMap: map[string]string{
+			"en": "Successfully updated data",
+			"id": "Berhasil mengubah data",
+		},
+	}, nil
 }
 
-// RemoveUser implements the RemoveUser method of the grpc usersServer interface to remove an user
-func (o *UserService) RemoveUser(_ context.Context, req *users.PayloadWithUserID) (*users.Empty, error) {
-	log.Printf("Received a remove user request")
+// RemoveUser implements the RemoveUser method of the grpc usersServer interface to soft delete an user
+func (us *UserService) RemoveUser(ctx context.Context, req *users.PayloadWithUserID) (*users.Empty, error) {
+	us.log.WithContext(ctx).Info("Received a remove user request")
+
+	credential, _, err := us.authorize(ctx)
+	if err != nil {
+		return &users.Empty{}, err
+	}
+
+	if credential.GetId() != req.GetUserId() {
+		us.log.WithContext(ctx).WithField("user_id", credential.GetId()).Errorf("RemoveUser | Failed to remove user, user can only remove itself")
+		return &users.Empty{}, status.Error(codes.PermissionDenied, "not allowed to remove user")
+	}
+
+	deletedBy := strconv.FormatUint(credential.GetId(), 10)
+
+	//start transaction db
+	txUser, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBBegin").WithError(err).Errorf("RemoveUser | Failed to txUserBegin")
+		return &users.Empty{}, err
+	}
+
+	err = us.db.RemoveUser(ctx, txUser, req.GetUserId(), deletedBy)
+	if err != nil {
+		txUser.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.Empty{}, status.Error(codes.NotFound, "user not found")
+		}
 
-	o.db.RemoveUser(req.GetUserId())
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RemoveUser | Failed to remove user")
+		return &users.Empty{}, err
+	}
+
+	err = us.saveUserEvent(ctx, txUser, event.TypeUserDeleted, event.UserData{
+		UserID: req.GetUserId(),
+		By:     deletedBy,
+	})
+	if err != nil {
+		txUser.Rollback()
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RemoveUser | Failed to save user event")
+		return &users.Empty{}, err
+	}
+
+	//commit transaction db
+	err = txUser.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionUserDBCommit").WithError(err).Errorf("RemoveUser | Failed to txUserCommit")
+		return &users.Empty{}, err
+	}
+
+	//a deleted user cannot keep its sessions
+	_, err = us.db.RevokeAllSessions(ctx, nil, req.GetUserId())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RemoveUser | Failed to revoke sessions")
+	}
 
 	return &users.Empty{}, nil
 }
+
+// RestoreUser implements the RestoreUser method of the grpc usersServer interface to restore a soft deleted user
+func (us *UserService) RestoreUser(ctx context.Context, req *users.PayloadWithUserID) (*users.PayloadWithSingleUser, error) {
+	us.log.WithContext(ctx).Info("Received a restore user request")
+
+	//the admin key is shared, the operator restoring the user is named by x-admin-id
+	restoredBy := middleware.GetAdminID(ctx)
+	if restoredBy == "" {
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "The admin restoring the user is required.",
+				"id": "Admin yang memulihkan pengguna wajib diisi.",
+			},
+		}, status.Errorf(codes.InvalidArgument, "%s is required", general.APIHeaderAdminID)
+	}
+
+	deleted, err := us.db.GetDeletedUserByID(ctx, req.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Data not found.",
+					"id": "Data tidak ditemukan.",
+				},
+			}, status.Error(codes.NotFound, "deleted user not found")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RestoreUser | Failed to get deleted user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//the username or email may have been taken while the user was deleted
+	isExist, err := us.db.CheckIsExistOtherUser(ctx, deleted)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RestoreUser | Failed to check is exist user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	if isExist {
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "Failed to restore user, username or email already used by another user.",
+				"id": "Gagal memulihkan pengguna, nama pengguna atau email sudah digunakan pengguna lain.",
+			},
+		}, status.Error(codes.AlreadyExists, "username or email already exists")
+	}
+
+	err = us.db.RestoreUser(ctx, nil, req.GetUserId(), restoredBy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &users.PayloadWithSingleUser{
+				ResponseMap: map[string]string{
+					"en": "Data not found.",
+					"id": "Data tidak ditemukan.",
+				},
+			}, status.Error(codes.NotFound, "deleted user not found")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RestoreUser | Failed to restore user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	user, err := us.db.GetUserByID(ctx, req.GetUserId())
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", req.GetUserId()).WithError(err).Errorf("RestoreUser | Failed to get data user")
+		return &users.PayloadWithSingleUser{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.PayloadWithSingleUser{
+		User: user,
+		ResponseMap: map[string]string{
+			"en": "User successfully restored",
+			"id": "Pengguna berhasil dipulihkan",
+		},
+	}, nil
+}
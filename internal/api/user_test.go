@@ -0,0 +1,554 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/notify"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const testPassword = "S3cret-passw0rd"
+
+// newTestService returns the user service on an empty in-memory repository
+func newTestService(t *testing.T, conf *infra.AppService) (*UserService, *db.MemoryDB) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	if conf == nil {
+		conf = &infra.AppService{}
+	}
+	conf.KeyData.User = "0123456789abcdef0123456789abcdef"
+	conf.Authorization.JWT = infra.JWTCredential{
+		IsActive:              true,
+		AccessTokenSecretKey:  "access-token-secret-key",
+		AccessTokenDuration:   5,
+		RefreshTokenSecretKey: "refresh-token-secret-key",
+		RefreshTokenDuration:  1,
+	}
+
+	err := infra.InitJWTConfig(conf.Authorization.JWT)
+	if err != nil {
+		t.Fatalf("InitJWTConfig: %v", err)
+	}
+
+	memory := db.NewMemoryDB(logger)
+	infra.InitSessionValidator(memory)
+
+	us := NewUserService(memory, notify.NewLogNotifier(logger), nil, logger, infra.NewConfigStore(nil, conf, logger))
+
+	return &us, memory
+}
+
+// register adds an user through RegistrationUser
+func register(t *testing.T, us *UserService, username, email string) {
+	t.Helper()
+
+	_, err := us.RegistrationUser(context.Background(), &users.PayloadWithSingleUser{User: &users.User{
+		Username:   username,
+		Email:      email,
+		Password:   testPassword,
+		Repassword: testPassword,
+	}})
+	if err != nil {
+		t.Fatalf("RegistrationUser(%s): %v", username, err)
+	}
+}
+
+// login logs in with email & password, failing the test on error
+func login(t *testing.T, us *UserService, email, password string) *users.LoginResponse {
+	t.Helper()
+
+	res, err := us.LoginV1(context.Background(), &users.PayloadWithSingleUser{User: &users.User{Email: email, Password: password}})
+	if err != nil {
+		t.Fatalf("LoginV1(%s): %v", email, err)
+	}
+
+	return res
+}
+
+func TestRegistrationUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *users.User
+		wantErr bool
+		wantEn  string
+	}{
+		{
+			name:   "new user",
+			user:   &users.User{Username: "alice", Email: "alice@example.com", Password: testPassword, Repassword: testPassword},
+			wantEn: "Account successfully created, please check your email to verify it.",
+		},
+		{
+			name:    "invalid email",
+			user:    &users.User{Username: "bob", Email: "not-an-email", Password: testPassword, Repassword: testPassword},
+			wantErr: true,
+			wantEn:  "Incorrect email format",
+		},
+		{
+			name:   "username taken",
+			user:   &users.User{Username: "taken", Email: "other@example.com", Password: testPassword, Repassword: testPassword},
+			wantEn: "Failed to create user, username or email already exists.",
+		},
+		{
+			name:   "email taken",
+			user:   &users.User{Username: "other", Email: "taken@example.com", Password: testPassword, Repassword: testPassword},
+			wantEn: "Failed to create user, username or email already exists.",
+		},
+		{
+			name:   "passwords differ",
+			user:   &users.User{Username: "carol", Email: "carol@example.com", Password: testPassword, Repassword: "another-password"},
+			wantEn: "Password and re-password are not the same.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			us, _ := newTestService(t, nil)
+			register(t, us, "taken", "taken@example.com")
+
+			res, err := us.RegistrationUser(context.Background(), &users.PayloadWithSingleUser{User: tt.user})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RegistrationUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := res.GetResponseMap()["en"]; got != tt.wantEn {
+				t.Errorf("RegistrationUser() message = %q, want %q", got, tt.wantEn)
+			}
+		})
+	}
+}
+
+func TestRegistrationUserStoresHashedPassword(t *testing.T) {
+	us, memory := newTestService(t, nil)
+	register(t, us, "alice", "alice@example.com")
+
+	user, err := memory.GetUserByEmailOrUsername(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmailOrUsername: %v", err)
+	}
+	if user.GetPassword() == "" || user.GetPassword() == testPassword {
+		t.Errorf("password stored as %q, want a bcrypt hash", user.GetPassword())
+	}
+	if !user.GetIsActive() {
+		t.Errorf("registered user is not active")
+	}
+}
+
+func TestLoginV1(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, _ := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+
+	t.Run("by email", func(t *testing.T) {
+		res := login(t, us, "alice@example.com", testPassword)
+		if res.GetUsername() != "alice" || res.GetUserId() == 0 {
+			t.Errorf("LoginV1() user = %d %q, want alice", res.GetUserId(), res.GetUsername())
+		}
+		if res.GetJwtAccess().GetAccessToken() == "" || res.GetJwtAccess().GetRenewToken() == "" {
+			t.Errorf("LoginV1() returned no token pair")
+		}
+
+		_, err := infra.ParseRenewToken(res.GetJwtAccess().GetRenewToken())
+		if err != nil {
+			t.Errorf("renew token does not parse: %v", err)
+		}
+	})
+
+	t.Run("by username", func(t *testing.T) {
+		res := login(t, us, "alice", testPassword)
+		if res.GetUsername() != "alice" {
+			t.Errorf("LoginV1() user = %q, want alice", res.GetUsername())
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		res := login(t, us, "alice@example.com", "wrong-password")
+		if res.GetJwtAccess() != nil {
+			t.Errorf("LoginV1() returned a token pair for a wrong password")
+		}
+		if got := res.GetResponseMap()["en"]; got != "Login Failed, password is incorrect" {
+			t.Errorf("LoginV1() message = %q", got)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		res := login(t, us, "nobody@example.com", testPassword)
+		if res.GetJwtAccess() != nil {
+			t.Errorf("LoginV1() returned a token pair for an unknown user")
+		}
+	})
+
+	t.Run("empty password", func(t *testing.T) {
+		_, err := us.LoginV1(context.Background(), &users.PayloadWithSingleUser{User: &users.User{Email: "alice@example.com"}})
+		if err == nil {
+			t.Errorf("LoginV1() accepted an empty password")
+		}
+	})
+}
+
+func TestLoginV1RefusesUnverifiedEmail(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyRefuse
+
+	us, _ := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+
+	_, err := us.LoginV1(context.Background(), &users.PayloadWithSingleUser{User: &users.User{Email: "alice@example.com", Password: testPassword}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("LoginV1() error = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestLoginV1LocksAccount(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+	conf.User.Lockout = infra.LockoutUser{Threshold: 2, BaseDelay: 60, MaxDelay: 600, Window: 15}
+
+	us, _ := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+
+	for i := 0; i < 2; i++ {
+		login(t, us, "alice@example.com", "wrong-password")
+	}
+
+	_, err := us.LoginV1(context.Background(), &users.PayloadWithSingleUser{User: &users.User{Email: "alice@example.com", Password: testPassword}})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("LoginV1() error = %v, want ResourceExhausted once locked", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, _ := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+	first := login(t, us, "alice@example.com", testPassword).GetJwtAccess()
+
+	refresh := func(renewToken string) (*users.RefreshTokenResponse, error) {
+		return us.RefreshToken(context.Background(), &users.RefreshTokenRequest{RenewToken: renewToken})
+	}
+
+	second, err := refresh(first.GetRenewToken())
+	if err != nil {
+		t.Fatalf("RefreshToken(): %v", err)
+	}
+	if second.GetJwtAccess().GetRenewToken() == "" || second.GetJwtAccess().GetRenewToken() == first.GetRenewToken() {
+		t.Fatalf("RefreshToken() did not rotate the renew token")
+	}
+
+	third, err := refresh(second.GetJwtAccess().GetRenewToken())
+	if err != nil {
+		t.Fatalf("RefreshToken() with the rotated token: %v", err)
+	}
+
+	// replaying a rotated token revokes the whole family, the latest token included
+	_, err = refresh(first.GetRenewToken())
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken() replay error = %v, want Unauthenticated", err)
+	}
+
+	_, err = refresh(third.GetJwtAccess().GetRenewToken())
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken() after replay error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	us, _ := newTestService(t, nil)
+
+	tests := []struct {
+		name       string
+		renewToken string
+		wantCode   codes.Code
+	}{
+		{name: "empty", renewToken: "", wantCode: codes.Unknown},
+		{name: "garbage", renewToken: "not-a-jwt", wantCode: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := us.RefreshToken(context.Background(), &users.RefreshTokenRequest{RenewToken: tt.renewToken})
+			if err == nil || status.Code(err) != tt.wantCode {
+				t.Errorf("RefreshToken() error = %v, want %v", err, tt.wantCode)
+			}
+			if res.GetJwtAccess() != nil {
+				t.Errorf("RefreshToken() returned a token pair")
+			}
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	us, memory := newTestService(t, nil)
+	register(t, us, "alice", "alice@example.com")
+
+	user, err := memory.GetUserByEmailOrUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByEmailOrUsername: %v", err)
+	}
+
+	err = memory.RemoveUser(context.Background(), nil, user.GetUserId(), "alice")
+	if err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+
+	req := &users.PayloadWithUserID{UserId: user.GetUserId()}
+
+	_, err = us.RestoreUser(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RestoreUser() without an admin id error = %v, want InvalidArgument", err)
+	}
+
+	ctx := context.WithValue(context.Background(), general.AdminIDContextKey, "support-bob")
+	res, err := us.RestoreUser(ctx, req)
+	if err != nil {
+		t.Fatalf("RestoreUser(): %v", err)
+	}
+	if got := res.GetUser().GetUpdatedBy(); got != "support-bob" {
+		t.Errorf("RestoreUser() updated_by = %q, want the admin id support-bob", got)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, _ := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+	first := login(t, us, "alice@example.com", testPassword)
+	login(t, us, "alice@example.com", testPassword)
+
+	_, err := us.RevokeUserSessions(context.Background(), &users.PayloadWithUserID{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RevokeUserSessions() without user id error = %v, want InvalidArgument", err)
+	}
+
+	res, err := us.RevokeUserSessions(context.Background(), &users.PayloadWithUserID{UserId: first.GetUserId()})
+	if err != nil {
+		t.Fatalf("RevokeUserSessions(): %v", err)
+	}
+	if res.GetRevokedSessions() != 2 {
+		t.Errorf("RevokeUserSessions() revoked %d sessions, want 2", res.GetRevokedSessions())
+	}
+
+	_, err = us.RefreshToken(context.Background(), &users.RefreshTokenRequest{RenewToken: first.GetJwtAccess().GetRenewToken()})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken() after the revocation error = %v, want Unauthenticated", err)
+	}
+}
+
+// userContext returns the context the Auth interceptor gives to a rpc called with an access token of userID
+func userContext(userID uint64) context.Context {
+	ctx := context.WithValue(context.Background(), general.SessionContextKey, &users.CredentialData{Id: userID})
+	return context.WithValue(ctx, general.SessionIDContextKey, "session")
+}
+
+// adminContext returns the context the Auth interceptor gives to a rpc called with the admin key by adminID
+func adminContext(adminID string) context.Context {
+	ctx := context.WithValue(context.Background(), general.AdminContextKey, true)
+	return context.WithValue(ctx, general.AdminIDContextKey, adminID)
+}
+
+// getUser returns the stored user of a login, failing the test on error
+func getUser(t *testing.T, memory *db.MemoryDB, login string) *users.User {
+	t.Helper()
+
+	user, err := memory.GetUserByEmailOrUsername(context.Background(), login)
+	if err != nil {
+		t.Fatalf("GetUserByEmailOrUsername(%s): %v", login, err)
+	}
+
+	return user
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		admin    bool
+		user     func(alice *users.User) *users.User
+		paths    []string
+		wantCode codes.Code
+	}{
+		{
+			name: "username",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, Username: "alice2"}
+			},
+			paths: []string{"username"},
+		},
+		{
+			name: "stale version",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version - 1, Username: "alice2"}
+			},
+			paths:    []string{"username"},
+			wantCode: codes.Aborted,
+		},
+		{
+			name: "unknown mask path",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, Password: "changed"}
+			},
+			paths:    []string{"password"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "username of another user",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, Username: "bob"}
+			},
+			paths:    []string{"username"},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "email of another user",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, Email: "bob@example.com"}
+			},
+			paths:    []string{"email"},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "another user",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId + 1, Version: 1, Username: "bob2"}
+			},
+			paths:    []string{"username"},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "own is_active",
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, IsActive: false}
+			},
+			paths:    []string{"is_active"},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:  "is_active by an admin",
+			admin: true,
+			user: func(alice *users.User) *users.User {
+				return &users.User{UserId: alice.UserId, Version: alice.Version, IsActive: false}
+			},
+			paths: []string{"is_active"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			us, memory := newTestService(t, nil)
+			register(t, us, "alice", "alice@example.com")
+			register(t, us, "bob", "bob@example.com")
+			alice := getUser(t, memory, "alice")
+
+			ctx := userContext(alice.UserId)
+			if tt.admin {
+				ctx = adminContext("support-carol")
+			}
+
+			user := tt.user(alice)
+			_, err := us.UpdateUser(ctx, &users.UpdateUserRequest{User: user, UpdateMask: &fieldmaskpb.FieldMask{Paths: tt.paths}})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("UpdateUser() error = %v, want %v", err, tt.wantCode)
+			}
+
+			stored := getUser(t, memory, "alice@example.com")
+			if tt.wantCode != codes.OK {
+				if stored.Version != alice.Version {
+					t.Errorf("UpdateUser() refused but changed the user to version %d", stored.Version)
+				}
+				return
+			}
+
+			if stored.Version != alice.Version+1 {
+				t.Errorf("UpdateUser() version = %d, want %d", stored.Version, alice.Version+1)
+			}
+			if user.Username != "" && stored.Username != user.Username {
+				t.Errorf("UpdateUser() username = %q, want %q", stored.Username, user.Username)
+			}
+			if tt.admin && (stored.IsActive || stored.UpdatedBy != "support-carol") {
+				t.Errorf("UpdateUser() by an admin = active %v by %q, want inactive by support-carol", stored.IsActive, stored.UpdatedBy)
+			}
+		})
+	}
+}
+
+func TestUpdateUserEmailClearsVerification(t *testing.T) {
+	us, memory := newTestService(t, nil)
+	register(t, us, "alice", "alice@example.com")
+	alice := getUser(t, memory, "alice")
+
+	err := memory.VerifyEmail(context.Background(), nil, alice.UserId)
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	alice = getUser(t, memory, "alice")
+	if alice.GetEmailVerifiedAt() == nil {
+		t.Fatalf("email not verified")
+	}
+
+	res, err := us.UpdateUser(userContext(alice.UserId), &users.UpdateUserRequest{
+		User:       &users.User{UserId: alice.UserId, Version: alice.Version, Email: "alice@example.org"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser(): %v", err)
+	}
+	if res.GetUser().GetEmail() != "alice@example.org" || res.GetUser().GetEmailVerifiedAt() != nil {
+		t.Errorf("UpdateUser() = %q verified at %v, want alice@example.org unverified", res.GetUser().GetEmail(), res.GetUser().GetEmailVerifiedAt())
+	}
+}
+
+func TestUpdateUserDeactivationRevokesSessions(t *testing.T) {
+	conf := &infra.AppService{}
+	conf.User.Verification.LoginPolicy = infra.LoginPolicyAllow
+
+	us, memory := newTestService(t, conf)
+	register(t, us, "alice", "alice@example.com")
+	accessToken := login(t, us, "alice@example.com", testPassword).GetJwtAccess().GetAccessToken()
+	alice := getUser(t, memory, "alice")
+
+	_, err := infra.CheckAccessToken(context.Background(), accessToken)
+	if err != nil {
+		t.Fatalf("CheckAccessToken() before the deactivation: %v", err)
+	}
+
+	_, err = us.UpdateUser(adminContext("support-carol"), &users.UpdateUserRequest{
+		User:       &users.User{UserId: alice.UserId, Version: alice.Version, IsActive: false},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"is_active"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser(): %v", err)
+	}
+
+	_, err = infra.CheckAccessToken(context.Background(), accessToken)
+	if err == nil {
+		t.Errorf("CheckAccessToken() accepted the access token of a deactivated user")
+	}
+}
+
+func TestUpdateUserByAdminNeedsAdminID(t *testing.T) {
+	us, memory := newTestService(t, nil)
+	register(t, us, "alice", "alice@example.com")
+	alice := getUser(t, memory, "alice")
+
+	_, err := us.UpdateUser(adminContext(""), &users.UpdateUserRequest{
+		User:       &users.User{UserId: alice.UserId, Version: alice.Version, IsActive: false},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"is_active"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateUser() without an admin id error = %v, want InvalidArgument", err)
+	}
+}
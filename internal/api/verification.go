@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/notify"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultVerificationTTL = 24 * time.Hour
+
+// VerifyEmail implements the VerifyEmail method of the grpc UsersServer interface to verify the email of a user with the token sent after registration
+func (us *UserService) VerifyEmail(ctx context.Context, req *users.VerifyEmailRequest) (*users.VerifyEmailResponse, error) {
+	us.log.WithContext(ctx).Info("Received a verify email request")
+
+	if req.GetToken() == "" {
+		return &users.VerifyEmailResponse{
+			ResponseMap: map[string]string{
+				"en": "Token cannot be empty",
+				"id": "Token tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	invalidToken := &users.VerifyEmailResponse{
+		ResponseMap: map[string]string{
+			"en": "Verification token is invalid or expired, please request a new one.",
+			"id": "Token verifikasi tidak valid atau sudah kedaluwarsa, silakan minta token baru.",
+		},
+	}
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionVerifyEmailDBBegin").WithError(err).Errorf("VerifyEmail | Failed to txBegin")
+		return &users.VerifyEmailResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	userID, err := us.db.UseUserToken(ctx, tx, utils.Hash256(req.GetToken()), db.TokenPurposeEmailVerification)
+	if err == nil {
+		err = us.db.VerifyEmail(ctx, tx, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return invalidToken, status.Error(codes.InvalidArgument, "verification token invalid")
+		}
+
+		us.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("VerifyEmail | Failed to verify email")
+		return &users.VerifyEmailResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		us.log.WithContext(ctx).WithField("request: ", "transactionVerifyEmailDBCommit").WithError(err).Errorf("VerifyEmail | Failed to txCommit")
+		return &users.VerifyEmailResponse{
+			ResponseMap: map[string]string{
+				"en": "There is an error in the system, please wait for a while our team will fix it immediately.",
+				"id": "Terdapat kesalahan pada sistem, mohon tunggu beberapa saat tim kami akan segera memperbaikinya.",
+			},
+		}, err
+	}
+
+	return &users.VerifyEmailResponse{
+		ResponseMap: map[string]string{
+			"en": "Email successfully verified.",
+			"id": "Email berhasil diverifikasi.",
+		},
+	}, nil
+}
+
+// ResendVerification implements the ResendVerification method of the grpc UsersServer interface to send a new verification token
+//
+// The response is the same whether the user exists or not, so it cannot be used to find registered emails
+func (us *UserService) ResendVerification(ctx context.Context, req *users.ResendVerificationRequest) (*users.VerifyEmailResponse, error) {
+	us.log.WithContext(ctx).Info("Received a resend verification request")
+
+	if req.GetLogin() == "" {
+		return &users.VerifyEmailResponse{
+			ResponseMap: map[string]string{
+				"en": "Email or username cannot be empty",
+				"id": "Email atau username tidak boleh kosong",
+			},
+		}, status.Error(codes.InvalidArgument, "data not valid")
+	}
+
+	sent := &users.VerifyEmailResponse{
+		ResponseMap: map[string]string{
+			"en": "If the account exists and is not verified yet, a verification link has been sent to its email.",
+			"id": "Jika akun terdaftar dan belum diverifikasi, tautan verifikasi telah dikirim ke emailnya.",
+		},
+	}
+
+	user, err := us.db.GetUserByEmailOrUsername(ctx, req.GetLogin())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			us.log.WithContext(ctx).WithError(err).Errorf("ResendVerification | Failed to get data user")
+		}
+
+		return sent, nil
+	}
+
+	if !user.IsActive || user.EmailVerifiedAt != nil {
+		return sent, nil
+	}
+
+	err = us.sendVerification(ctx, user)
+	if err != nil {
+		us.log.WithContext(ctx).WithField("user_id", user.UserId).WithError(err).Errorf("ResendVerification | Failed to send verification")
+	}
+
+	return sent, nil
+}
+
+// sendVerification replaces the pending verification token of a user and sends the new one by email
+func (us *UserService) sendVerification(ctx context.Context, user *users.User) error {
+	ttl := time.Duration(us.conf().User.Verification.TTL) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultVerificationTTL
+	}
+
+	token := utils.GenerateSecretToken()
+
+	//start transaction db
+	tx, err := us.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// only the latest verification link is valid
+	err = us.db.InvalidateUserTokens(ctx, tx, user.UserId, db.TokenPurposeEmailVerification)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = us.db.SaveUserToken(ctx, tx, &db.UserToken{
+		TokenHash: utils.Hash256(token),
+		UserID:    user.UserId,
+		Purpose:   db.TokenPurposeEmailVerification,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return us.notifier.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Open %s?token=%s to verify your email. The link expires in %d minutes.", us.conf().User.Verification.URL, url.QueryEscape(token), int(ttl.Minutes())),
+	})
+}
+
+// checkEmailVerified applies the login policy for unverified emails, it returns the refusal message or nil
+func (us *UserService) checkEmailVerified(user *users.User) map[string]string {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	verification := us.conf().User.Verification
+
+	switch verification.LoginPolicy {
+	case "", infra.LoginPolicyAllow:
+		return nil
+	case infra.LoginPolicyGrace:
+		deadline := user.GetCreatedAt().AsTime().Add(time.Duration(verification.GracePeriod) * time.Hour)
+		if time.Now().UTC().Before(deadline) {
+			return nil
+		}
+	}
+
+	return map[string]string{
+		"en": "Login Failed, please verify your email first.",
+		"id": "Gagal login, silakan verifikasi email Anda terlebih dahulu.",
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache drivers
+const (
+	DriverNone   = "none"
+	DriverRedis  = "redis"
+	DriverMemory = "memory"
+)
+
+// ErrMiss is returned by Get when the key is not cached or expired
+var ErrMiss = errors.New("cache: miss")
+
+// Cache stores values by key for a limited time
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// New returns the cache selected by CACHE.DRIVER, nil when the cache is disabled
+// The redis driver needs the client of infra.NewRedisClient
+func New(conf infra.CacheConfig, client *redis.Client) (Cache, error) {
+	switch conf.Driver {
+	case "", DriverNone:
+		return nil, nil
+	case DriverRedis:
+		if client == nil {
+			return nil, fmt.Errorf("cache driver redis needs REDIS.URL")
+		}
+
+		return NewRedisCache(client), nil
+	case DriverMemory:
+		return NewMemoryCache(), nil
+	}
+
+	return nil, fmt.Errorf("unknown cache driver %s", conf.Driver)
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySweepInterval = time.Minute
+
+// MemoryCache keeps the values in process, for a single instance or local development only
+// Other instances never see its invalidations
+type MemoryCache struct {
+	mu        sync.Mutex
+	items     map[string]memoryItem
+	lastSweep time.Time
+}
+
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates an empty in-process cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		items:     make(map[string]memoryItem),
+		lastSweep: time.Now(),
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || !item.expiresAt.After(time.Now()) {
+		return nil, ErrMiss
+	}
+
+	return append([]byte(nil), item.value...), nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	c.items[key] = memoryItem{
+		value:     append([]byte(nil), value...),
+		expiresAt: now.Add(ttl),
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+
+	return nil
+}
+
+// sweep removes the expired values, c.mu must be held
+func (c *MemoryCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < memorySweepInterval {
+		return
+	}
+
+	for key, item := range c.items {
+		if !item.expiresAt.After(now) {
+			delete(c.items, key)
+		}
+	}
+
+	c.lastSweep = now
+}
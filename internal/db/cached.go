@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/cache"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultUserCacheTTL = 5 * time.Minute
+
+// CachedRepository reads the users by id through a cache in front of another Repository
+// The writes on a user invalidate its entry, again after the commit when they run in a transaction,
+// a read racing a write may still serve the old user until the ttl expires
+type CachedRepository struct {
+	Repository
+	cache cache.Cache
+	ttl   time.Duration
+	log   *logrus.Logger
+}
+
+// NewCachedRepository wraps repo with a read-through user cache, users stay cached for ttl
+func NewCachedRepository(repo Repository, c cache.Cache, ttl time.Duration, logger *logrus.Logger) *CachedRepository {
+	if ttl <= 0 {
+		ttl = defaultUserCacheTTL
+	}
+
+	return &CachedRepository{
+		Repository: repo,
+		cache:      c,
+		ttl:        ttl,
+		log:        logger,
+	}
+}
+
+// cachedTx invalidates the users written in the transaction once it is committed
+type cachedTx struct {
+	Tx
+	ctx  context.Context
+	repo *CachedRepository
+	keys []string
+}
+
+func (t *cachedTx) Unwrap() Tx {
+	return t.Tx
+}
+
+func (t *cachedTx) Commit() error {
+	err := t.Tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	t.repo.invalidate(t.ctx, t.keys...)
+
+	return nil
+}
+
+func (c *CachedRepository) Begin(ctx context.Context) (Tx, error) {
+	tx, err := c.Repository.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedTx{Tx: tx, ctx: ctx, repo: c}, nil
+}
+
+func userCacheKey(userID uint64) string {
+	return "user:" + strconv.FormatUint(userID, 10)
+}
+
+func (c *CachedRepository) GetUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	key := userCacheKey(userID)
+
+	data, err := c.cache.Get(ctx, key)
+	if err == nil {
+		user := &users.User{}
+		err = proto.Unmarshal(data, user)
+		if err == nil {
+			c.log.WithContext(ctx).WithField("user_id", userID).WithField("source", general.SourceFromCache).Infof("Query GetUserByID")
+			return user, nil
+		}
+	}
+	if err != nil && !errors.Is(err, cache.ErrMiss) {
+		c.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("GetUserByID | Failed to read user from cache")
+	}
+
+	user, err := c.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.WithContext(ctx).WithField("user_id", userID).WithField("source", general.SourceFromDB).Infof("Query GetUserByID")
+
+	data, err = proto.Marshal(user)
+	if err == nil {
+		err = c.cache.Set(ctx, key, data, c.ttl)
+	}
+	if err != nil {
+		c.log.WithContext(ctx).WithField("user_id", userID).WithError(err).Errorf("GetUserByID | Failed to cache user")
+	}
+
+	return user, nil
+}
+
+func (c *CachedRepository) UpdateUser(ctx context.Context, tx Tx, user *users.User, fields []string) (int64, error) {
+	defer c.invalidateUser(ctx, tx, user.GetUserId())
+	return c.Repository.UpdateUser(ctx, tx, user, fields)
+}
+
+func (c *CachedRepository) RemoveUser(ctx context.Context, tx Tx, userID uint64, deletedBy string) error {
+	defer c.invalidateUser(ctx, tx, userID)
+	return c.Repository.RemoveUser(ctx, tx, userID, deletedBy)
+}
+
+func (c *CachedRepository) RestoreUser(ctx context.Context, tx Tx, userID uint64, restoredBy string) error {
+	defer c.invalidateUser(ctx, tx, userID)
+	return c.Repository.RestoreUser(ctx, tx, userID, restoredBy)
+}
+
+func (c *CachedRepository) UpdatePassword(ctx context.Context, tx Tx, userID uint64, password string) error {
+	defer c.invalidateUser(ctx, tx, userID)
+	return c.Repository.UpdatePassword(ctx, tx, userID, password)
+}
+
+func (c *CachedRepository) VerifyEmail(ctx context.Context, tx Tx, userID uint64) error {
+	defer c.invalidateUser(ctx, tx, userID)
+	return c.Repository.VerifyEmail(ctx, tx, userID)
+}
+
+// invalidateUser drops the cached user now and, inside a transaction, once more after its commit
+func (c *CachedRepository) invalidateUser(ctx context.Context, tx Tx, userID uint64) {
+	key := userCacheKey(userID)
+
+	if cTx, ok := tx.(*cachedTx); ok && cTx != nil {
+		cTx.keys = append(cTx.keys, key)
+	}
+
+	c.invalidate(ctx, key)
+}
+
+// invalidate deletes cached entries, failures are only logged
+func (c *CachedRepository) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	err := c.cache.Delete(ctx, keys...)
+	if err != nil {
+		c.log.WithContext(ctx).WithField("keys", keys).WithError(err).Errorf("CachedRepository | Failed to invalidate cache")
+	}
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/febriandani/backend-user-service/internal/cache"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// countingRepository counts the GetUserByID reaching the repository under the cache
+type countingRepository struct {
+	Repository
+	reads int
+}
+
+func (c *countingRepository) GetUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	c.reads++
+	return c.Repository.GetUserByID(ctx, userID)
+}
+
+// newCachedTest returns a CachedRepository on an in-memory repository with a redis cache served by miniredis
+func newCachedTest(t *testing.T) (*CachedRepository, *countingRepository, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	repo := &countingRepository{Repository: NewMemoryDB(logger)}
+
+	return NewCachedRepository(repo, cache.NewRedisCache(client), time.Minute, logger), repo, mr
+}
+
+// redisKey is the key of a cached user in redis, under the prefix of cache.RedisCache
+func redisKey(userID uint64) string {
+	return "cache:" + userCacheKey(userID)
+}
+
+// saveUser stores an active user and returns its id
+func saveUser(t *testing.T, repo Repository, username string) uint64 {
+	t.Helper()
+
+	userID, err := repo.SaveUser(context.Background(), nil, &users.User{
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  "hash",
+		IsActive:  true,
+		CreatedBy: "system",
+	})
+	if err != nil {
+		t.Fatalf("SaveUser(%s): %v", username, err)
+	}
+
+	return uint64(userID)
+}
+
+func TestCachedRepositoryGetUserByID(t *testing.T) {
+	ctx := context.Background()
+	c, repo, mr := newCachedTest(t)
+	userID := saveUser(t, c, "alice")
+
+	// miss, read from the repository and cached
+	user, err := c.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserByID() miss: %v", err)
+	}
+	if user.GetUsername() != "alice" || repo.reads != 1 {
+		t.Fatalf("GetUserByID() miss = %q with %d reads, want alice with 1 read", user.GetUsername(), repo.reads)
+	}
+	if !mr.Exists(redisKey(userID)) {
+		t.Fatalf("user not cached after a miss")
+	}
+	if ttl := mr.TTL(redisKey(userID)); ttl != time.Minute {
+		t.Errorf("cached user ttl = %v, want %v", ttl, time.Minute)
+	}
+
+	// hit, the repository is not read again
+	cached, err := c.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserByID() hit: %v", err)
+	}
+	if !proto.Equal(cached, user) || repo.reads != 1 {
+		t.Errorf("GetUserByID() hit = %v with %d reads, want %v with 1 read", cached, repo.reads, user)
+	}
+
+	// expired, read from the repository again
+	mr.FastForward(time.Minute + time.Second)
+	_, err = c.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserByID() after ttl: %v", err)
+	}
+	if repo.reads != 2 {
+		t.Errorf("GetUserByID() after ttl made %d reads, want 2", repo.reads)
+	}
+}
+
+func TestCachedRepositoryGetUserByIDNotFound(t *testing.T) {
+	c, _, mr := newCachedTest(t)
+
+	_, err := c.GetUserByID(context.Background(), 42)
+	if err == nil {
+		t.Fatalf("GetUserByID() of a missing user returned no error")
+	}
+	if mr.Exists(redisKey(42)) {
+		t.Errorf("missing user was cached")
+	}
+}
+
+func TestCachedRepositoryRedisDown(t *testing.T) {
+	c, repo, mr := newCachedTest(t)
+	userID := saveUser(t, c, "alice")
+	mr.Close()
+
+	user, err := c.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetUserByID() with redis down: %v", err)
+	}
+	if user.GetUsername() != "alice" || repo.reads != 1 {
+		t.Errorf("GetUserByID() with redis down = %q with %d reads, want alice from the repository", user.GetUsername(), repo.reads)
+	}
+}
+
+func TestCachedRepositoryInvalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare brings the user in the state the write expects
+		prepare func(c *CachedRepository, userID uint64) error
+		write   func(c *CachedRepository, tx Tx, userID uint64) error
+	}{
+		{
+			name: "UpdateUser",
+			write: func(c *CachedRepository, tx Tx, userID uint64) error {
+				_, err := c.UpdateUser(context.Background(), tx, &users.User{UserId: userID, Username: "renamed", Version: 1, UpdatedBy: "admin"}, []string{"username"})
+				return err
+			},
+		},
+		{
+			name: "RemoveUser",
+			write: func(c *CachedRepository, tx Tx, userID uint64) error {
+				return c.RemoveUser(context.Background(), tx, userID, "admin")
+			},
+		},
+		{
+			name: "RestoreUser",
+			prepare: func(c *CachedRepository, userID uint64) error {
+				return c.Repository.RemoveUser(context.Background(), nil, userID, "admin")
+			},
+			write: func(c *CachedRepository, tx Tx, userID uint64) error {
+				return c.RestoreUser(context.Background(), tx, userID, "admin")
+			},
+		},
+		{
+			name: "UpdatePassword",
+			write: func(c *CachedRepository, tx Tx, userID uint64) error {
+				return c.UpdatePassword(context.Background(), tx, userID, "new-hash")
+			},
+		},
+		{
+			name: "VerifyEmail",
+			write: func(c *CachedRepository, tx Tx, userID uint64) error {
+				return c.VerifyEmail(context.Background(), tx, userID)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, mr := newCachedTest(t)
+			userID := saveUser(t, c, "alice")
+			key := redisKey(userID)
+
+			if tt.prepare != nil {
+				err := tt.prepare(c, userID)
+				if err != nil {
+					t.Fatalf("prepare: %v", err)
+				}
+			}
+
+			mr.Set(key, "stale")
+
+			err := tt.write(c, nil, userID)
+			if err != nil {
+				t.Fatalf("%s(): %v", tt.name, err)
+			}
+			if mr.Exists(key) {
+				t.Errorf("%s() left the user cached", tt.name)
+			}
+		})
+
+		t.Run(tt.name+" in transaction", func(t *testing.T) {
+			ctx := context.Background()
+			c, _, mr := newCachedTest(t)
+			userID := saveUser(t, c, "alice")
+			key := redisKey(userID)
+
+			if tt.prepare != nil {
+				err := tt.prepare(c, userID)
+				if err != nil {
+					t.Fatalf("prepare: %v", err)
+				}
+			}
+
+			tx, err := c.Begin(ctx)
+			if err != nil {
+				t.Fatalf("Begin(): %v", err)
+			}
+
+			mr.Set(key, "stale")
+
+			err = tt.write(c, tx, userID)
+			if err != nil {
+				t.Fatalf("%s(): %v", tt.name, err)
+			}
+			if mr.Exists(key) {
+				t.Errorf("%s() left the user cached before the commit", tt.name)
+			}
+
+			// a read racing the transaction caches the user again before the commit
+			mr.Set(key, "stale")
+
+			err = tx.Commit()
+			if err != nil {
+				t.Fatalf("Commit(): %v", err)
+			}
+			if mr.Exists(key) {
+				t.Errorf("Commit() after %s() left the user cached", tt.name)
+			}
+		})
+	}
+}
+
+func TestCachedTxRollbackKeepsCache(t *testing.T) {
+	ctx := context.Background()
+	c, _, mr := newCachedTest(t)
+	userID := saveUser(t, c, "alice")
+	key := redisKey(userID)
+
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin(): %v", err)
+	}
+
+	err = c.UpdatePassword(ctx, tx, userID, "new-hash")
+	if err != nil {
+		t.Fatalf("UpdatePassword(): %v", err)
+	}
+
+	// nothing was written, the entry cached after the write stays valid
+	mr.Set(key, "cached")
+
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback(): %v", err)
+	}
+	if !mr.Exists(key) {
+		t.Errorf("Rollback() invalidated the cache")
+	}
+}
+
+func TestCachedTxUnwrap(t *testing.T) {
+	c, _, _ := newCachedTest(t)
+
+	tx, err := c.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("Begin(): %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, ok := unwrapTx(tx).(*memoryTx); !ok {
+		t.Errorf("unwrapTx() = %T, want the transaction of the memory repository", unwrapTx(tx))
+	}
+}
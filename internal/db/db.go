@@ -4,8 +4,10 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/febriandani/backend-user-service/internal/infra"
@@ -14,12 +16,22 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// ErrStaleUser is returned by UpdateUser when the user version changed since it was read
+var ErrStaleUser = errors.New("user was modified by another request")
+
+// UpdatableUserFields maps the update mask paths of a user into their column
+var UpdatableUserFields = map[string]string{
+	"username":  "username",
+	"email":     "email",
+	"is_active": "is_active",
+}
+
 type DB struct {
 	db  *infra.DatabaseList
 	log *logrus.Logger
 }
 
-// NewDB creates a new array to mimic the behaviour of a in-memory database
+// NewDB creates the Postgres repository on the read & write databases
 func NewDB(db *infra.DatabaseList, logger *logrus.Logger) *DB {
 	return &DB{
 		db:  db,
@@ -27,48 +39,53 @@ func NewDB(db *infra.DatabaseList, logger *logrus.Logger) *DB {
 	}
 }
 
-// SaveUser adds a new order to the DB collection. Returns an error on duplicate ids
-func (d *DB) SaveUser(ctx context.Context, tx *sql.Tx, user *users.User) (int64, error) {
-	InsertUser := `INSERT INTO public.users
-	(username, email, password, is_active, created_at, updated_at, created_by, updated_by)
-	VALUES(?, ?, ?, ?, ?, ?, ?, ?)	
-	 returning user_id;
-	`
+// Begin starts a transaction on the write database
+func (d *DB) Begin(ctx context.Context) (Tx, error) {
+	return d.db.Backend.Write.BeginTx(ctx, nil)
+}
 
-	param := make([]interface{}, 0)
+// userColumns are the columns scanned by scanUser, the password is only read by the login
+const userColumns SQL = `user_id, username, email, is_active, created_at, created_by, updated_at, updated_by, version, email_verified_at`
 
-	param = append(param, user.Username)
-	param = append(param, user.Email)
-	param = append(param, user.Password)
-	param = append(param, user.IsActive)
-	param = append(param, time.Now().UTC())
-	param = append(param, time.Now().UTC())
-	param = append(param, user.CreatedBy)
-	param = append(param, user.UpdatedBy)
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns
+func scanUser(row rowScanner) (*users.User, error) {
+	var (
+		result               users.User
+		createdAt, updatedAt time.Time
+		emailVerifiedAt      sql.NullTime
+	)
 
-	query, args, err := d.db.Backend.Write.In(InsertUser, param...)
+	err := row.Scan(&result.UserId, &result.Username, &result.Email, &result.IsActive, &createdAt, &result.CreatedBy, &updatedAt, &result.UpdatedBy, &result.Version, &emailVerifiedAt)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	query = d.db.Backend.Write.Rebind(query)
+	// Convert time.Time to *timestamppb.Timestamp
+	result.CreatedAt = timestamppb.New(createdAt)
+	result.UpdatedAt = timestamppb.New(updatedAt)
+	if emailVerifiedAt.Valid {
+		result.EmailVerifiedAt = timestamppb.New(emailVerifiedAt.Time)
+	}
 
-	d.log.WithField("QueryDebug : ", query).Infof("Query SaveUser")
+	return &result, nil
+}
 
-	var res *sql.Row
-	if tx == nil {
-		res = d.db.Backend.Write.QueryRow(ctx, query, args...)
-	} else {
-		res = tx.QueryRowContext(ctx, query, args...)
-	}
+// SaveUser adds a new order to the DB collection. Returns an error on duplicate ids
+func (d *DB) SaveUser(ctx context.Context, tx Tx, user *users.User) (int64, error) {
+	now := time.Now().UTC()
 
-	if err != nil {
-		return 0, err
-	}
+	q := NewQuery(`INSERT INTO public.users
+	(username, email, password, is_active, created_at, updated_at, created_by, updated_by)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	returning user_id;`, user.Username, user.Email, user.Password, user.IsActive, now, now, user.CreatedBy, user.UpdatedBy)
 
-	err = res.Err()
+	res, err := d.queryRow(ctx, tx, "SaveUser", q)
 	if err != nil {
-		log.Println(err.Error())
 		return 0, err
 	}
 
@@ -81,117 +98,397 @@ func (d *DB) SaveUser(ctx context.Context, tx *sql.Tx, user *users.User) (int64,
 	return id, nil
 }
 
+// CheckIsExistUser checks whether the username or email is already used, the email is also checked as a username
 func (d *DB) CheckIsExistUser(ctx context.Context, user *users.User) (bool, error) {
 	var res bool
 
-	GetUser := `select exists(select 1 from public.users u where (u.username = '` + user.Username + `' or u.email = '` + user.Email + `') or (u.username = '` + user.Email + `' or u.email = '` + user.Email + `'));`
+	q := NewQuery(`select exists(select 1 from public.users u where (u.username = ? or u.email = ? or u.username = ?) and u.deleted_at is null);`, user.Username, user.Email, user.Email)
 
-	query, args, err := d.db.Backend.Read.In(GetUser)
+	err := d.get(ctx, d.db.Backend.Write, "isExists user", &res, q)
 	if err != nil {
 		return res, err
 	}
 
-	query = d.db.Backend.Read.Rebind(query)
-	d.log.WithField("QueryDebug : ", query).Infof("Query isExists user")
-	err = d.db.Backend.Write.Get(&res, query, args...)
-	if err != nil && err != sql.ErrNoRows {
-		return res, err
-	}
+	return res, nil
+}
+
+// GetUserByID returns an order by the order_id
+func (d *DB) GetUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	q := NewQuery(`SELECT `+userColumns+` FROM public.users WHERE user_id = ? AND deleted_at IS NULL`, userID)
 
+	res, err := d.queryRow(ctx, nil, "GetUserByID", q)
 	if err != nil {
-		return res, err
+		return nil, err
 	}
 
-	return res, nil
+	return scanUser(res)
 }
 
-// GetUserByID returns an order by the order_id
-func (d *DB) GetUserByID(ctx context.Context, userID uint64) (*users.User, error) {
-	var result users.User
+// GetUserByEmailOrUsername returns the user with its password hash for the login
+func (d *DB) GetUserByEmailOrUsername(ctx context.Context, data string) (*users.User, error) {
+	var (
+		result          users.User
+		createdAt       time.Time
+		emailVerifiedAt sql.NullTime
+	)
 
-	query := fmt.Sprintf(`SELECT user_id, username, email, is_active, created_at, created_by, updated_at, updated_by FROM public.users WHERE user_id = %d`, userID)
+	q := NewQuery(`SELECT user_id, username, email, is_active, password, created_at, email_verified_at FROM public.users WHERE (username = ? or email = ?) AND deleted_at IS NULL;`, data, data)
 
-	rows, err := d.db.Backend.Write.Query(query)
+	res, err := d.queryRow(ctx, nil, "GetUserByEmailOrUsername", q)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	if !rows.Next() {
-		return nil, sql.ErrNoRows
+	err = res.Scan(&result.UserId, &result.Username, &result.Email, &result.IsActive, &result.Password, &createdAt, &emailVerifiedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result.CreatedAt = timestamppb.New(createdAt)
+	if emailVerifiedAt.Valid {
+		result.EmailVerifiedAt = timestamppb.New(emailVerifiedAt.Time)
 	}
 
-	var createdAt, updatedAt time.Time
-	err = rows.Scan(&result.UserId, &result.Username, &result.Email, &result.IsActive, &createdAt, &result.CreatedBy, &updatedAt, &result.UpdatedBy)
+	return &result, nil
+}
+
+// ListUsersFilter filters, sorts and pages ListUsers
+// AfterValue & AfterUserID are the sort value and user id of the last user of the previous page
+type ListUsersFilter struct {
+	IsActive    *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	CreatedBy   string
+	Search      string
+	OrderBy     string
+	Descending  bool
+	AfterValue  interface{}
+	AfterUserID uint64
+	Limit       int
+}
+
+// SortableUserColumns are the indexed columns ListUsers can be sorted by
+var SortableUserColumns = map[string]string{
+	"user_id":    "user_id",
+	"username":   "username",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// ListUsers returns a page of users from the read database using keyset pagination on (order_by, user_id)
+func (d *DB) ListUsers(ctx context.Context, filter ListUsersFilter) ([]*users.User, error) {
+	column, ok := SortableUserColumns[filter.OrderBy]
+	if !ok {
+		return nil, fmt.Errorf("users cannot be sorted by %s", filter.OrderBy)
+	}
+
+	// column comes from the SortableUserColumns whitelist
+	orderBy := SQL(column)
+
+	conditions := []*Query{NewQuery(`deleted_at IS NULL`)}
+
+	if filter.IsActive != nil {
+		conditions = append(conditions, NewQuery(`is_active = ?`, *filter.IsActive))
+	}
+
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, NewQuery(`created_at >= ?`, *filter.CreatedFrom))
+	}
+
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, NewQuery(`created_at < ?`, *filter.CreatedTo))
+	}
+
+	if filter.CreatedBy != "" {
+		conditions = append(conditions, NewQuery(`created_by = ?`, filter.CreatedBy))
+	}
+
+	if filter.Search != "" {
+		search := escapeLike(strings.ToLower(filter.Search)) + "%"
+		conditions = append(conditions, NewQuery(`(lower(username) LIKE ? ESCAPE '\' OR lower(email) LIKE ? ESCAPE '\')`, search, search))
+	}
+
+	var direction, comparator SQL = "ASC", ">"
+	if filter.Descending {
+		direction, comparator = "DESC", "<"
+	}
+
+	if filter.AfterUserID != 0 {
+		if orderBy == "user_id" {
+			conditions = append(conditions, NewQuery(`user_id `+comparator+` ?`, filter.AfterUserID))
+		} else {
+			conditions = append(conditions, NewQuery(`(`+orderBy+`, user_id) `+comparator+` (?, ?)`, filter.AfterValue, filter.AfterUserID))
+		}
+	}
+
+	order := `user_id ` + direction
+	if orderBy != "user_id" {
+		order = orderBy + ` ` + direction + `, ` + order
+	}
+
+	q := NewQuery(`SELECT `+userColumns+` FROM public.users WHERE`).
+		Join(` AND `, conditions...).
+		Append(`ORDER BY `+order+` LIMIT ?`, filter.Limit)
+
+	rows, err := d.query(ctx, d.db.Backend.Read, "ListUsers", q)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Convert time.Time to *timestamppb.Timestamp
-	createdAtProto := timestamppb.New(createdAt)
-	updatedAtProto := timestamppb.New(updatedAt)
-	result.CreatedAt = createdAtProto
-	result.UpdatedAt = updatedAtProto
+	result := make([]*users.User, 0, filter.Limit)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
 
-	return &result, nil
+		result = append(result, user)
+	}
+
+	return result, rows.Err()
 }
 
-func (d *DB) GetUserByEmailOrUsername(data string) (*users.User, error) {
-	var result users.User
+// escapeLike escapes the LIKE wildcards of a user input
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
 
-	query := fmt.Sprintf(`SELECT user_id, username, email, is_active, password FROM public.users WHERE username = '%s' or email = '%s';`, data, data)
+// GetUserByIDs returns the users of the given ids in one query on the read database, missing ids are skipped
+func (d *DB) GetUserByIDs(ctx context.Context, userIDs []uint64) ([]*users.User, error) {
+	result := make([]*users.User, 0, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
 
-	rows, err := d.db.Backend.Write.Query(query)
+	q := NewQuery(`SELECT `+userColumns+` FROM public.users WHERE user_id IN (?) AND deleted_at IS NULL`, userIDs)
+
+	rows, err := d.query(ctx, d.db.Backend.Read, "GetUserByIDs", q)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	if !rows.Next() {
-		return nil, sql.ErrNoRows
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, user)
+	}
+
+	return result, rows.Err()
+}
+
+// CheckIsExistOtherUser checks whether the username or email is already used by another user
+func (d *DB) CheckIsExistOtherUser(ctx context.Context, user *users.User) (bool, error) {
+	var res bool
+
+	q := NewQuery(`select exists(select 1 from public.users u where (u.username = ? or u.email = ? or u.username = ?) and u.user_id <> ? and u.deleted_at is null);`, user.Username, user.Email, user.Email, user.UserId)
+
+	err := d.get(ctx, d.db.Backend.Write, "isExists other user", &res, q)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// UpdateUser updates the given fields of a user when its version still matches user.Version
+// Returns the new version, ErrStaleUser when the version changed or sql.ErrNoRows when the user does not exist
+func (d *DB) UpdateUser(ctx context.Context, tx Tx, user *users.User, fields []string) (int64, error) {
+	sets := make([]*Query, 0, len(fields)+3)
+
+	for _, field := range fields {
+		switch field {
+		case "username":
+			sets = append(sets, NewQuery(`username = ?`, user.Username))
+		case "email":
+			// a new email has to be verified again
+			sets = append(sets, NewQuery(`email = ?`, user.Email), NewQuery(`email_verified_at = NULL`))
+		case "is_active":
+			sets = append(sets, NewQuery(`is_active = ?`, user.IsActive))
+		default:
+			return 0, fmt.Errorf("field %s cannot be updated", field)
+		}
+	}
+
+	sets = append(sets, NewQuery(`updated_at = ?`, time.Now().UTC()), NewQuery(`updated_by = ?`, user.UpdatedBy), NewQuery(`version = version + 1`))
+
+	q := NewQuery(`UPDATE public.users SET`).
+		Join(`, `, sets...).
+		Append(`WHERE user_id = ? AND version = ? AND deleted_at IS NULL returning version;`, user.UserId, user.Version)
+
+	res, err := d.queryRow(ctx, tx, "UpdateUser", q)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	err = res.Scan(&version)
+	if err == nil {
+		return version, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	// nothing updated, tell apart a stale version from a missing user
+	var isExist bool
+	err = d.get(ctx, d.db.Backend.Write, "isExists user by id", &isExist, NewQuery(`select exists(select 1 from public.users u where u.user_id = ? and u.deleted_at is null);`, user.UserId))
+	if err != nil {
+		return 0, err
+	}
+
+	if isExist {
+		return 0, ErrStaleUser
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+// RemoveUser soft deletes a user by setting deleted_at and deactivating the account
+// Returns sql.ErrNoRows when the user does not exist or is already deleted
+func (d *DB) RemoveUser(ctx context.Context, tx Tx, userID uint64, deletedBy string) error {
+	now := time.Now().UTC()
+
+	q := NewQuery(`UPDATE public.users SET deleted_at = ?, is_active = false, updated_at = ?, updated_by = ?, version = version + 1 WHERE user_id = ? AND deleted_at IS NULL`, now, now, deletedBy, userID)
+
+	res, err := d.exec(ctx, tx, "RemoveUser", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
 
-	err = rows.Scan(&result.UserId, &result.Username, &result.Email, &result.IsActive, &result.Password)
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetDeletedUserByID returns a soft deleted user, used to restore it
+func (d *DB) GetDeletedUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	q := NewQuery(`SELECT `+userColumns+` FROM public.users WHERE user_id = ? AND deleted_at IS NOT NULL`, userID)
+
+	res, err := d.queryRow(ctx, nil, "GetDeletedUserByID", q)
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	return scanUser(res)
 }
 
-// GetUserByIDs returns all users pertaining to the given order ids
-func (d *DB) GetUserByIDs(userIDs []uint64) []*users.User {
-	filtered := make([]*users.User, 0)
-
-	// for _, idx := range userIDs {
-	// 	for _, order := range d.collection {
-	// 		if order.UserId == idx {
-	// 			filtered = append(filtered, order)
-	// 			break
-	// 		}
-	// 	}
-	// }
-
-	return filtered
-}
-
-// UpdateUser updates an order in place
-func (d *DB) UpdateUser(order *users.User) {
-	// for i, o := range d.collection {
-	// 	if o.UserId == order.UserId {
-	// 		d.collection[i] = order
-	// 		return
-	// 	}
-	// }
-}
-
-// RemoveUser removes an order from the users collection
-func (d *DB) RemoveUser(userID uint64) {
-	// filtered := make([]*users.User, 0, len(d.collection)-1)
-	// for i := range d.collection {
-	// 	if d.collection[i].UserId != userID {
-	// 		filtered = append(filtered, d.collection[i])
-	// 	}
-	// }
-	// d.collection = filtered
+// RestoreUser clears deleted_at of a soft deleted user and activates the account again
+// Returns sql.ErrNoRows when the user is not deleted or was already purged
+func (d *DB) RestoreUser(ctx context.Context, tx Tx, userID uint64, restoredBy string) error {
+	q := NewQuery(`UPDATE public.users SET deleted_at = NULL, is_active = true, updated_at = ?, updated_by = ?, version = version + 1 WHERE user_id = ? AND deleted_at IS NOT NULL`, time.Now().UTC(), restoredBy, userID)
+
+	res, err := d.exec(ctx, tx, "RestoreUser", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of a user as verified, verifying it again keeps the first verification time
+// Returns sql.ErrNoRows when the user does not exist
+func (d *DB) VerifyEmail(ctx context.Context, tx Tx, userID uint64) error {
+	q := NewQuery(`UPDATE public.users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE user_id = ? AND deleted_at IS NULL`, time.Now().UTC(), userID)
+
+	res, err := d.exec(ctx, tx, "VerifyEmail", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user
+// Returns sql.ErrNoRows when the user does not exist
+func (d *DB) UpdatePassword(ctx context.Context, tx Tx, userID uint64, password string) error {
+	q := NewQuery(`UPDATE public.users SET password = ?, updated_at = ?, updated_by = ?, version = version + 1 WHERE user_id = ? AND deleted_at IS NULL`, password, time.Now().UTC(), strconv.FormatUint(userID, 10), userID)
+
+	res, err := d.exec(ctx, tx, "UpdatePassword", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers hard deletes the users soft deleted before the given time together with their sessions & failed logins
+// Returns the number of purged users
+func (d *DB) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	tx, err := d.db.Backend.Write.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range []SQL{"public.user_refresh_tokens", "public.user_sessions", "public.user_tokens", "public.user_totp", "public.user_recovery_codes"} {
+		q := NewQuery(`DELETE FROM `+table+` WHERE user_id IN (SELECT user_id FROM public.users WHERE deleted_at IS NOT NULL AND deleted_at < ?)`, deletedBefore)
+
+		_, err = d.exec(ctx, tx, "PurgeDeletedUsers", q)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	//the failed logins of an account have no foreign key, their subject is the user id
+	q := NewQuery(`DELETE FROM public.login_attempts WHERE scope = ? AND subject IN (SELECT user_id::text FROM public.users WHERE deleted_at IS NOT NULL AND deleted_at < ?)`, LoginAttemptScopeUser, deletedBefore)
+
+	_, err = d.exec(ctx, tx, "PurgeDeletedUsers", q)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	res, err := d.exec(ctx, tx, "PurgeDeletedUsers", NewQuery(`DELETE FROM public.users WHERE deleted_at IS NOT NULL AND deleted_at < ?`, deletedBefore))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return affected, tx.Commit()
 }
@@ -0,0 +1,398 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// statement is a statement received by the recording driver
+type statement struct {
+	query string
+	args  []driver.NamedValue
+}
+
+// recorder is a database/sql driver recording every statement, queries answer no row except exists() answering true
+type recorder struct {
+	mu         sync.Mutex
+	statements []statement
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return &recorderConn{r: r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+func (r *recorder) record(query string, args []driver.NamedValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.statements = append(r.statements, statement{query: query, args: args})
+}
+
+// take returns the statements recorded since the previous call
+func (r *recorder) take() []statement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statements := r.statements
+	r.statements = nil
+
+	return statements
+}
+
+type recorderConn struct {
+	r *recorder
+}
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare is not supported: %s", query)
+}
+func (c *recorderConn) Close() error              { return nil }
+func (c *recorderConn) Begin() (driver.Tx, error) { return recorderTx{}, nil }
+func (c *recorderConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return recorderTx{}, nil
+}
+
+func (c *recorderConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.r.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recorderConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.r.record(query, args)
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "select exists") {
+		return &recorderRows{columns: []string{"exists"}, values: [][]driver.Value{{true}}}, nil
+	}
+
+	return &recorderRows{}, nil
+}
+
+type recorderTx struct{}
+
+func (recorderTx) Commit() error   { return nil }
+func (recorderTx) Rollback() error { return nil }
+
+type recorderRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recorderRows) Columns() []string { return r.columns }
+func (r *recorderRows) Close() error      { return nil }
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+// newRecordedDB returns the Postgres repository on the recording driver
+func newRecordedDB(t *testing.T) (*DB, *recorder) {
+	t.Helper()
+
+	r := &recorder{}
+	conn := sqlx.NewDb(sql.OpenDB(r), "postgres")
+	t.Cleanup(func() { conn.Close() })
+
+	handler := &infra.DBHandler{DB: conn}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return NewDB(&infra.DatabaseList{Backend: infra.DatabaseType{Read: handler, Write: handler}}, logger), r
+}
+
+// TestDBInjection feeds injection payloads through every method of DB,
+// they must reach the driver as arguments and never as part of a statement
+func TestDBInjection(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name string
+		// wantArg is false for the methods without string input, they are only checked for the statements they run
+		wantArg bool
+		call    func(d *DB, tx Tx, p string) error
+	}{
+		{"SaveUser", true, func(d *DB, tx Tx, p string) error {
+			_, err := d.SaveUser(ctx, tx, &users.User{Username: p, Email: p, Password: p, CreatedBy: p})
+			return err
+		}},
+		{"CheckIsExistUser", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.CheckIsExistUser(ctx, &users.User{Username: p, Email: p})
+			return err
+		}},
+		{"CheckIsExistOtherUser", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.CheckIsExistOtherUser(ctx, &users.User{UserId: 1, Username: p, Email: p})
+			return err
+		}},
+		{"GetUserByID", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.GetUserByID(ctx, 1)
+			return err
+		}},
+		{"GetUserByIDs", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.GetUserByIDs(ctx, []uint64{1, 2})
+			return err
+		}},
+		{"GetUserByEmailOrUsername", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.GetUserByEmailOrUsername(ctx, p)
+			return err
+		}},
+		{"ListUsers", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.ListUsers(ctx, ListUsersFilter{CreatedBy: p, Search: p, OrderBy: "username", AfterValue: p, AfterUserID: 1, Limit: 10})
+			return err
+		}},
+		{"UpdateUser", true, func(d *DB, tx Tx, p string) error {
+			_, err := d.UpdateUser(ctx, tx, &users.User{UserId: 1, Username: p, Email: p, UpdatedBy: p, Version: 1}, []string{"username", "email", "is_active"})
+			return err
+		}},
+		{"RemoveUser", true, func(d *DB, tx Tx, p string) error {
+			return d.RemoveUser(ctx, tx, 1, p)
+		}},
+		{"GetDeletedUserByID", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.GetDeletedUserByID(ctx, 1)
+			return err
+		}},
+		{"RestoreUser", true, func(d *DB, tx Tx, p string) error {
+			return d.RestoreUser(ctx, tx, 1, p)
+		}},
+		{"VerifyEmail", false, func(d *DB, tx Tx, _ string) error {
+			return d.VerifyEmail(ctx, tx, 1)
+		}},
+		{"UpdatePassword", true, func(d *DB, tx Tx, p string) error {
+			return d.UpdatePassword(ctx, tx, 1, p)
+		}},
+		{"PurgeDeletedUsers", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.PurgeDeletedUsers(ctx, now)
+			return err
+		}},
+		{"SaveSession", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveSession(ctx, tx, &Session{SessionID: p, UserID: 1, UserAgent: p, IPAddress: p, ExpiresAt: now})
+		}},
+		{"TouchSession", true, func(d *DB, tx Tx, p string) error {
+			return d.TouchSession(ctx, tx, p, now)
+		}},
+		{"IsSessionActive", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.IsSessionActive(ctx, p)
+			return err
+		}},
+		{"GetSessionsByUserID", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.GetSessionsByUserID(ctx, 1)
+			return err
+		}},
+		{"RevokeSession", true, func(d *DB, _ Tx, p string) error {
+			return d.RevokeSession(ctx, 1, p)
+		}},
+		{"RevokeAllSessions", false, func(d *DB, tx Tx, _ string) error {
+			_, err := d.RevokeAllSessions(ctx, tx, 1)
+			return err
+		}},
+		{"SaveRefreshToken", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveRefreshToken(ctx, tx, &RefreshToken{TokenID: p, Family: p, UserID: 1, ExpiresAt: now})
+		}},
+		{"GetRefreshToken", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.GetRefreshToken(ctx, p)
+			return err
+		}},
+		{"UseRefreshToken", true, func(d *DB, tx Tx, p string) error {
+			_, err := d.UseRefreshToken(ctx, tx, p)
+			return err
+		}},
+		{"RevokeRefreshTokenFamily", true, func(d *DB, _ Tx, p string) error {
+			return d.RevokeRefreshTokenFamily(ctx, p)
+		}},
+		{"SaveUserToken", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveUserToken(ctx, tx, &UserToken{TokenHash: p, UserID: 1, Purpose: p, ExpiresAt: now})
+		}},
+		{"UseUserToken", true, func(d *DB, tx Tx, p string) error {
+			_, err := d.UseUserToken(ctx, tx, p, p)
+			return err
+		}},
+		{"InvalidateUserTokens", true, func(d *DB, tx Tx, p string) error {
+			return d.InvalidateUserTokens(ctx, tx, 1, p)
+		}},
+		{"SaveTOTP", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveTOTP(ctx, tx, 1, p)
+		}},
+		{"GetTOTP", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.GetTOTP(ctx, 1)
+			return err
+		}},
+		{"EnableTOTP", false, func(d *DB, tx Tx, _ string) error {
+			return d.EnableTOTP(ctx, tx, 1, 42)
+		}},
+		{"UseTOTPCounter", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.UseTOTPCounter(ctx, 1, 42)
+			return err
+		}},
+		{"SaveRecoveryCodes", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveRecoveryCodes(ctx, tx, 1, []string{p, p + "2"})
+		}},
+		{"UseRecoveryCode", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.UseRecoveryCode(ctx, 1, p)
+			return err
+		}},
+		{"GetLoginAttempt", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.GetLoginAttempt(ctx, p, p)
+			return err
+		}},
+		{"RecordLoginFailure", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.RecordLoginFailure(ctx, p, p, now)
+			return err
+		}},
+		{"LockLoginAttempt", true, func(d *DB, _ Tx, p string) error {
+			return d.LockLoginAttempt(ctx, p, p, now)
+		}},
+		{"ClearLoginAttempt", true, func(d *DB, _ Tx, p string) error {
+			_, err := d.ClearLoginAttempt(ctx, p, p)
+			return err
+		}},
+		{"SaveOutboxEvent", true, func(d *DB, tx Tx, p string) error {
+			return d.SaveOutboxEvent(ctx, tx, &OutboxEvent{EventID: p, Type: p, UserID: 1, Payload: []byte(`{}`), CreatedAt: now})
+		}},
+		{"ClaimOutboxEvents", false, func(d *DB, tx Tx, _ string) error {
+			_, err := d.ClaimOutboxEvents(ctx, tx, 10)
+			return err
+		}},
+		{"MarkOutboxEventPublished", false, func(d *DB, tx Tx, _ string) error {
+			return d.MarkOutboxEventPublished(ctx, tx, 1)
+		}},
+		{"MarkOutboxEventFailed", true, func(d *DB, tx Tx, p string) error {
+			return d.MarkOutboxEventFailed(ctx, tx, 1, p, true)
+		}},
+		{"PurgeOutboxEvents", false, func(d *DB, _ Tx, _ string) error {
+			_, err := d.PurgeOutboxEvents(ctx, now)
+			return err
+		}},
+	}
+
+	d, r := newRecordedDB(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, payload := range injectionPayloads {
+				// the methods taking a Tx run once in a transaction and once on their own
+				for _, inTx := range []bool{false, true} {
+					var tx Tx
+					if inTx {
+						var err error
+						tx, err = d.Begin(ctx)
+						if err != nil {
+							t.Fatalf("Begin() error = %v", err)
+						}
+					}
+
+					// the errors are expected, the driver answers no row
+					_ = tt.call(d, tx, payload)
+					if tx != nil {
+						tx.Rollback()
+					}
+
+					checkStatements(t, r.take(), payload, tt.wantArg)
+				}
+			}
+		})
+	}
+}
+
+// checkStatements fails when a statement contains payload, or when wantArg and no statement received it as an argument
+func checkStatements(t *testing.T, statements []statement, payload string, wantArg bool) {
+	t.Helper()
+
+	if len(statements) == 0 {
+		t.Fatalf("no statement run for payload %q", payload)
+	}
+
+	received := false
+	for _, s := range statements {
+		if strings.Contains(s.query, payload) {
+			t.Errorf("statement contains payload %q: %s", payload, s.query)
+		}
+
+		if strings.Contains(s.query, "?") {
+			t.Errorf("statement was not rebound for postgres: %s", s.query)
+		}
+
+		for _, arg := range s.args {
+			if value, ok := arg.Value.(string); ok && strings.Contains(value, payload) {
+				received = true
+			}
+		}
+	}
+
+	if wantArg && !received {
+		t.Errorf("payload %q reached no statement as an argument", payload)
+	}
+}
+
+// TestUpdateUserRefusesUnknownField checks the update mask cannot name a column outside of the whitelist
+func TestUpdateUserRefusesUnknownField(t *testing.T) {
+	d, r := newRecordedDB(t)
+
+	for _, payload := range injectionPayloads {
+		_, err := d.UpdateUser(context.Background(), nil, &users.User{UserId: 1, Version: 1}, []string{payload})
+		if err == nil {
+			t.Errorf("UpdateUser() accepted the field %q", payload)
+		}
+	}
+
+	if statements := r.take(); len(statements) > 0 {
+		t.Errorf("UpdateUser() ran %d statements for unknown fields", len(statements))
+	}
+}
+
+// TestListUsersRefusesUnknownOrder checks the sort column cannot be chosen outside of SortableUserColumns
+func TestListUsersRefusesUnknownOrder(t *testing.T) {
+	d, r := newRecordedDB(t)
+
+	for _, payload := range injectionPayloads {
+		_, err := d.ListUsers(context.Background(), ListUsersFilter{OrderBy: payload, Limit: 10})
+		if err == nil {
+			t.Errorf("ListUsers() accepted the order %q", payload)
+		}
+	}
+
+	if statements := r.take(); len(statements) > 0 {
+		t.Errorf("ListUsers() ran %d statements for unknown orders", len(statements))
+	}
+}
+
+// TestPurgeDeletedUsersRemovesLoginAttempts checks the failed logins of the purged users go with them, before the users
+func TestPurgeDeletedUsersRemovesLoginAttempts(t *testing.T) {
+	d, r := newRecordedDB(t)
+
+	_, err := d.PurgeDeletedUsers(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers() error = %v", err)
+	}
+
+	attempts, purge := -1, -1
+	for i, s := range r.take() {
+		switch {
+		case strings.HasPrefix(s.query, "DELETE FROM public.login_attempts"):
+			attempts = i
+			if len(s.args) == 0 || s.args[0].Value != LoginAttemptScopeUser {
+				t.Errorf("login attempts deleted with args %v, want the scope %q first", s.args, LoginAttemptScopeUser)
+			}
+		case strings.HasPrefix(s.query, "DELETE FROM public.users"):
+			purge = i
+		}
+	}
+
+	if attempts < 0 || purge < 0 || attempts > purge {
+		t.Errorf("PurgeDeletedUsers() deleted the login attempts at %d and the users at %d, want the login attempts first", attempts, purge)
+	}
+}
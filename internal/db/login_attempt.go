@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Scopes of the failed logins
+const (
+	LoginAttemptScopeUser = "user"
+	LoginAttemptScopeIP   = "ip"
+)
+
+// LoginAttempt counts the consecutive failed logins of an account or a client ip
+type LoginAttempt struct {
+	Scope        string       `db:"scope"`
+	Subject      string       `db:"subject"`
+	Failures     int          `db:"failures"`
+	LastFailedAt time.Time    `db:"last_failed_at"`
+	LockedUntil  sql.NullTime `db:"locked_until"`
+}
+
+// GetLoginAttempt returns the failed logins of a subject, sql.ErrNoRows when there is none
+func (d *DB) GetLoginAttempt(ctx context.Context, scope, subject string) (*LoginAttempt, error) {
+	var result LoginAttempt
+
+	q := NewQuery(`SELECT scope, subject, failures, last_failed_at, locked_until FROM public.login_attempts WHERE scope = ? AND subject = ?`, scope, subject)
+
+	err := d.get(ctx, d.db.Backend.Write, "GetLoginAttempt", &result, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// RecordLoginFailure counts a failed login of a subject and returns the new count
+// The count starts again when both the last failure and the lock ended before windowStart
+func (d *DB) RecordLoginFailure(ctx context.Context, scope, subject string, windowStart time.Time) (*LoginAttempt, error) {
+	q := NewQuery(`INSERT INTO public.login_attempts
+	(scope, subject, failures, last_failed_at)
+	VALUES(?, ?, 1, ?)
+	ON CONFLICT (scope, subject) DO UPDATE SET
+	failures = CASE WHEN public.login_attempts.last_failed_at < ? AND (public.login_attempts.locked_until IS NULL OR public.login_attempts.locked_until < ?) THEN 1 ELSE public.login_attempts.failures + 1 END,
+	last_failed_at = EXCLUDED.last_failed_at
+	returning scope, subject, failures, last_failed_at, locked_until;`, scope, subject, time.Now().UTC(), windowStart, windowStart)
+
+	var result LoginAttempt
+
+	err := d.get(ctx, d.db.Backend.Write, "RecordLoginFailure", &result, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// LockLoginAttempt refuses the logins of a subject until lockedUntil
+func (d *DB) LockLoginAttempt(ctx context.Context, scope, subject string, lockedUntil time.Time) error {
+	q := NewQuery(`UPDATE public.login_attempts SET locked_until = ? WHERE scope = ? AND subject = ?`, lockedUntil, scope, subject)
+
+	_, err := d.exec(ctx, nil, "LockLoginAttempt", q)
+
+	return err
+}
+
+// ClearLoginAttempt forgets the failed logins and the lock of a subject. Returns false when there was none
+func (d *DB) ClearLoginAttempt(ctx context.Context, scope, subject string) (bool, error) {
+	q := NewQuery(`DELETE FROM public.login_attempts WHERE scope = ? AND subject = ?`, scope, subject)
+
+	res, err := d.exec(ctx, nil, "ClearLoginAttempt", q)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,1021 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryDB is a thread-safe in-memory Repository, used for local development and handler tests
+type MemoryDB struct {
+	mu         sync.RWMutex
+	log        *logrus.Logger
+	lastUserID uint64
+	users      map[uint64]*memoryUser
+	sessions   map[string]*Session
+	tokens     map[string]*RefreshToken
+	userTokens map[string]*UserToken
+	totp       map[uint64]*TOTP
+	recovery   map[uint64]map[string]*sql.NullTime
+	attempts   map[string]*LoginAttempt
+	outbox     []*OutboxEvent
+	lastEvent  int64
+}
+
+type memoryUser struct {
+	user      *users.User
+	deletedAt *time.Time
+}
+
+// memoryTx applies the changes immediately and keeps an undo list to revert them on Rollback
+type memoryTx struct {
+	db   *MemoryDB
+	undo []func()
+	done bool
+}
+
+// NewMemoryDB creates an empty in-memory repository
+func NewMemoryDB(logger *logrus.Logger) *MemoryDB {
+	return &MemoryDB{
+		log:        logger,
+		users:      make(map[uint64]*memoryUser),
+		sessions:   make(map[string]*Session),
+		tokens:     make(map[string]*RefreshToken),
+		userTokens: make(map[string]*UserToken),
+		totp:       make(map[uint64]*TOTP),
+		recovery:   make(map[uint64]map[string]*sql.NullTime),
+		attempts:   make(map[string]*LoginAttempt),
+	}
+}
+
+// Begin starts an in-memory transaction
+func (m *MemoryDB) Begin(ctx context.Context) (Tx, error) {
+	return &memoryTx{db: m}, nil
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+
+	t.done = true
+	t.undo = nil
+
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+
+	t.done = true
+	t.undo = nil
+
+	return nil
+}
+
+// track registers how to revert a change made in tx, m.mu must be held
+func (m *MemoryDB) track(tx Tx, undo func()) {
+	if memTx, ok := unwrapTx(tx).(*memoryTx); ok && memTx != nil {
+		memTx.undo = append(memTx.undo, undo)
+	}
+}
+
+// snapshot returns a copy of a stored user without its password
+func snapshot(stored *memoryUser) *users.User {
+	result := proto.Clone(stored.user).(*users.User)
+	result.Password = ""
+
+	return result
+}
+
+func (m *MemoryDB) SaveUser(ctx context.Context, tx Tx, user *users.User) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.users {
+		if stored.deletedAt == nil && (stored.user.Username == user.Username || stored.user.Email == user.Email) {
+			return 0, fmt.Errorf("user %s already exists", user.Username)
+		}
+	}
+
+	now := timestamppb.New(time.Now().UTC())
+
+	m.lastUserID++
+	saved := proto.Clone(user).(*users.User)
+	saved.UserId = m.lastUserID
+	saved.CreatedAt = now
+	saved.UpdatedAt = now
+	saved.Version = 1
+
+	m.users[saved.UserId] = &memoryUser{user: saved}
+	m.track(tx, func() {
+		delete(m.users, saved.UserId)
+	})
+
+	return int64(saved.UserId), nil
+}
+
+func (m *MemoryDB) CheckIsExistUser(ctx context.Context, user *users.User) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.users {
+		if stored.deletedAt != nil {
+			continue
+		}
+
+		if stored.user.Username == user.Username || stored.user.Username == user.Email || stored.user.Email == user.Email {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *MemoryDB) CheckIsExistOtherUser(ctx context.Context, user *users.User) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.users {
+		if stored.deletedAt != nil || stored.user.UserId == user.UserId {
+			continue
+		}
+
+		if stored.user.Username == user.Username || stored.user.Username == user.Email || stored.user.Email == user.Email {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *MemoryDB) GetUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return snapshot(stored), nil
+}
+
+func (m *MemoryDB) GetUserByIDs(ctx context.Context, userIDs []uint64) ([]*users.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*users.User, 0, len(userIDs))
+	for _, userID := range userIDs {
+		stored, ok := m.users[userID]
+		if ok && stored.deletedAt == nil {
+			result = append(result, snapshot(stored))
+		}
+	}
+
+	return result, nil
+}
+
+// GetUserByEmailOrUsername is the only method returning the password hash, it is needed by the login
+func (m *MemoryDB) GetUserByEmailOrUsername(ctx context.Context, data string) (*users.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.users {
+		if stored.deletedAt == nil && (stored.user.Username == data || stored.user.Email == data) {
+			return proto.Clone(stored.user).(*users.User), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryDB) UpdateUser(ctx context.Context, tx Tx, user *users.User, fields []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.UserId]
+	if !ok || stored.deletedAt != nil {
+		return 0, sql.ErrNoRows
+	}
+
+	if stored.user.Version != user.Version {
+		return 0, ErrStaleUser
+	}
+
+	updated := proto.Clone(stored.user).(*users.User)
+	for _, field := range fields {
+		switch field {
+		case "username":
+			updated.Username = user.Username
+		case "email":
+			updated.Email = user.Email
+			updated.EmailVerifiedAt = nil
+		case "is_active":
+			updated.IsActive = user.IsActive
+		default:
+			return 0, fmt.Errorf("field %s cannot be updated", field)
+		}
+	}
+
+	updated.UpdatedAt = timestamppb.New(time.Now().UTC())
+	updated.UpdatedBy = user.UpdatedBy
+	updated.Version++
+
+	previous := stored.user
+	stored.user = updated
+	m.track(tx, func() {
+		stored.user = previous
+	})
+
+	return updated.Version, nil
+}
+
+func (m *MemoryDB) RemoveUser(ctx context.Context, tx Tx, userID uint64, deletedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt != nil {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now().UTC()
+
+	removed := proto.Clone(stored.user).(*users.User)
+	removed.IsActive = false
+	removed.UpdatedAt = timestamppb.New(now)
+	removed.UpdatedBy = deletedBy
+	removed.Version++
+
+	previous := stored.user
+	stored.user, stored.deletedAt = removed, &now
+	m.track(tx, func() {
+		stored.user, stored.deletedAt = previous, nil
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) ListUsers(ctx context.Context, filter ListUsersFilter) ([]*users.User, error) {
+	if _, ok := SortableUserColumns[filter.OrderBy]; !ok {
+		return nil, fmt.Errorf("users cannot be sorted by %s", filter.OrderBy)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	search := strings.ToLower(filter.Search)
+	after := &users.User{UserId: filter.AfterUserID}
+	switch value := filter.AfterValue.(type) {
+	case string:
+		after.Username, after.Email = value, value
+	case time.Time:
+		after.CreatedAt = timestamppb.New(value)
+	}
+
+	result := make([]*users.User, 0)
+	for _, stored := range m.users {
+		user := stored.user
+		if stored.deletedAt != nil {
+			continue
+		}
+
+		if filter.IsActive != nil && user.IsActive != *filter.IsActive {
+			continue
+		}
+
+		if filter.CreatedFrom != nil && user.CreatedAt.AsTime().Before(*filter.CreatedFrom) {
+			continue
+		}
+
+		if filter.CreatedTo != nil && !user.CreatedAt.AsTime().Before(*filter.CreatedTo) {
+			continue
+		}
+
+		if filter.CreatedBy != "" && user.CreatedBy != filter.CreatedBy {
+			continue
+		}
+
+		if search != "" && !strings.HasPrefix(strings.ToLower(user.Username), search) && !strings.HasPrefix(strings.ToLower(user.Email), search) {
+			continue
+		}
+
+		if filter.AfterUserID != 0 {
+			cmp := compareUsers(user, after, filter.OrderBy)
+			if (!filter.Descending && cmp <= 0) || (filter.Descending && cmp >= 0) {
+				continue
+			}
+		}
+
+		result = append(result, snapshot(stored))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		cmp := compareUsers(result[i], result[j], filter.OrderBy)
+		if filter.Descending {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	})
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result, nil
+}
+
+// compareUsers compares two users by (orderBy, user_id) like the ListUsers keyset
+func compareUsers(a, b *users.User, orderBy string) int {
+	cmp := 0
+	switch orderBy {
+	case "username":
+		cmp = strings.Compare(a.Username, b.Username)
+	case "email":
+		cmp = strings.Compare(a.Email, b.Email)
+	case "created_at":
+		cmp = a.CreatedAt.AsTime().Compare(b.CreatedAt.AsTime())
+	}
+
+	if cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case a.UserId < b.UserId:
+		return -1
+	case a.UserId > b.UserId:
+		return 1
+	}
+
+	return 0
+}
+
+func (m *MemoryDB) GetDeletedUserByID(ctx context.Context, userID uint64) (*users.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return snapshot(stored), nil
+}
+
+func (m *MemoryDB) RestoreUser(ctx context.Context, tx Tx, userID uint64, restoredBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt == nil {
+		return sql.ErrNoRows
+	}
+
+	restored := proto.Clone(stored.user).(*users.User)
+	restored.IsActive = true
+	restored.UpdatedAt = timestamppb.New(time.Now().UTC())
+	restored.UpdatedBy = restoredBy
+	restored.Version++
+
+	previous, deletedAt := stored.user, stored.deletedAt
+	stored.user, stored.deletedAt = restored, nil
+	m.track(tx, func() {
+		stored.user, stored.deletedAt = previous, deletedAt
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) UpdatePassword(ctx context.Context, tx Tx, userID uint64, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt != nil {
+		return sql.ErrNoRows
+	}
+
+	updated := proto.Clone(stored.user).(*users.User)
+	updated.Password = password
+	updated.UpdatedAt = timestamppb.New(time.Now().UTC())
+	updated.UpdatedBy = strconv.FormatUint(userID, 10)
+	updated.Version++
+
+	previous := stored.user
+	stored.user = updated
+	m.track(tx, func() {
+		stored.user = previous
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) VerifyEmail(ctx context.Context, tx Tx, userID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok || stored.deletedAt != nil {
+		return sql.ErrNoRows
+	}
+
+	if stored.user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	verified := proto.Clone(stored.user).(*users.User)
+	verified.EmailVerifiedAt = timestamppb.New(time.Now().UTC())
+
+	previous := stored.user
+	stored.user = verified
+	m.track(tx, func() {
+		stored.user = previous
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for userID, stored := range m.users {
+		if stored.deletedAt == nil || !stored.deletedAt.Before(deletedBefore) {
+			continue
+		}
+
+		for sessionID, session := range m.sessions {
+			if session.UserID == userID {
+				delete(m.sessions, sessionID)
+			}
+		}
+
+		for tokenID, token := range m.tokens {
+			if token.UserID == userID {
+				delete(m.tokens, tokenID)
+			}
+		}
+
+		for tokenHash, token := range m.userTokens {
+			if token.UserID == userID {
+				delete(m.userTokens, tokenHash)
+			}
+		}
+
+		delete(m.totp, userID)
+		delete(m.recovery, userID)
+		delete(m.attempts, LoginAttemptScopeUser+":"+strconv.FormatUint(userID, 10))
+		delete(m.users, userID)
+		purged++
+	}
+
+	return purged, nil
+}
+
+func (m *MemoryDB) SaveSession(ctx context.Context, tx Tx, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[session.SessionID]; ok {
+		return fmt.Errorf("session %s already exists", session.SessionID)
+	}
+
+	now := time.Now().UTC()
+
+	saved := *session
+	saved.CreatedAt = now
+	saved.LastSeenAt = now
+
+	m.sessions[saved.SessionID] = &saved
+	m.track(tx, func() {
+		delete(m.sessions, saved.SessionID)
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) TouchSession(ctx context.Context, tx Tx, sessionID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.RevokedAt.Valid {
+		return nil
+	}
+
+	previous := *session
+	session.LastSeenAt = time.Now().UTC()
+	session.ExpiresAt = expiresAt
+	m.track(tx, func() {
+		*session = previous
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[sessionID]
+
+	return ok && !session.RevokedAt.Valid && session.ExpiresAt.After(time.Now().UTC()), nil
+}
+
+func (m *MemoryDB) GetSessionsByUserID(ctx context.Context, userID uint64) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().UTC()
+
+	result := make([]Session, 0)
+	for _, session := range m.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid && session.ExpiresAt.After(now) {
+			result = append(result, *session)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+func (m *MemoryDB) RevokeSession(ctx context.Context, userID uint64, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	session, ok := m.sessions[sessionID]
+	if ok && session.UserID == userID && !session.RevokedAt.Valid {
+		session.RevokedAt = now
+	}
+
+	m.revokeTokens(now, func(token *RefreshToken) bool {
+		return token.Family == sessionID
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) RevokeAllSessions(ctx context.Context, tx Tx, userID uint64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	var sessions []*Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid {
+			session.RevokedAt = now
+			sessions = append(sessions, session)
+		}
+	}
+
+	tokens := m.revokeTokens(now, func(token *RefreshToken) bool {
+		return token.UserID == userID
+	})
+
+	m.track(tx, func() {
+		for _, session := range sessions {
+			session.RevokedAt = sql.NullTime{}
+		}
+		for _, token := range tokens {
+			token.RevokedAt = sql.NullTime{}
+		}
+	})
+
+	return int64(len(sessions)), nil
+}
+
+func (m *MemoryDB) SaveRefreshToken(ctx context.Context, tx Tx, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[token.TokenID]; ok {
+		return fmt.Errorf("renew token %s already exists", token.TokenID)
+	}
+
+	saved := *token
+	saved.CreatedAt = time.Now().UTC()
+
+	m.tokens[saved.TokenID] = &saved
+	m.track(tx, func() {
+		delete(m.tokens, saved.TokenID)
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) GetRefreshToken(ctx context.Context, tokenID string) (*RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.tokens[tokenID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	result := *token
+
+	return &result, nil
+}
+
+func (m *MemoryDB) UseRefreshToken(ctx context.Context, tx Tx, tokenID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[tokenID]
+	if !ok || token.UsedAt.Valid || token.RevokedAt.Valid {
+		return false, nil
+	}
+
+	token.UsedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	m.track(tx, func() {
+		token.UsedAt = sql.NullTime{}
+	})
+
+	return true, nil
+}
+
+func (m *MemoryDB) RevokeRefreshTokenFamily(ctx context.Context, family string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeTokens(sql.NullTime{Time: time.Now().UTC(), Valid: true}, func(token *RefreshToken) bool {
+		return token.Family == family
+	})
+
+	return nil
+}
+
+// revokeTokens revokes the renew tokens matching fn, m.mu must be held
+// revokeTokens revokes the renew tokens matching fn and returns them
+func (m *MemoryDB) revokeTokens(now sql.NullTime, fn func(token *RefreshToken) bool) []*RefreshToken {
+	var revoked []*RefreshToken
+	for _, token := range m.tokens {
+		if !token.RevokedAt.Valid && fn(token) {
+			token.RevokedAt = now
+			revoked = append(revoked, token)
+		}
+	}
+
+	return revoked
+}
+
+func (m *MemoryDB) SaveUserToken(ctx context.Context, tx Tx, token *UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.userTokens[token.TokenHash]; ok {
+		return fmt.Errorf("user token already exists")
+	}
+
+	saved := *token
+	saved.CreatedAt = time.Now().UTC()
+
+	m.userTokens[saved.TokenHash] = &saved
+	m.track(tx, func() {
+		delete(m.userTokens, saved.TokenHash)
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) UseUserToken(ctx context.Context, tx Tx, tokenHash, purpose string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	token, ok := m.userTokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt.Valid || !token.ExpiresAt.After(now) {
+		return 0, sql.ErrNoRows
+	}
+
+	token.UsedAt = sql.NullTime{Time: now, Valid: true}
+	m.track(tx, func() {
+		token.UsedAt = sql.NullTime{}
+	})
+
+	return token.UserID, nil
+}
+
+func (m *MemoryDB) InvalidateUserTokens(ctx context.Context, tx Tx, userID uint64, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	for _, token := range m.userTokens {
+		if token.UserID != userID || token.Purpose != purpose || token.UsedAt.Valid {
+			continue
+		}
+
+		token.UsedAt = now
+		invalidated := token
+		m.track(tx, func() {
+			invalidated.UsedAt = sql.NullTime{}
+		})
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) SaveTOTP(ctx context.Context, tx Tx, userID uint64, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, ok := m.totp[userID]
+	if ok && previous.EnabledAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	m.totp[userID] = &TOTP{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.track(tx, func() {
+		if ok {
+			m.totp[userID] = previous
+			return
+		}
+
+		delete(m.totp, userID)
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) GetTOTP(ctx context.Context, userID uint64) (*TOTP, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.totp[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	result := *stored
+
+	return &result, nil
+}
+
+func (m *MemoryDB) EnableTOTP(ctx context.Context, tx Tx, userID uint64, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.totp[userID]
+	if !ok || stored.EnabledAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	lastCounter := stored.LastCounter
+	stored.EnabledAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	stored.LastCounter = counter
+	m.track(tx, func() {
+		stored.EnabledAt = sql.NullTime{}
+		stored.LastCounter = lastCounter
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) UseTOTPCounter(ctx context.Context, userID uint64, counter int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.totp[userID]
+	if !ok || !stored.EnabledAt.Valid || stored.LastCounter >= counter {
+		return false, nil
+	}
+
+	stored.LastCounter = counter
+
+	return true, nil
+}
+
+func (m *MemoryDB) SaveRecoveryCodes(ctx context.Context, tx Tx, userID uint64, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, ok := m.recovery[userID]
+
+	codes := make(map[string]*sql.NullTime, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes[codeHash] = &sql.NullTime{}
+	}
+
+	m.recovery[userID] = codes
+	m.track(tx, func() {
+		if ok {
+			m.recovery[userID] = previous
+			return
+		}
+
+		delete(m.recovery, userID)
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) UseRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usedAt, ok := m.recovery[userID][codeHash]
+	if !ok || usedAt.Valid {
+		return false, nil
+	}
+
+	*usedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	return true, nil
+}
+
+func (m *MemoryDB) GetLoginAttempt(ctx context.Context, scope, subject string) (*LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.attempts[scope+":"+subject]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	result := *stored
+
+	return &result, nil
+}
+
+func (m *MemoryDB) RecordLoginFailure(ctx context.Context, scope, subject string, windowStart time.Time) (*LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	stored, ok := m.attempts[scope+":"+subject]
+	if !ok {
+		stored = &LoginAttempt{Scope: scope, Subject: subject}
+		m.attempts[scope+":"+subject] = stored
+	}
+
+	if stored.LastFailedAt.Before(windowStart) && (!stored.LockedUntil.Valid || stored.LockedUntil.Time.Before(windowStart)) {
+		stored.Failures = 0
+	}
+
+	stored.Failures++
+	stored.LastFailedAt = now
+
+	result := *stored
+
+	return &result, nil
+}
+
+func (m *MemoryDB) LockLoginAttempt(ctx context.Context, scope, subject string, lockedUntil time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.attempts[scope+":"+subject]; ok {
+		stored.LockedUntil = sql.NullTime{Time: lockedUntil, Valid: true}
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) ClearLoginAttempt(ctx context.Context, scope, subject string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.attempts[scope+":"+subject]
+	delete(m.attempts, scope+":"+subject)
+
+	return ok, nil
+}
+
+func (m *MemoryDB) SaveOutboxEvent(ctx context.Context, tx Tx, event *OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastEvent++
+	event.ID = m.lastEvent
+
+	stored := *event
+	stored.Payload = append([]byte(nil), event.Payload...)
+	m.outbox = append(m.outbox, &stored)
+
+	m.track(tx, func() {
+		for i, e := range m.outbox {
+			if e.ID == stored.ID {
+				m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+				break
+			}
+		}
+	})
+
+	return nil
+}
+
+// ClaimOutboxEvents returns the pending events ordered by user then write order,
+// the memory driver runs a single relay so nothing is locked
+func (m *MemoryDB) ClaimOutboxEvents(ctx context.Context, tx Tx, limit int) ([]OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]OutboxEvent, 0)
+	for _, stored := range m.outbox {
+		if !stored.PublishedAt.Valid && !stored.FailedAt.Valid {
+			result = append(result, *stored)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].UserID < result[j].UserID
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (m *MemoryDB) MarkOutboxEventPublished(ctx context.Context, tx Tx, id int64) error {
+	return m.updateOutboxEvent(tx, id, func(event *OutboxEvent) {
+		event.Attempts++
+		event.LastError = sql.NullString{}
+		event.PublishedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	})
+}
+
+func (m *MemoryDB) MarkOutboxEventFailed(ctx context.Context, tx Tx, id int64, reason string, deadLetter bool) error {
+	return m.updateOutboxEvent(tx, id, func(event *OutboxEvent) {
+		event.Attempts++
+		event.LastError = sql.NullString{String: reason, Valid: true}
+		if deadLetter {
+			event.FailedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		}
+	})
+}
+
+// updateOutboxEvent changes a stored event, reverted when tx is rolled back
+func (m *MemoryDB) updateOutboxEvent(tx Tx, id int64, update func(event *OutboxEvent)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.outbox {
+		if stored.ID != id {
+			continue
+		}
+
+		previous := *stored
+		update(stored)
+		m.track(tx, func() { *stored = previous })
+
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryDB) PurgeOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.outbox[:0]
+	for _, stored := range m.outbox {
+		if !stored.PublishedAt.Valid || !stored.PublishedAt.Time.Before(publishedBefore) {
+			kept = append(kept, stored)
+		}
+	}
+
+	purged := int64(len(m.outbox) - len(kept))
+	m.outbox = kept
+
+	return purged, nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMemoryDBPurgeDeletedUsers(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	m := NewMemoryDB(logger)
+	purgedID := saveUser(t, m, "alice")
+	keptID := saveUser(t, m, "bob")
+
+	for _, userID := range []uint64{purgedID, keptID} {
+		_, err := m.RecordLoginFailure(ctx, LoginAttemptScopeUser, strconv.FormatUint(userID, 10), time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("RecordLoginFailure(%d): %v", userID, err)
+		}
+	}
+
+	err := m.RemoveUser(ctx, nil, purgedID, "admin")
+	if err != nil {
+		t.Fatalf("RemoveUser(): %v", err)
+	}
+
+	purged, err := m.PurgeDeletedUsers(ctx, time.Now().Add(time.Second))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers() = %d, %v, want 1 purged", purged, err)
+	}
+
+	_, err = m.GetLoginAttempt(ctx, LoginAttemptScopeUser, strconv.FormatUint(purgedID, 10))
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetLoginAttempt() of the purged user error = %v, want sql.ErrNoRows", err)
+	}
+
+	_, err = m.GetLoginAttempt(ctx, LoginAttemptScopeUser, strconv.FormatUint(keptID, 10))
+	if err != nil {
+		t.Errorf("GetLoginAttempt() of the kept user error = %v", err)
+	}
+}
+
+func TestMemoryDBRevokeAllSessionsRollback(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	m := NewMemoryDB(logger)
+	userID := saveUser(t, m, "alice")
+
+	err := m.SaveSession(ctx, nil, &Session{SessionID: "session-1", UserID: userID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("SaveSession(): %v", err)
+	}
+	err = m.SaveRefreshToken(ctx, nil, &RefreshToken{TokenID: "token-1", Family: "session-1", UserID: userID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("SaveRefreshToken(): %v", err)
+	}
+
+	// revoked in a transaction rolled back, e.g. a password reset failing after the revocation
+	tx, err := m.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin(): %v", err)
+	}
+
+	revoked, err := m.RevokeAllSessions(ctx, tx, userID)
+	if err != nil || revoked != 1 {
+		t.Fatalf("RevokeAllSessions() = %d, %v, want 1 revoked", revoked, err)
+	}
+
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback(): %v", err)
+	}
+
+	active, err := m.IsSessionActive(ctx, "session-1")
+	if err != nil || !active {
+		t.Errorf("IsSessionActive() after the rollback = %t, %v, want active", active, err)
+	}
+	token, err := m.GetRefreshToken(ctx, "token-1")
+	if err != nil || token.RevokedAt.Valid {
+		t.Errorf("GetRefreshToken() after the rollback = %+v, %v, want not revoked", token, err)
+	}
+
+	// committed, it stays revoked
+	tx, err = m.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin(): %v", err)
+	}
+	_, err = m.RevokeAllSessions(ctx, tx, userID)
+	if err != nil {
+		t.Fatalf("RevokeAllSessions(): %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit(): %v", err)
+	}
+
+	active, err = m.IsSessionActive(ctx, "session-1")
+	if err != nil || active {
+		t.Errorf("IsSessionActive() after the commit = %t, %v, want revoked", active, err)
+	}
+}
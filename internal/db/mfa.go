@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TOTP is the authenticator app of a user, Secret is encrypted with KEY.USER
+// LastCounter is the time step of the last accepted code, a code cannot be used twice
+type TOTP struct {
+	UserID      uint64       `db:"user_id"`
+	Secret      string       `db:"secret"`
+	LastCounter int64        `db:"last_counter"`
+	EnabledAt   sql.NullTime `db:"enabled_at"`
+	CreatedAt   time.Time    `db:"created_at"`
+}
+
+// SaveTOTP stores a pending TOTP secret, replacing the previous one not confirmed yet
+// Returns sql.ErrNoRows when TOTP is already enabled for the user
+func (d *DB) SaveTOTP(ctx context.Context, tx Tx, userID uint64, secret string) error {
+	q := NewQuery(`INSERT INTO public.user_totp
+	(user_id, secret, last_counter, created_at)
+	VALUES(?, ?, 0, ?)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_counter = 0, created_at = EXCLUDED.created_at
+	WHERE public.user_totp.enabled_at IS NULL;`, userID, secret, time.Now().UTC())
+
+	res, err := d.exec(ctx, tx, "SaveTOTP", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetTOTP returns the TOTP secret of a user, pending or enabled
+func (d *DB) GetTOTP(ctx context.Context, userID uint64) (*TOTP, error) {
+	var result TOTP
+
+	q := NewQuery(`SELECT user_id, secret, last_counter, enabled_at, created_at FROM public.user_totp WHERE user_id = ?`, userID)
+
+	err := d.get(ctx, d.db.Backend.Write, "GetTOTP", &result, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// EnableTOTP enables the pending TOTP secret of a user with the time step of the confirmed code
+// Returns sql.ErrNoRows when there is no pending secret
+func (d *DB) EnableTOTP(ctx context.Context, tx Tx, userID uint64, counter int64) error {
+	q := NewQuery(`UPDATE public.user_totp SET enabled_at = ?, last_counter = ? WHERE user_id = ? AND enabled_at IS NULL`, time.Now().UTC(), counter, userID)
+
+	res, err := d.exec(ctx, tx, "EnableTOTP", q)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UseTOTPCounter records the time step of an accepted code. Returns false when a code of this or a later step was already used
+func (d *DB) UseTOTPCounter(ctx context.Context, userID uint64, counter int64) (bool, error) {
+	q := NewQuery(`UPDATE public.user_totp SET last_counter = ? WHERE user_id = ? AND enabled_at IS NOT NULL AND last_counter < ?`, counter, userID, counter)
+
+	res, err := d.exec(ctx, nil, "UseTOTPCounter", q)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// SaveRecoveryCodes replaces the recovery codes of a user, codeHashes are the Hash256 of the codes
+func (d *DB) SaveRecoveryCodes(ctx context.Context, tx Tx, userID uint64, codeHashes []string) error {
+	_, err := d.exec(ctx, tx, "SaveRecoveryCodes", NewQuery(`DELETE FROM public.user_recovery_codes WHERE user_id = ?`, userID))
+	if err != nil {
+		return err
+	}
+
+	if len(codeHashes) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	values := make([]*Query, 0, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		values = append(values, NewQuery(`(?, ?, ?)`, userID, codeHash, now))
+	}
+
+	q := NewQuery(`INSERT INTO public.user_recovery_codes (user_id, code_hash, created_at) VALUES`).Join(", ", values...)
+
+	_, err = d.exec(ctx, tx, "SaveRecoveryCodes", q)
+
+	return err
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used. Returns false when the code does not exist or was already used
+func (d *DB) UseRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error) {
+	q := NewQuery(`UPDATE public.user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, time.Now().UTC(), userID, codeHash)
+
+	res, err := d.exec(ctx, nil, "UseRecoveryCode", q)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OutboxEvent is a domain event waiting in the outbox until it is published
+type OutboxEvent struct {
+	ID          int64
+	EventID     string
+	Type        string
+	UserID      uint64
+	Payload     []byte
+	CreatedAt   time.Time
+	Attempts    int
+	LastError   sql.NullString
+	PublishedAt sql.NullTime
+	FailedAt    sql.NullTime
+}
+
+// outboxLockClass is the first key of the advisory locks taken on the users whose events a relay claims
+const outboxLockClass = 8
+
+// SaveOutboxEvent writes an event in tx, it is published only when tx commits
+func (d *DB) SaveOutboxEvent(ctx context.Context, tx Tx, event *OutboxEvent) error {
+	q := NewQuery(`INSERT INTO public.outbox_events
+	(event_id, event_type, user_id, payload, created_at)
+	VALUES(?, ?, ?, ?, ?)
+	returning id;`, event.EventID, event.Type, event.UserID, string(event.Payload), event.CreatedAt)
+
+	row, err := d.queryRow(ctx, tx, "SaveOutboxEvent", q)
+	if err != nil {
+		return err
+	}
+
+	return row.Scan(&event.ID)
+}
+
+// ClaimOutboxEvents returns the pending events ordered by user then write order, neither published nor dead-lettered.
+// The users of the events are locked until tx ends, the users locked by the relay of another instance are skipped
+// so the events of a user are never published by two relays at once
+func (d *DB) ClaimOutboxEvents(ctx context.Context, tx Tx, limit int) ([]OutboxEvent, error) {
+	result := make([]OutboxEvent, 0)
+
+	q := NewQuery(`WITH waiting AS MATERIALIZED (
+		SELECT user_id FROM public.outbox_events
+		WHERE published_at IS NULL AND failed_at IS NULL
+		GROUP BY user_id
+		ORDER BY MIN(id)
+		LIMIT ?
+	), claimed AS MATERIALIZED (
+		SELECT user_id FROM waiting WHERE pg_try_advisory_xact_lock(?, hashtext(user_id::text))
+	)
+	SELECT id, event_id, event_type, user_id, payload, created_at, attempts, last_error, published_at, failed_at
+	FROM public.outbox_events
+	WHERE published_at IS NULL AND failed_at IS NULL AND user_id IN (SELECT user_id FROM claimed)
+	ORDER BY user_id, id
+	LIMIT ?
+	FOR UPDATE`, limit, outboxLockClass, limit)
+
+	rows, err := d.queryTx(ctx, tx, "ClaimOutboxEvents", q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OutboxEvent
+		err = rows.Scan(&event.ID, &event.EventID, &event.Type, &event.UserID, &event.Payload, &event.CreatedAt, &event.Attempts, &event.LastError, &event.PublishedAt, &event.FailedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, event)
+	}
+
+	return result, rows.Err()
+}
+
+// MarkOutboxEventPublished records that an event reached the broker
+func (d *DB) MarkOutboxEventPublished(ctx context.Context, tx Tx, id int64) error {
+	q := NewQuery(`UPDATE public.outbox_events SET published_at = ?, attempts = attempts + 1, last_error = NULL WHERE id = ?`, time.Now().UTC(), id)
+
+	_, err := d.exec(ctx, tx, "MarkOutboxEventPublished", q)
+
+	return err
+}
+
+// MarkOutboxEventFailed counts a failed publication of an event, it is retried by the next run of the relay
+// unless deadLetter, a dead-lettered event keeps its failed_at and is never claimed again
+func (d *DB) MarkOutboxEventFailed(ctx context.Context, tx Tx, id int64, reason string, deadLetter bool) error {
+	var failedAt sql.NullTime
+	if deadLetter {
+		failedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	q := NewQuery(`UPDATE public.outbox_events SET attempts = attempts + 1, last_error = ?, failed_at = ? WHERE id = ?`, reason, failedAt, id)
+
+	_, err := d.exec(ctx, tx, "MarkOutboxEventFailed", q)
+
+	return err
+}
+
+// PurgeOutboxEvents deletes the events published before the given time. Returns the number of deleted events
+func (d *DB) PurgeOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	q := NewQuery(`DELETE FROM public.outbox_events WHERE published_at IS NOT NULL AND published_at < ?`, publishedBefore)
+
+	res, err := d.exec(ctx, nil, "PurgeOutboxEvents", q)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
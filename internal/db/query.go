@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/febriandani/backend-user-service/internal/db")
+
+// SQL is the text of a query. It is a distinct type so that only string literals & constants convert to it
+// implicitly, a value coming from a request has to go through the placeholders of NewQuery & Append.
+// Converting a variable with SQL(...) is only allowed for whitelisted identifiers like SortableUserColumns
+type SQL string
+
+// Query is a statement with its arguments, written with ? placeholders and rebound for the driver on Build
+type Query struct {
+	sql  strings.Builder
+	args []interface{}
+}
+
+// NewQuery starts a query, every value must be passed in args with a ? placeholder
+func NewQuery(sql SQL, args ...interface{}) *Query {
+	q := &Query{}
+	q.sql.WriteString(string(sql))
+	q.args = append(q.args, args...)
+
+	return q
+}
+
+// Append adds a fragment and its arguments at the end of the query
+func (q *Query) Append(sql SQL, args ...interface{}) *Query {
+	q.sql.WriteString(" ")
+	q.sql.WriteString(string(sql))
+	q.args = append(q.args, args...)
+
+	return q
+}
+
+// Join adds the fragments separated by sep, used for dynamic lists of conditions or assignments
+func (q *Query) Join(sep SQL, fragments ...*Query) *Query {
+	q.sql.WriteString(" ")
+	for i, fragment := range fragments {
+		if i > 0 {
+			q.sql.WriteString(string(sep))
+		}
+
+		q.sql.WriteString(fragment.sql.String())
+		q.args = append(q.args, fragment.args...)
+	}
+
+	return q
+}
+
+// Build expands slice arguments of IN (?) and rebinds the placeholders for the database driver
+func (q *Query) Build(db infra.Database) (string, []interface{}, error) {
+	query, args, err := db.In(q.sql.String(), q.args...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return db.Rebind(query), args, nil
+}
+
+// startSpan starts the span of a statement, named after the repository method running it
+func startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(name), semconv.DBStatement(query)),
+	)
+}
+
+// recordError marks the span of a failed statement, no rows is an answer and not a failure
+func recordError(span trace.Span, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// exec runs a statement in tx, or on the write database when tx is nil
+func (d *DB) exec(ctx context.Context, tx Tx, name string, q *Query) (sql.Result, error) {
+	query, args, err := q.Build(d.db.Backend.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.WithContext(ctx).WithField("QueryDebug : ", query).Infof("Query %s", name)
+
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	var result sql.Result
+	if sqlTx := toSQLTx(tx); sqlTx != nil {
+		result, err = sqlTx.ExecContext(ctx, query, args...)
+	} else {
+		result, err = d.db.Backend.Write.ExecContext(ctx, query, args...)
+	}
+	recordError(span, err)
+
+	return result, err
+}
+
+// queryRow runs a query returning one row in tx, or on the write database when tx is nil
+func (d *DB) queryRow(ctx context.Context, tx Tx, name string, q *Query) (*sql.Row, error) {
+	query, args, err := q.Build(d.db.Backend.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.WithContext(ctx).WithField("QueryDebug : ", query).Infof("Query %s", name)
+
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	var row *sql.Row
+	if sqlTx := toSQLTx(tx); sqlTx != nil {
+		row = sqlTx.QueryRowContext(ctx, query, args...)
+	} else {
+		row = d.db.Backend.Write.QueryRow(ctx, query, args...)
+	}
+	recordError(span, row.Err())
+
+	return row, nil
+}
+
+// queryTx runs a query returning rows in tx, or on the write database when tx is nil
+func (d *DB) queryTx(ctx context.Context, tx Tx, name string, q *Query) (*sql.Rows, error) {
+	query, args, err := q.Build(d.db.Backend.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.WithContext(ctx).WithField("QueryDebug : ", query).Infof("Query %s", name)
+
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	var rows *sql.Rows
+	if sqlTx := toSQLTx(tx); sqlTx != nil {
+		rows, err = sqlTx.QueryContext(ctx, query, args...)
+	} else {
+		rows, err = d.db.Backend.Write.QueryContext(ctx, query, args...)
+	}
+	recordError(span, err)
+
+	return rows, err
+}
+
+// query runs a query returning rows on db, the read or the write database
+func (d *DB) query(ctx context.Context, db infra.Database, name string, q *Query) (*sql.Rows, error) {
+	query, args, err := q.Build(db)
+	if err != nil {
+		return nil, err
+	}
+
+	d.log.WithContext(ctx).WithField("QueryDebug : ", query).Infof("Query %s", name)
+
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	recordError(span, err)
+
+	return rows, err
+}
+
+// get scans one row into dest with sqlx on db, the read or the write database
+func (d *DB) get(ctx context.Context, db infra.Database, name string, dest interface{}, q *Query) error {
+	query, args, err := q.Build(db)
+	if err != nil {
+		return err
+	}
+
+	d.log.WithContext(ctx).WithField("QueryDebug : ", query).Infof("Query %s", name)
+
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	err = db.GetContext(ctx, dest, query, args...)
+	recordError(span, err)
+
+	return err
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/jmoiron/sqlx"
+)
+
+// injectionPayloads are classic SQL injection inputs, none of them may ever reach the text of a statement
+var injectionPayloads = []string{
+	`' OR '1'='1`,
+	`' OR 1=1 --`,
+	`admin'--`,
+	`'; DROP TABLE public.users; --`,
+	`" OR ""="`,
+	`1; SELECT pg_sleep(10)`,
+	`') OR ('a'='a`,
+	`' UNION SELECT user_id, password FROM public.users --`,
+	`%' OR username LIKE '%`,
+	`\'; DELETE FROM public.users WHERE '1'='1`,
+	"'||(SELECT version())||'",
+	"x'; UPDATE public.users SET is_active = true WHERE ''='",
+}
+
+// postgres is an infra.Database rebinding like the server, Build never opens a connection
+func postgres() infra.Database {
+	return &infra.DBHandler{DB: sqlx.NewDb(nil, "postgres")}
+}
+
+func TestQueryBuild(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    *Query
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "placeholders rebound",
+			query:    NewQuery(`SELECT 1 FROM public.users WHERE username = ? AND email = ?`, "alice", "alice@example.com"),
+			wantSQL:  `SELECT 1 FROM public.users WHERE username = $1 AND email = $2`,
+			wantArgs: []interface{}{"alice", "alice@example.com"},
+		},
+		{
+			name:     "append keeps the order",
+			query:    NewQuery(`SELECT 1 FROM public.users WHERE username = ?`, "alice").Append(`LIMIT ?`, 10),
+			wantSQL:  `SELECT 1 FROM public.users WHERE username = $1 LIMIT $2`,
+			wantArgs: []interface{}{"alice", 10},
+		},
+		{
+			name: "join of conditions",
+			query: NewQuery(`SELECT 1 FROM public.users WHERE`).
+				Join(` AND `, NewQuery(`deleted_at IS NULL`), NewQuery(`is_active = ?`, true), NewQuery(`created_by = ?`, "system")),
+			wantSQL:  `SELECT 1 FROM public.users WHERE deleted_at IS NULL AND is_active = $1 AND created_by = $2`,
+			wantArgs: []interface{}{true, "system"},
+		},
+		{
+			name:     "slice expanded for IN",
+			query:    NewQuery(`SELECT 1 FROM public.users WHERE user_id IN (?) AND username = ?`, []uint64{1, 2, 3}, "alice"),
+			wantSQL:  `SELECT 1 FROM public.users WHERE user_id IN ($1, $2, $3) AND username = $4`,
+			wantArgs: []interface{}{uint64(1), uint64(2), uint64(3), "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := tt.query.Build(postgres())
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if query != tt.wantSQL {
+				t.Errorf("Build() sql = %q, want %q", query, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("Build() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestQueryBuildKeepsPayloadsInArgs(t *testing.T) {
+	for _, payload := range injectionPayloads {
+		t.Run(payload, func(t *testing.T) {
+			q := NewQuery(`SELECT 1 FROM public.users WHERE username = ?`, payload).
+				Join(` OR `, NewQuery(`email = ?`, payload)).
+				Append(`AND created_by IN (?)`, []string{payload, "system"})
+
+			query, args, err := q.Build(postgres())
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if strings.Contains(query, payload) {
+				t.Errorf("Build() sql %q contains the payload", query)
+			}
+
+			want := []interface{}{payload, payload, payload, "system"}
+			if !reflect.DeepEqual(args, want) {
+				t.Errorf("Build() args = %#v, want %#v", args, want)
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "alice", want: "alice"},
+		{value: "100%", want: `100\%`},
+		{value: "a_b", want: `a\_b`},
+		{value: `back\slash`, want: `back\\slash`},
+		{value: `%' OR username LIKE '%`, want: `\%' OR username LIKE '\%`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLike(tt.value); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+)
+
+// Tx is a transaction started by Repository.Begin, a nil Tx runs the statement on its own
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// UserRepository stores the users, soft deleted users are hidden from every method except the deleted ones
+type UserRepository interface {
+	SaveUser(ctx context.Context, tx Tx, user *users.User) (int64, error)
+	CheckIsExistUser(ctx context.Context, user *users.User) (bool, error)
+	CheckIsExistOtherUser(ctx context.Context, user *users.User) (bool, error)
+	GetUserByID(ctx context.Context, userID uint64) (*users.User, error)
+	GetUserByIDs(ctx context.Context, userIDs []uint64) ([]*users.User, error)
+	GetUserByEmailOrUsername(ctx context.Context, data string) (*users.User, error)
+	UpdateUser(ctx context.Context, tx Tx, user *users.User, fields []string) (int64, error)
+	RemoveUser(ctx context.Context, tx Tx, userID uint64, deletedBy string) error
+	ListUsers(ctx context.Context, filter ListUsersFilter) ([]*users.User, error)
+	GetDeletedUserByID(ctx context.Context, userID uint64) (*users.User, error)
+	RestoreUser(ctx context.Context, tx Tx, userID uint64, restoredBy string) error
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+	UpdatePassword(ctx context.Context, tx Tx, userID uint64, password string) error
+	VerifyEmail(ctx context.Context, tx Tx, userID uint64) error
+}
+
+// SessionRepository stores the login sessions
+type SessionRepository interface {
+	SaveSession(ctx context.Context, tx Tx, session *Session) error
+	TouchSession(ctx context.Context, tx Tx, sessionID string, expiresAt time.Time) error
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+	GetSessionsByUserID(ctx context.Context, userID uint64) ([]Session, error)
+	RevokeSession(ctx context.Context, userID uint64, sessionID string) error
+	RevokeAllSessions(ctx context.Context, tx Tx, userID uint64) (int64, error)
+}
+
+// TokenRepository stores the renew tokens
+type TokenRepository interface {
+	SaveRefreshToken(ctx context.Context, tx Tx, token *RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenID string) (*RefreshToken, error)
+	UseRefreshToken(ctx context.Context, tx Tx, tokenID string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, family string) error
+}
+
+// UserTokenRepository stores the single-use tokens sent to users
+type UserTokenRepository interface {
+	SaveUserToken(ctx context.Context, tx Tx, token *UserToken) error
+	UseUserToken(ctx context.Context, tx Tx, tokenHash, purpose string) (uint64, error)
+	InvalidateUserTokens(ctx context.Context, tx Tx, userID uint64, purpose string) error
+}
+
+// MFARepository stores the TOTP secrets and recovery codes of the users
+type MFARepository interface {
+	SaveTOTP(ctx context.Context, tx Tx, userID uint64, secret string) error
+	GetTOTP(ctx context.Context, userID uint64) (*TOTP, error)
+	EnableTOTP(ctx context.Context, tx Tx, userID uint64, counter int64) error
+	UseTOTPCounter(ctx context.Context, userID uint64, counter int64) (bool, error)
+	SaveRecoveryCodes(ctx context.Context, tx Tx, userID uint64, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error)
+}
+
+// LoginAttemptRepository counts the failed logins per account and per client ip
+type LoginAttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, scope, subject string) (*LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, scope, subject string, windowStart time.Time) (*LoginAttempt, error)
+	LockLoginAttempt(ctx context.Context, scope, subject string, lockedUntil time.Time) error
+	ClearLoginAttempt(ctx context.Context, scope, subject string) (bool, error)
+}
+
+// OutboxRepository stores the domain events until the relay publishes them
+type OutboxRepository interface {
+	SaveOutboxEvent(ctx context.Context, tx Tx, event *OutboxEvent) error
+	ClaimOutboxEvents(ctx context.Context, tx Tx, limit int) ([]OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, tx Tx, id int64) error
+	MarkOutboxEventFailed(ctx context.Context, tx Tx, id int64, reason string, deadLetter bool) error
+	PurgeOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+// Repository is everything the user service stores, implemented by DB (Postgres) and MemoryDB
+type Repository interface {
+	// Begin starts a transaction shared by the methods taking a Tx
+	Begin(ctx context.Context) (Tx, error)
+
+	UserRepository
+	SessionRepository
+	TokenRepository
+	UserTokenRepository
+	MFARepository
+	LoginAttemptRepository
+	OutboxRepository
+}
+
+var (
+	_ Repository = (*DB)(nil)
+	_ Repository = (*MemoryDB)(nil)
+	_ Repository = (*CachedRepository)(nil)
+)
+
+// toSQLTx returns the *sql.Tx of a transaction started by DB.Begin, nil when there is no transaction
+func toSQLTx(tx Tx) *sql.Tx {
+	sqlTx, _ := unwrapTx(tx).(*sql.Tx)
+	return sqlTx
+}
+
+// unwrapTx returns the transaction started by the Repository under the decorators such as CachedRepository
+func unwrapTx(tx Tx) Tx {
+	for {
+		wrapped, ok := tx.(interface{ Unwrap() Tx })
+		if !ok {
+			return tx
+		}
+
+		tx = wrapped.Unwrap()
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is a login of a user on one device, its id is the jti of the access tokens issued for it
+type Session struct {
+	SessionID  string       `db:"session_id"`
+	UserID     uint64       `db:"user_id"`
+	UserAgent  string       `db:"user_agent"`
+	IPAddress  string       `db:"ip_address"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastSeenAt time.Time    `db:"last_seen_at"`
+	ExpiresAt  time.Time    `db:"expires_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+}
+
+// SaveSession stores a new session created on login
+func (d *DB) SaveSession(ctx context.Context, tx Tx, session *Session) error {
+	now := time.Now().UTC()
+
+	q := NewQuery(`INSERT INTO public.user_sessions
+	(session_id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at)
+	VALUES(?, ?, ?, ?, ?, ?, ?);`, session.SessionID, session.UserID, session.UserAgent, session.IPAddress, now, now, session.ExpiresAt)
+
+	_, err := d.exec(ctx, tx, "SaveSession", q)
+
+	return err
+}
+
+// TouchSession extends a session when its renew token is rotated
+func (d *DB) TouchSession(ctx context.Context, tx Tx, sessionID string, expiresAt time.Time) error {
+	q := NewQuery(`UPDATE public.user_sessions SET last_seen_at = ?, expires_at = ? WHERE session_id = ? AND revoked_at IS NULL`, time.Now().UTC(), expiresAt, sessionID)
+
+	_, err := d.exec(ctx, tx, "TouchSession", q)
+
+	return err
+}
+
+// IsSessionActive returns true when the session exists, is not revoked and not expired
+func (d *DB) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var res bool
+
+	q := NewQuery(`SELECT exists(SELECT 1 FROM public.user_sessions s WHERE s.session_id = ? AND s.revoked_at IS NULL AND s.expires_at > ?)`, sessionID, time.Now().UTC())
+
+	err := d.get(ctx, d.db.Backend.Write, "IsSessionActive", &res, q)
+	if err != nil {
+		return false, err
+	}
+
+	return res, nil
+}
+
+// GetSessionsByUserID returns the active sessions of a user, newest first
+func (d *DB) GetSessionsByUserID(ctx context.Context, userID uint64) ([]Session, error) {
+	result := make([]Session, 0)
+
+	q := NewQuery(`SELECT session_id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at, revoked_at
+	FROM public.user_sessions
+	WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC`, userID, time.Now().UTC())
+
+	rows, err := d.query(ctx, d.db.Backend.Write, "GetSessionsByUserID", q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.SessionID, &session.UserID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, session)
+	}
+
+	return result, rows.Err()
+}
+
+// RevokeSession revokes one session of a user together with its renew tokens
+func (d *DB) RevokeSession(ctx context.Context, userID uint64, sessionID string) error {
+	q := NewQuery(`UPDATE public.user_sessions SET revoked_at = ? WHERE user_id = ? AND session_id = ? AND revoked_at IS NULL`, time.Now().UTC(), userID, sessionID)
+
+	_, err := d.exec(ctx, nil, "RevokeSession", q)
+	if err != nil {
+		return err
+	}
+
+	return d.RevokeRefreshTokenFamily(ctx, sessionID)
+}
+
+// RevokeAllSessions revokes every session of a user together with their renew tokens, in tx when it is not nil.
+// Returns the number of revoked sessions
+func (d *DB) RevokeAllSessions(ctx context.Context, tx Tx, userID uint64) (int64, error) {
+	now := time.Now().UTC()
+
+	res, err := d.exec(ctx, tx, "RevokeAllSessions", NewQuery(`UPDATE public.user_sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID))
+	if err != nil {
+		return 0, err
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = d.exec(ctx, tx, "RevokeAllSessions", NewQuery(`UPDATE public.user_refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID))
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RefreshToken is a renew token issued to a user, one row per token in a rotation family
+type RefreshToken struct {
+	TokenID   string       `db:"token_id"`
+	Family    string       `db:"family"`
+	UserID    uint64       `db:"user_id"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// SaveRefreshToken stores a newly issued renew token so it can be rotated exactly once
+func (d *DB) SaveRefreshToken(ctx context.Context, tx Tx, token *RefreshToken) error {
+	q := NewQuery(`INSERT INTO public.user_refresh_tokens
+	(token_id, family, user_id, expires_at, created_at)
+	VALUES(?, ?, ?, ?, ?);`, token.TokenID, token.Family, token.UserID, token.ExpiresAt, time.Now().UTC())
+
+	_, err := d.exec(ctx, tx, "SaveRefreshToken", q)
+
+	return err
+}
+
+// GetRefreshToken returns a renew token by its token id (the jti claim)
+func (d *DB) GetRefreshToken(ctx context.Context, tokenID string) (*RefreshToken, error) {
+	var result RefreshToken
+
+	q := NewQuery(`SELECT token_id, family, user_id, expires_at, used_at, revoked_at, created_at FROM public.user_refresh_tokens WHERE token_id = ?`, tokenID)
+
+	err := d.get(ctx, d.db.Backend.Write, "GetRefreshToken", &result, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UseRefreshToken marks a renew token as rotated. Returns false when the token was already used or revoked
+func (d *DB) UseRefreshToken(ctx context.Context, tx Tx, tokenID string) (bool, error) {
+	q := NewQuery(`UPDATE public.user_refresh_tokens SET used_at = ? WHERE token_id = ? AND used_at IS NULL AND revoked_at IS NULL`, time.Now().UTC(), tokenID)
+
+	res, err := d.exec(ctx, tx, "UseRefreshToken", q)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every renew token issued from the same login
+func (d *DB) RevokeRefreshTokenFamily(ctx context.Context, family string) error {
+	q := NewQuery(`UPDATE public.user_refresh_tokens SET revoked_at = ? WHERE family = ? AND revoked_at IS NULL`, time.Now().UTC(), family)
+
+	_, err := d.exec(ctx, nil, "RevokeRefreshTokenFamily", q)
+
+	return err
+}
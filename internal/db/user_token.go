@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Purposes of the tokens sent to users
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken is a single-use token sent to a user, only the sha256 of the token is stored
+type UserToken struct {
+	TokenHash string       `db:"token_hash"`
+	UserID    uint64       `db:"user_id"`
+	Purpose   string       `db:"purpose"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// SaveUserToken stores a token sent to a user
+func (d *DB) SaveUserToken(ctx context.Context, tx Tx, token *UserToken) error {
+	q := NewQuery(`INSERT INTO public.user_tokens
+	(token_hash, user_id, purpose, expires_at, created_at)
+	VALUES(?, ?, ?, ?, ?);`, token.TokenHash, token.UserID, token.Purpose, token.ExpiresAt, time.Now().UTC())
+
+	_, err := d.exec(ctx, tx, "SaveUserToken", q)
+
+	return err
+}
+
+// UseUserToken marks an unused and unexpired token as used and returns its user id
+// Returns sql.ErrNoRows when the token does not exist, has another purpose, is expired or was already used
+func (d *DB) UseUserToken(ctx context.Context, tx Tx, tokenHash, purpose string) (uint64, error) {
+	now := time.Now().UTC()
+
+	q := NewQuery(`UPDATE public.user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? returning user_id;`, now, tokenHash, purpose, now)
+
+	res, err := d.queryRow(ctx, tx, "UseUserToken", q)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID uint64
+	err = res.Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// InvalidateUserTokens marks the unused tokens of a user for a purpose as used, so only the latest one sent is valid
+func (d *DB) InvalidateUserTokens(ctx context.Context, tx Tx, userID uint64, purpose string) error {
+	q := NewQuery(`UPDATE public.user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`, time.Now().UTC(), userID, purpose)
+
+	_, err := d.exec(ctx, tx, "InvalidateUserTokens", q)
+
+	return err
+}
@@ -0,0 +1,140 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// AMQPPublisher publishes the events to the AMQP.EXCHANGE topic exchange with the event type as routing key
+// Messages are persistent and every publication waits for the confirm of the broker
+type AMQPPublisher struct {
+	mu   sync.Mutex
+	conf infra.AMQPConfig
+	log  *logrus.Logger
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// NewAMQPPublisher creates the AMQP publisher, a broker down at startup is only logged and dialed again on the next publication
+func NewAMQPPublisher(conf infra.AMQPConfig, logger *logrus.Logger) (*AMQPPublisher, error) {
+	if conf.URL == "" || conf.Exchange == "" {
+		return nil, fmt.Errorf("event publisher amqp needs AMQP.URL and AMQP.EXCHANGE")
+	}
+
+	p := &AMQPPublisher{
+		conf: conf,
+		log:  logger,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.channel()
+	if err != nil {
+		logger.WithError(err).Errorf("AMQPPublisher | Failed to connect, events wait in the outbox")
+	}
+
+	return p, nil
+}
+
+// channel returns the confirm channel, connecting again after the connection or the channel was closed. p.mu must be held
+func (p *AMQPPublisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := infra.NewAMQPConnection(p.conf, p.log)
+		if err != nil {
+			return nil, err
+		}
+
+		p.conn = conn
+	}
+
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	p.ch = ch
+
+	return ch, nil
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.conf.Exchange, msg.Type, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Type:         msg.Type,
+		Timestamp:    msg.OccurredAt,
+		Body:         body,
+	})
+	if err != nil {
+		p.resetChannel()
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// the confirm may still come on this channel, a new one keeps the next confirms in order
+		p.resetChannel()
+		return err
+	}
+
+	if !acked {
+		return fmt.Errorf("event %s was not accepted by the broker", msg.ID)
+	}
+
+	return nil
+}
+
+// resetChannel drops the channel, the next publication opens a new one. p.mu must be held
+func (p *AMQPPublisher) resetChannel() {
+	if p.ch != nil {
+		p.ch.Close()
+		p.ch = nil
+	}
+}
+
+func (p *AMQPPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.resetChannel()
+
+	if p.conn == nil {
+		return nil
+	}
+
+	err := p.conn.Close()
+	p.conn = nil
+
+	return err
+}
@@ -0,0 +1,63 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/utils"
+)
+
+// Types of the domain events, also their routing key
+const (
+	TypeUserRegistered = "user.registered"
+	TypeUserUpdated    = "user.updated"
+	TypeUserDeleted    = "user.deleted"
+)
+
+// UserData is the data of the user events
+type UserData struct {
+	UserID   uint64 `json:"user_id"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	IsActive *bool  `json:"is_active,omitempty"`
+	// Fields are the fields changed by user.updated, only they are set
+	Fields []string `json:"fields,omitempty"`
+	By     string   `json:"by,omitempty"`
+}
+
+// Message is a published domain event, consumers deduplicate on ID since the delivery is at least once
+type Message struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	UserID     uint64          `json:"user_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// NewUserEvent returns the outbox event of a change on a user
+func NewUserEvent(eventType string, data UserData) (*db.OutboxEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.OutboxEvent{
+		EventID:   utils.GenerateTokenID(),
+		Type:      eventType,
+		UserID:    data.UserID,
+		Payload:   payload,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// NewMessage returns the message publishing an outbox event
+func NewMessage(event db.OutboxEvent) Message {
+	return Message{
+		ID:         event.EventID,
+		Type:       event.Type,
+		UserID:     event.UserID,
+		OccurredAt: event.CreatedAt.UTC(),
+		Data:       json.RawMessage(event.Payload),
+	}
+}
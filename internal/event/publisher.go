@@ -0,0 +1,99 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+// Publishers
+const (
+	PublisherAMQP = "amqp"
+	PublisherLog  = "log"
+)
+
+// Publisher delivers the domain events to the other services
+// Publish returns nil only once the event is safely stored by the broker
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// New returns the publisher selected by EVENTS.PUBLISHER, the log publisher by default
+func New(conf infra.EventConfig, amqpConf infra.AMQPConfig, logger *logrus.Logger) (Publisher, error) {
+	switch conf.Publisher {
+	case "", PublisherLog:
+		return NewLogPublisher(logger), nil
+	case PublisherAMQP:
+		return NewAMQPPublisher(amqpConf, logger)
+	}
+
+	return nil, fmt.Errorf("unknown event publisher %s", conf.Publisher)
+}
+
+// LogPublisher writes the events to the service log, for local development only
+type LogPublisher struct {
+	log *logrus.Logger
+}
+
+// NewLogPublisher creates a publisher writing to the service log
+func NewLogPublisher(logger *logrus.Logger) *LogPublisher {
+	return &LogPublisher{log: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, msg Message) error {
+	p.log.WithField("event_id", msg.ID).WithField("user_id", msg.UserID).Infof("Event | %s %s", msg.Type, msg.Data)
+	return nil
+}
+
+func (p *LogPublisher) Close() error {
+	return nil
+}
+
+// MemoryPublisher keeps the published events in memory, for tests
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+// NewMemoryPublisher creates an empty in-memory publisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	p.messages = append(p.messages, msg)
+
+	return nil
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
+
+// Messages returns the published events in their publication order
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Message(nil), p.messages...)
+}
+
+// SetError makes Publish fail with err until it is set back to nil, simulating a broker outage
+func (p *MemoryPublisher) SetError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+// Check returns an error when a dependency is unreachable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker pings the dependencies every interval and sets the serving status of the services relying on them
+// The status of the server itself, the empty service name, is left to the server lifecycle
+type Checker struct {
+	server   *health.Server
+	services []string
+	checks   []namedCheck
+	failing  map[string]bool
+	interval time.Duration
+	timeout  time.Duration
+	log      *logrus.Logger
+}
+
+// NewChecker creates a checker setting the status of services on server, they are NOT_SERVING until the first check
+func NewChecker(server *health.Server, services []string, conf infra.HealthConfig, logger *logrus.Logger) *Checker {
+	interval := time.Duration(conf.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	for _, service := range services {
+		server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return &Checker{
+		server:   server,
+		services: services,
+		failing:  make(map[string]bool),
+		interval: interval,
+		timeout:  timeout,
+		log:      logger,
+	}
+}
+
+// Add registers a dependency, it must be called before Run
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run checks the dependencies every interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check pings every dependency, the services are SERVING only when all of them answered
+func (c *Checker) check(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_SERVING
+
+	for _, dependency := range c.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := dependency.check(checkCtx)
+		cancel()
+
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+
+			// only the changes are logged, a dependency down would flood the log otherwise
+			if !c.failing[dependency.name] {
+				c.log.WithField("dependency", dependency.name).WithError(err).Errorf("Health | Dependency is unreachable")
+			}
+			c.failing[dependency.name] = true
+
+			continue
+		}
+
+		if c.failing[dependency.name] {
+			c.log.WithField("dependency", dependency.name).Infof("Health | Dependency is reachable again")
+			delete(c.failing, dependency.name)
+		}
+	}
+
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
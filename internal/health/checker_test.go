@@ -0,0 +1,156 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const testService = "users.Users"
+
+// dependency is a check failing while down is set
+type dependency struct {
+	down atomic.Bool
+}
+
+func (d *dependency) check(ctx context.Context) error {
+	if d.down.Load() {
+		return errors.New("connection refused")
+	}
+
+	return nil
+}
+
+// servingStatus returns the status of service on server
+func servingStatus(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	res, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q): %v", service, err)
+	}
+
+	return res.GetStatus()
+}
+
+func TestCheckerStatus(t *testing.T) {
+	server := health.NewServer()
+	logger, hook := test.NewNullLogger()
+
+	checker := NewChecker(server, []string{testService}, infra.HealthConfig{}, logger)
+	database, redis := &dependency{}, &dependency{}
+	checker.Add("database", database.check)
+	checker.Add("redis", redis.check)
+
+	if got := servingStatus(t, server, testService); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status before the first check = %s, want NOT_SERVING", got)
+	}
+
+	steps := []struct {
+		name          string
+		databaseDown  bool
+		redisDown     bool
+		want          healthpb.HealthCheckResponse_ServingStatus
+		wantErrorLogs int
+	}{
+		{name: "all reachable", want: healthpb.HealthCheckResponse_SERVING},
+		{name: "database down", databaseDown: true, want: healthpb.HealthCheckResponse_NOT_SERVING, wantErrorLogs: 1},
+		{name: "database still down", databaseDown: true, want: healthpb.HealthCheckResponse_NOT_SERVING, wantErrorLogs: 1},
+		{name: "redis down too", databaseDown: true, redisDown: true, want: healthpb.HealthCheckResponse_NOT_SERVING, wantErrorLogs: 2},
+		{name: "redis still down", redisDown: true, want: healthpb.HealthCheckResponse_NOT_SERVING, wantErrorLogs: 2},
+		{name: "all back", want: healthpb.HealthCheckResponse_SERVING, wantErrorLogs: 2},
+	}
+
+	for _, step := range steps {
+		database.down.Store(step.databaseDown)
+		redis.down.Store(step.redisDown)
+		checker.check(context.Background())
+
+		if got := servingStatus(t, server, testService); got != step.want {
+			t.Errorf("%s: status = %s, want %s", step.name, got, step.want)
+		}
+
+		// a dependency staying down is logged once
+		errorLogs := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.ErrorLevel {
+				errorLogs++
+			}
+		}
+		if errorLogs != step.wantErrorLogs {
+			t.Errorf("%s: %d errors logged, want %d", step.name, errorLogs, step.wantErrorLogs)
+		}
+	}
+
+	// the status of the server itself belongs to its lifecycle
+	if got := servingStatus(t, server, ""); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("server status = %s, want SERVING", got)
+	}
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	server := health.NewServer()
+	logger, _ := test.NewNullLogger()
+
+	checker := NewChecker(server, []string{testService}, infra.HealthConfig{}, logger)
+	checker.timeout = 20 * time.Millisecond
+	checker.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	checker.check(context.Background())
+
+	if got := servingStatus(t, server, testService); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status with a hanging dependency = %s, want NOT_SERVING", got)
+	}
+}
+
+func TestCheckerRun(t *testing.T) {
+	server := health.NewServer()
+	logger, _ := test.NewNullLogger()
+
+	checker := NewChecker(server, []string{testService}, infra.HealthConfig{}, logger)
+	checker.interval = 10 * time.Millisecond
+	database := &dependency{}
+	checker.Add("database", database.check)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for servingStatus(t, server, testService) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("status = %s, want %s", servingStatus(t, server, testService), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitStatus(healthpb.HealthCheckResponse_SERVING)
+
+	database.down.Store(true)
+	waitStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	database.down.Store(false)
+	waitStatus(healthpb.HealthCheckResponse_SERVING)
+}
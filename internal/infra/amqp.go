@@ -1 +1,50 @@
 package infra
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+const amqpDialTimeout = 5 * time.Second
+
+// NewAMQPConnection connects to the AMQP broker and declares the durable topic exchange of the domain events
+func NewAMQPConnection(conf AMQPConfig, logger *logrus.Logger) (*amqp.Connection, error) {
+	if conf.URL == "" {
+		return nil, fmt.Errorf("AMQP.URL cannot be empty")
+	}
+
+	if conf.Exchange == "" {
+		return nil, fmt.Errorf("AMQP.EXCHANGE cannot be empty")
+	}
+
+	conn, err := amqp.DialConfig(conf.URL, amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Dial:      amqp.DefaultDial(amqpDialTimeout),
+	})
+	if err != nil {
+		logger.Error(ConnectAMQPFail + " | " + err.Error())
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		logger.Error(ConnectAMQPFail + " | " + err.Error())
+		return nil, err
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclare(conf.Exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		logger.Error(ConnectAMQPFail + " | " + err.Error())
+		return nil, err
+	}
+
+	logger.Info(ConnectAMQPSuccess)
+
+	return conn, nil
+}
@@ -0,0 +1,428 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultConfigFile is the YAML file read when neither the caller nor CONFIG_FILE names one
+const DefaultConfigFile = "config/app.yaml"
+
+// Config is the configuration read in layers, each one overriding the previous:
+// the defaults, the YAML file, the environment variables of SectionService, then their _FILE secret files
+type Config struct {
+	v       *viper.Viper
+	file    string
+	secrets map[string]bool
+}
+
+// ReadConfig reads the layered configuration, file may be empty for CONFIG_FILE or DefaultConfigFile
+// A missing default file is not an error, the environment can hold the whole configuration
+func ReadConfig(file string) (*Config, error) {
+	explicit := file != ""
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+		explicit = file != ""
+	}
+	if file == "" {
+		file = DefaultConfigFile
+	}
+
+	v := viper.New()
+	setConfigDefaults(v)
+
+	v.SetConfigFile(file)
+	v.SetConfigType("yaml")
+	err := v.ReadInConfig()
+	if err != nil && (explicit || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	conf := &Config{
+		v:       v,
+		file:    file,
+		secrets: make(map[string]bool),
+	}
+
+	err = conf.bindEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// LoadConfig reads the layered configuration into AppService, without validating it
+func LoadConfig(file string) (*AppService, error) {
+	conf, err := ReadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf.AppService()
+}
+
+// File returns the YAML file of the configuration
+func (c *Config) File() string {
+	return c.file
+}
+
+// bindEnv binds every key of SectionService to its environment variable, a _FILE variable overrides it with the file content
+func (c *Config) bindEnv() error {
+	fields := reflect.TypeOf(SectionService{})
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+
+		key := field.Tag.Get("config")
+		env := field.Tag.Get("json")
+		if key == "" || env == "" {
+			continue
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			c.secrets[strings.ToLower(key)] = true
+		}
+
+		err := c.v.BindEnv(key, env)
+		if err != nil {
+			return err
+		}
+
+		path := os.Getenv(env + "_FILE")
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE: %w", env, err)
+		}
+
+		c.v.Set(key, strings.TrimRight(string(data), "\r\n"))
+	}
+
+	return nil
+}
+
+// setConfigDefaults sets the keys needed to start without the YAML file, the other defaults belong to the code using them
+func setConfigDefaults(v *viper.Viper) {
+	v.SetDefault("APP.NAME", "backend-user-service")
+	v.SetDefault("APP.ENV", EnvStaging)
+	v.SetDefault("APP.PORT", "8080")
+	v.SetDefault("APP.PORT_CLIENT", "50051")
+
+	v.SetDefault("DATABASE.DRIVER", DriverPostgres)
+	for _, db := range []string{"READ", "WRITE"} {
+		v.SetDefault("DATABASE."+db+".PORT", "5432")
+		v.SetDefault("DATABASE."+db+".TIMEOUT", "10")
+		v.SetDefault("DATABASE."+db+".SSL_MODE", "require")
+	}
+
+	v.SetDefault("REDIS.PORT", 6379)
+
+	v.SetDefault("AUTHORIZATION.JWT.IS_ACTIVE", true)
+	v.SetDefault("AUTHORIZATION.JWT.ACCESS_TOKEN_DURATION", 120)
+	v.SetDefault("AUTHORIZATION.JWT.REFRESH_TOKEN_DURATION", 365)
+	v.SetDefault("AUTHORIZATION.JWT.SIGNING_METHOD", SigningMethodHS256)
+
+	v.SetDefault("METRICS.PORT", "9090")
+	v.SetDefault("METRICS.PATH", "/metrics")
+	v.SetDefault("TRACING.EXPORTER", TracingExporterNone)
+	v.SetDefault("TRACING.SAMPLE_RATIO", 1)
+	v.SetDefault("SHUTDOWN.TIMEOUT", 20)
+}
+
+// AppService returns the configuration of the services
+func (c *Config) AppService() (*AppService, error) {
+	v := c.v
+
+	var jwtKeys []JWTKey
+	err := v.UnmarshalKey("AUTHORIZATION.JWT.KEYS", &jwtKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var rateLimitDefault RateLimitRule
+	err = v.UnmarshalKey("RATE_LIMIT.DEFAULT", &rateLimitDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	var rateLimitMethods map[string]RateLimitRule
+	err = v.UnmarshalKey("RATE_LIMIT.METHODS", &rateLimitMethods)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := AppService{
+		App: AppUser{
+			Name:         v.GetString("APP.NAME"),
+			Environtment: v.GetString("APP.ENV"),
+			URL:          v.GetString("APP.URL"),
+			Port:         v.GetString("APP.PORT"),
+			PortClient:   v.GetString("APP.PORT_CLIENT"),
+			SecretKey:    v.GetString("APP.KEY"),
+
+			TrustedProxies: getStringSlice(v, "APP.TRUSTED_PROXIES"),
+		},
+		Route: RouteUser{
+			Methods: getStringSlice(v, "ROUTE.METHODS"),
+			Headers: getStringSlice(v, "ROUTE.HEADERS"),
+			Origins: getStringSlice(v, "ROUTE.ORIGIN"),
+		},
+		DatabaseUser: DatabaseUser{
+			Driver: v.GetString("DATABASE.DRIVER"),
+			Read: DBDetailUser{
+				Username:     v.GetString("DATABASE.READ.USERNAME"),
+				Password:     v.GetString("DATABASE.READ.PASSWORD"),
+				URL:          v.GetString("DATABASE.READ.URL"),
+				Port:         v.GetString("DATABASE.READ.PORT"),
+				DBName:       v.GetString("DATABASE.READ.DB_NAME"),
+				MaxIdleConns: v.GetInt("DATABASE.READ.MAXIDLECONNS"),
+				MaxOpenConns: v.GetInt("DATABASE.READ.MAXOPENCONNS"),
+				MaxLifeTime:  v.GetInt("DATABASE.READ.MAXLIFETIME"),
+				Timeout:      v.GetString("DATABASE.READ.TIMEOUT"),
+				SSLMode:      v.GetString("DATABASE.READ.SSL_MODE"),
+			},
+			Write: DBDetailUser{
+				Username:     v.GetString("DATABASE.WRITE.USERNAME"),
+				Password:     v.GetString("DATABASE.WRITE.PASSWORD"),
+				URL:          v.GetString("DATABASE.WRITE.URL"),
+				Port:         v.GetString("DATABASE.WRITE.PORT"),
+				DBName:       v.GetString("DATABASE.WRITE.DB_NAME"),
+				MaxIdleConns: v.GetInt("DATABASE.WRITE.MAXIDLECONNS"),
+				MaxOpenConns: v.GetInt("DATABASE.WRITE.MAXOPENCONNS"),
+				MaxLifeTime:  v.GetInt("DATABASE.WRITE.MAXLIFETIME"),
+				Timeout:      v.GetString("DATABASE.WRITE.TIMEOUT"),
+				SSLMode:      v.GetString("DATABASE.WRITE.SSL_MODE"),
+			},
+			Migration: MigrationUser{
+				RequireLatest: v.GetBool("DATABASE.MIGRATION.REQUIRE_LATEST"),
+			},
+		},
+		Redis: RedisUser{
+			Username:     v.GetString("REDIS.USERNAME"),
+			Password:     v.GetString("REDIS.PASSWORD"),
+			URL:          v.GetString("REDIS.URL"),
+			Port:         v.GetInt("REDIS.PORT"),
+			MinIdleConns: v.GetInt("REDIS.MINIDLECONNS"),
+			Timeout:      v.GetString("REDIS.TIMEOUT"),
+			TLS:          v.GetBool("REDIS.TLS"),
+		},
+		Authorization: AuthUser{
+			JWT: JWTCredential{
+				IsActive:              v.GetBool("AUTHORIZATION.JWT.IS_ACTIVE"),
+				AccessTokenSecretKey:  v.GetString("AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY"),
+				AccessTokenDuration:   v.GetInt("AUTHORIZATION.JWT.ACCESS_TOKEN_DURATION"),
+				RefreshTokenSecretKey: v.GetString("AUTHORIZATION.JWT.REFRESH_TOKEN_SECRET_KEY"),
+				RefreshTokenDuration:  v.GetInt("AUTHORIZATION.JWT.REFRESH_TOKEN_DURATION"),
+				SigningMethod:         v.GetString("AUTHORIZATION.JWT.SIGNING_METHOD"),
+				SigningKeyID:          v.GetString("AUTHORIZATION.JWT.SIGNING_KEY_ID"),
+				MFATokenDuration:      v.GetInt("AUTHORIZATION.JWT.MFA_TOKEN_DURATION"),
+				Keys:                  jwtKeys,
+			},
+			Public: PublicCredential{
+				SecretKey: v.GetString("AUTHORIZATION.PUBLIC.SECRET_KEY"),
+			},
+			Admin: AdminCredential{
+				SecretKey: v.GetString("AUTHORIZATION.ADMIN.SECRET_KEY"),
+			},
+		},
+		KeyData: KeyUser{
+			User: v.GetString("KEY.USER"),
+		},
+		Minio: MinioSecret{
+			BucketName: v.GetString("MINIO.BUCKET_NAME"),
+			Endpoint:   v.GetString("MINIO.ENDPOINT"),
+			Key:        v.GetString("MINIO.KEY"),
+			Secret:     v.GetString("MINIO.SECRET"),
+			Region:     v.GetString("MINIO.REGION"),
+			TempFolder: v.GetString("MINIO.TEMP_FOLDER"),
+			BaseURL:    v.GetString("MINIO.BASE_URL"),
+		},
+		User: UserConfig{
+			Retention: RetentionUser{
+				PurgeAfterDays: v.GetInt("USER.RETENTION.PURGE_AFTER_DAYS"),
+				Interval:       v.GetInt("USER.RETENTION.INTERVAL"),
+			},
+			Batch: BatchUser{
+				MaxSize: v.GetInt("USER.BATCH.MAX_SIZE"),
+			},
+			PasswordReset: PasswordResetUser{
+				TTL: v.GetInt("USER.PASSWORD_RESET.TTL"),
+				URL: v.GetString("USER.PASSWORD_RESET.URL"),
+			},
+			Verification: VerificationUser{
+				TTL:         v.GetInt("USER.EMAIL_VERIFICATION.TTL"),
+				URL:         v.GetString("USER.EMAIL_VERIFICATION.URL"),
+				LoginPolicy: v.GetString("USER.EMAIL_VERIFICATION.LOGIN_POLICY"),
+				GracePeriod: v.GetInt("USER.EMAIL_VERIFICATION.GRACE_PERIOD"),
+			},
+			MFA: MFAUser{
+				Issuer: v.GetString("USER.MFA.ISSUER"),
+			},
+			Lockout: LockoutUser{
+				Threshold:   v.GetInt("USER.LOCKOUT.THRESHOLD"),
+				IPThreshold: v.GetInt("USER.LOCKOUT.IP_THRESHOLD"),
+				BaseDelay:   v.GetInt("USER.LOCKOUT.BASE_DELAY"),
+				MaxDelay:    v.GetInt("USER.LOCKOUT.MAX_DELAY"),
+				Window:      v.GetInt("USER.LOCKOUT.WINDOW"),
+			},
+		},
+		Notify: NotifyConfig{
+			Driver:   v.GetString("NOTIFY.DRIVER"),
+			FilePath: v.GetString("NOTIFY.FILE_PATH"),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: v.GetBool("RATE_LIMIT.ENABLED"),
+			Default: rateLimitDefault,
+			Methods: rateLimitMethods,
+		},
+		Cache: CacheConfig{
+			Driver:  v.GetString("CACHE.DRIVER"),
+			UserTTL: v.GetInt("CACHE.USER_TTL"),
+		},
+		AMQP: AMQPConfig{
+			URL:      v.GetString("AMQP.URL"),
+			Exchange: v.GetString("AMQP.EXCHANGE"),
+		},
+		Metrics: MetricsConfig{
+			Enabled: v.GetBool("METRICS.ENABLED"),
+			Port:    v.GetString("METRICS.PORT"),
+			Path:    v.GetString("METRICS.PATH"),
+		},
+		Shutdown: ShutdownConfig{
+			Timeout:    v.GetInt("SHUTDOWN.TIMEOUT"),
+			DrainDelay: v.GetInt("SHUTDOWN.DRAIN_DELAY"),
+		},
+		Health: HealthConfig{
+			Interval: v.GetInt("HEALTH.INTERVAL"),
+			Timeout:  v.GetInt("HEALTH.TIMEOUT"),
+		},
+		Log: LogConfig{
+			Level: v.GetString("LOG.LEVEL"),
+		},
+		Tracing: TracingConfig{
+			Exporter:    v.GetString("TRACING.EXPORTER"),
+			Endpoint:    v.GetString("TRACING.ENDPOINT"),
+			Insecure:    v.GetBool("TRACING.INSECURE"),
+			SampleRatio: v.GetFloat64("TRACING.SAMPLE_RATIO"),
+		},
+		Events: EventConfig{
+			Publisher:     v.GetString("EVENTS.PUBLISHER"),
+			RelayInterval: v.GetInt("EVENTS.RELAY_INTERVAL"),
+			BatchSize:     v.GetInt("EVENTS.BATCH_SIZE"),
+			RetentionDays: v.GetInt("EVENTS.RETENTION_DAYS"),
+			MaxAttempts:   v.GetInt("EVENTS.MAX_ATTEMPTS"),
+		},
+	}
+
+	return &conf, nil
+}
+
+// getStringSlice reads a YAML list, or a comma separated list set by an environment variable
+func getStringSlice(v *viper.Viper, key string) []string {
+	value, ok := v.Get(key).(string)
+	if !ok {
+		return v.GetStringSlice(key)
+	}
+
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Settings returns every key with its value by section, redacted masks the secrets of SectionService
+// and the password of the URLs such as AMQP.URL
+func (c *Config) Settings(redacted bool) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for key, value := range c.values() {
+		if redacted {
+			value = c.redactSetting(key, value)
+		}
+
+		// nest the value under its sections, the keys are upper case like in the YAML file
+		path := strings.Split(strings.ToUpper(key), ".")
+		section := settings
+		for _, name := range path[:len(path)-1] {
+			next, ok := section[name].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				section[name] = next
+			}
+			section = next
+		}
+		section[path[len(path)-1]] = value
+	}
+
+	return settings
+}
+
+// ChangedKeys returns the keys whose value differs from previous, sorted and upper case like in the YAML file
+func (c *Config) ChangedKeys(previous *Config) []string {
+	current, old := c.values(), previous.values()
+
+	var changed []string
+	for key, value := range current {
+		if !reflect.DeepEqual(value, old[key]) {
+			changed = append(changed, strings.ToUpper(key))
+		}
+	}
+	for key := range old {
+		if _, ok := current[key]; !ok {
+			changed = append(changed, strings.ToUpper(key))
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+// values returns the value of every key, the keys are lower case as viper stores them
+func (c *Config) values() map[string]interface{} {
+	keys := c.v.AllKeys()
+
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		values[key] = c.v.Get(key)
+	}
+
+	return values
+}
+
+// redactSetting masks a secret value, an empty secret stays empty so a missing one is still visible
+func (c *Config) redactSetting(key string, value interface{}) interface{} {
+	if c.secrets[key] {
+		if value == nil || value == "" {
+			return value
+		}
+
+		return Redacted
+	}
+
+	text, ok := value.(string)
+	if !ok || !strings.Contains(text, "://") {
+		return value
+	}
+
+	parsed, err := url.Parse(text)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+
+	return parsed.Redacted()
+}
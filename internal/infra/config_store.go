@@ -0,0 +1,217 @@
+package infra
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDebounce groups the events of one save, editors write a file in several steps
+const reloadDebounce = 500 * time.Millisecond
+
+// reloadableKeys are the keys applied without restart, by name or by section prefix
+// The other keys are read once on startup, a change of them is logged and applied on the next restart
+var reloadableKeys = []string{
+	"LOG.LEVEL",
+	"AUTHORIZATION.JWT.ACCESS_TOKEN_DURATION",
+	"AUTHORIZATION.JWT.REFRESH_TOKEN_DURATION",
+	"AUTHORIZATION.JWT.MFA_TOKEN_DURATION",
+	"RATE_LIMIT.",
+	"USER.BATCH.",
+	"USER.PASSWORD_RESET.",
+	"USER.EMAIL_VERIFICATION.",
+	"USER.MFA.",
+	"USER.LOCKOUT.",
+}
+
+// ConfigStore holds the current snapshot of AppService, swapped atomically when the configuration is reloaded
+// A snapshot is never modified, readers keep a consistent configuration for as long as they hold it
+type ConfigStore struct {
+	current  atomic.Pointer[AppService]
+	config   *Config
+	log      *logrus.Logger
+	mu       sync.Mutex
+	onReload []func(conf *AppService)
+}
+
+// NewConfigStore creates the store of conf, read from config
+func NewConfigStore(config *Config, conf *AppService, logger *logrus.Logger) *ConfigStore {
+	store := &ConfigStore{
+		config: config,
+		log:    logger,
+	}
+	store.current.Store(conf)
+
+	return store
+}
+
+// Load returns the current snapshot
+func (s *ConfigStore) Load() *AppService {
+	return s.current.Load()
+}
+
+// OnReload registers fn, called with the new snapshot after every reload applying a change
+func (s *ConfigStore) OnReload(fn func(conf *AppService)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onReload = append(s.onReload, fn)
+}
+
+// Reload reads the configuration again and swaps the snapshot with its reloadable keys
+// An unreadable or invalid configuration is rejected, the previous snapshot stays in use
+func (s *ConfigStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := ReadConfig(s.config.File())
+	if err != nil {
+		return err
+	}
+
+	next, err := config.AppService()
+	if err != nil {
+		return err
+	}
+
+	err = next.Validate()
+	if err != nil {
+		return err
+	}
+
+	changed := config.ChangedKeys(s.config)
+	s.config = config
+	if len(changed) == 0 {
+		s.log.Info("ConfigStore | Config reloaded without change")
+		return nil
+	}
+
+	var applied, restart []string
+	for _, key := range changed {
+		if isReloadable(key) {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+
+	if len(restart) > 0 {
+		s.log.WithField("keys", restart).Warn("ConfigStore | Changed keys need a restart to apply")
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+
+	conf := withReloadable(s.current.Load(), next)
+	s.current.Store(conf)
+
+	for _, fn := range s.onReload {
+		fn(conf)
+	}
+
+	// warn so the change is logged whatever the new level
+	s.log.WithField("keys", applied).Warn("ConfigStore | Config reloaded")
+
+	return nil
+}
+
+// Run reloads the configuration when its file changes or on SIGHUP, until ctx is done
+func (s *ConfigStore) Run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// the directory is watched, a file replaced by a rename or a symlink swap like a kubernetes ConfigMap is seen too
+	file := filepath.Clean(s.config.File())
+	realFile, _ := filepath.EvalSymlinks(file)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(file))
+	}
+	if err != nil {
+		s.log.WithError(err).Errorf("ConfigStore | Failed to watch %s, reload with SIGHUP only", file)
+	} else {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			s.log.Info("ConfigStore | SIGHUP received, reloading config")
+			s.reload()
+		case event := <-events:
+			currentFile, _ := filepath.EvalSymlinks(file)
+			if filepath.Clean(event.Name) != file && currentFile == realFile {
+				continue
+			}
+
+			realFile = currentFile
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				debounce.Reset(reloadDebounce)
+			}
+		case err := <-errs:
+			s.log.WithError(err).Errorf("ConfigStore | Failed to watch %s", file)
+		case <-debounce.C:
+			s.reload()
+		}
+	}
+}
+
+// reload logs a rejected reload, the service goes on with the previous snapshot
+func (s *ConfigStore) reload() {
+	err := s.Reload()
+	if err != nil {
+		s.log.WithError(err).Errorf("ConfigStore | Config reload rejected, keeping the previous config")
+	}
+}
+
+// isReloadable reports whether key is applied without restart
+func isReloadable(key string) bool {
+	for _, reloadable := range reloadableKeys {
+		if key == reloadable || (strings.HasSuffix(reloadable, ".") && strings.HasPrefix(key, reloadable)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withReloadable returns a copy of current with the reloadable keys of next, keep it in line with reloadableKeys
+func withReloadable(current, next *AppService) *AppService {
+	conf := *current
+
+	conf.Log.Level = next.Log.Level
+
+	conf.Authorization.JWT.AccessTokenDuration = next.Authorization.JWT.AccessTokenDuration
+	conf.Authorization.JWT.RefreshTokenDuration = next.Authorization.JWT.RefreshTokenDuration
+	conf.Authorization.JWT.MFATokenDuration = next.Authorization.JWT.MFATokenDuration
+
+	conf.RateLimit = next.RateLimit
+
+	conf.User.Batch = next.User.Batch
+	conf.User.PasswordReset = next.User.PasswordReset
+	conf.User.Verification = next.User.Verification
+	conf.User.MFA = next.User.MFA
+	conf.User.Lockout = next.User.Lockout
+
+	return &conf
+}
@@ -0,0 +1,194 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// storeYAML completes testConfigYAML with reloadable keys, LOG.LEVEL & RATE_LIMIT, and METRICS.PORT needing a restart
+const storeYAML = testConfigYAML + `
+LOG:
+  LEVEL: %s
+RATE_LIMIT:
+  DEFAULT:
+    LIMIT: %d
+    PERIOD: 1
+    KEY: ip
+METRICS:
+  PORT: %s
+`
+
+// newTestStore writes the configuration of level, limit & metricsPort and returns its store, its file & its log
+func newTestStore(t *testing.T, level string, limit int, metricsPort string) (*ConfigStore, string, *test.Hook) {
+	t.Helper()
+
+	setenv(t, testSecrets)
+	file := writeFile(t, "app.yaml", storeContent(level, limit, metricsPort))
+
+	config, err := ReadConfig(file)
+	if err != nil {
+		t.Fatalf("ReadConfig(): %v", err)
+	}
+	conf, err := config.AppService()
+	if err != nil {
+		t.Fatalf("AppService(): %v", err)
+	}
+
+	logger, hook := test.NewNullLogger()
+
+	return NewConfigStore(config, conf, logger), file, hook
+}
+
+// storeContent returns the configuration file of level, limit & metricsPort
+func storeContent(level string, limit int, metricsPort string) string {
+	return fmt.Sprintf(storeYAML, level, limit, metricsPort)
+}
+
+// rewrite replaces the configuration file
+func rewrite(t *testing.T, file, content string) {
+	t.Helper()
+
+	err := os.WriteFile(file, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("rewrite %s: %v", file, err)
+	}
+}
+
+// warned returns the keys of the last warning logged with message, nil when none was
+func warned(hook *test.Hook, message string) []string {
+	var keys []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && entry.Message == message {
+			keys, _ = entry.Data["keys"].([]string)
+		}
+	}
+
+	return keys
+}
+
+func TestConfigStoreReload(t *testing.T) {
+	store, file, hook := newTestStore(t, "info", 10, "9090")
+	previous := store.Load()
+
+	var reloaded []*AppService
+	store.OnReload(func(conf *AppService) { reloaded = append(reloaded, conf) })
+
+	rewrite(t, file, storeContent("debug", 20, "9091"))
+	err := store.Reload()
+	if err != nil {
+		t.Fatalf("Reload(): %v", err)
+	}
+
+	conf := store.Load()
+	if conf.Log.Level != "debug" || conf.RateLimit.Default.Limit != 20 {
+		t.Errorf("LOG.LEVEL, RATE_LIMIT.DEFAULT.LIMIT = %s, %d, want the reloaded debug, 20", conf.Log.Level, conf.RateLimit.Default.Limit)
+	}
+	if conf.Metrics.Port != "9090" {
+		t.Errorf("METRICS.PORT = %s, want 9090 until the restart", conf.Metrics.Port)
+	}
+
+	// the previous snapshot is never modified
+	if previous.Log.Level != "info" || previous.RateLimit.Default.Limit != 10 {
+		t.Errorf("previous snapshot modified: %s, %d", previous.Log.Level, previous.RateLimit.Default.Limit)
+	}
+
+	if len(reloaded) != 1 || reloaded[0] != conf {
+		t.Errorf("OnReload() called with %v, want the new snapshot once", reloaded)
+	}
+
+	if keys := warned(hook, "ConfigStore | Changed keys need a restart to apply"); len(keys) != 1 || keys[0] != "METRICS.PORT" {
+		t.Errorf("restart warning keys = %v, want METRICS.PORT", keys)
+	}
+}
+
+func TestConfigStoreReloadRestartOnly(t *testing.T) {
+	store, file, hook := newTestStore(t, "info", 10, "9090")
+	previous := store.Load()
+
+	called := false
+	store.OnReload(func(conf *AppService) { called = true })
+
+	rewrite(t, file, storeContent("info", 10, "9091"))
+	err := store.Reload()
+	if err != nil {
+		t.Fatalf("Reload(): %v", err)
+	}
+
+	if store.Load() != previous || called {
+		t.Errorf("snapshot swapped for a key needing a restart")
+	}
+	if keys := warned(hook, "ConfigStore | Changed keys need a restart to apply"); len(keys) != 1 {
+		t.Errorf("restart warning keys = %v, want METRICS.PORT", keys)
+	}
+}
+
+func TestConfigStoreReloadRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid", content: storeContent("loud", 20, "9090")},
+		{name: "negative limit", content: storeContent("debug", -1, "9090")},
+		{name: "unparsable", content: "LOG: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, file, _ := newTestStore(t, "info", 10, "9090")
+			previous := store.Load()
+
+			called := false
+			store.OnReload(func(conf *AppService) { called = true })
+
+			rewrite(t, file, tt.content)
+			if err := store.Reload(); err == nil {
+				t.Errorf("Reload() accepted the configuration")
+			}
+
+			if store.Load() != previous || called {
+				t.Errorf("snapshot swapped by a rejected reload")
+			}
+
+			// a later valid change is still applied against the configuration in use
+			rewrite(t, file, storeContent("debug", 10, "9090"))
+			if err := store.Reload(); err != nil {
+				t.Fatalf("Reload(): %v", err)
+			}
+			if store.Load().Log.Level != "debug" {
+				t.Errorf("LOG.LEVEL = %s after a valid reload, want debug", store.Load().Log.Level)
+			}
+		})
+	}
+}
+
+func TestConfigStoreRunReloadsOnWrite(t *testing.T) {
+	store, file, _ := newTestStore(t, "info", 10, "9090")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the watcher starts asynchronously, the file is written until the change is seen
+	deadline := time.Now().Add(10 * time.Second)
+	for store.Load().Log.Level != "warn" {
+		if time.Now().After(deadline) {
+			t.Fatalf("LOG.LEVEL = %s, want warn after the file changed", store.Load().Log.Level)
+		}
+
+		rewrite(t, file, storeContent("warn", 10, "9090"))
+		time.Sleep(reloadDebounce + 200*time.Millisecond)
+	}
+}
@@ -0,0 +1,304 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfigYAML is a configuration starting on the memory database, its secrets are set by the tests
+const testConfigYAML = `
+APP:
+  NAME: backend-user-service
+  PORT: 8080
+DATABASE:
+  DRIVER: memory
+EVENTS:
+  PUBLISHER: log
+AUTHORIZATION:
+  JWT:
+    IS_ACTIVE: true
+    ACCESS_TOKEN_DURATION: 120
+    REFRESH_TOKEN_DURATION: 365
+`
+
+// testSecrets are the environment variables completing testConfigYAML
+var testSecrets = map[string]string{
+	"KEY_USER": "0123456789abcdef0123456789abcdef",
+	"AUTHORIZATION_JWT_ACCESS_TOKEN_SECRET_KEY":  "access-token-secret-key",
+	"AUTHORIZATION_JWT_REFRESH_TOKEN_SECRET_KEY": "refresh-token-secret-key",
+}
+
+// writeFile writes content in the test directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+
+	return path
+}
+
+// setenv sets the environment variables for the test
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+// loadTestConfig loads testConfigYAML with testSecrets, failing the test on error
+func loadTestConfig(t *testing.T) *AppService {
+	t.Helper()
+
+	setenv(t, testSecrets)
+	conf, err := LoadConfig(writeFile(t, "app.yaml", testConfigYAML))
+	if err != nil {
+		t.Fatalf("LoadConfig(): %v", err)
+	}
+
+	return conf
+}
+
+func TestReadConfigLayering(t *testing.T) {
+	yaml := testConfigYAML + `
+REDIS:
+  USERNAME: from-yaml
+  PASSWORD: from-yaml
+KEY:
+  USER: from-yaml
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		// files are the _FILE variables, named by variable with their content
+		files    map[string]string
+		username string
+		password string
+		keyUser  string
+	}{
+		{
+			name:     "yaml",
+			username: "from-yaml",
+			password: "from-yaml",
+			keyUser:  "from-yaml",
+		},
+		{
+			name:     "env overrides yaml",
+			env:      map[string]string{"REDIS_PASSWORD": "from-env", "KEY_USER": "from-env"},
+			username: "from-yaml",
+			password: "from-env",
+			keyUser:  "from-env",
+		},
+		{
+			name:     "file overrides env",
+			env:      map[string]string{"REDIS_PASSWORD": "from-env", "KEY_USER": "from-env"},
+			files:    map[string]string{"REDIS_PASSWORD_FILE": "from-file\n", "KEY_USER_FILE": "from-file\r\n"},
+			username: "from-yaml",
+			password: "from-file",
+			keyUser:  "from-file",
+		},
+		{
+			name:     "file without env",
+			files:    map[string]string{"REDIS_USERNAME_FILE": "from-file"},
+			username: "from-file",
+			password: "from-yaml",
+			keyUser:  "from-yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+			for env, content := range tt.files {
+				t.Setenv(env, writeFile(t, env, content))
+			}
+
+			conf, err := LoadConfig(writeFile(t, "app.yaml", yaml))
+			if err != nil {
+				t.Fatalf("LoadConfig(): %v", err)
+			}
+
+			if conf.Redis.Username != tt.username || conf.Redis.Password != tt.password || conf.KeyData.User != tt.keyUser {
+				t.Errorf("REDIS.USERNAME, REDIS.PASSWORD, KEY.USER = %q, %q, %q, want %q, %q, %q",
+					conf.Redis.Username, conf.Redis.Password, conf.KeyData.User, tt.username, tt.password, tt.keyUser)
+			}
+		})
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	t.Run("missing _FILE", func(t *testing.T) {
+		t.Setenv("KEY_USER_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, err := ReadConfig(writeFile(t, "app.yaml", testConfigYAML))
+		if err == nil || !strings.Contains(err.Error(), "KEY_USER_FILE") {
+			t.Errorf("ReadConfig() error = %v, want the KEY_USER_FILE failure", err)
+		}
+	})
+
+	t.Run("missing explicit file", func(t *testing.T) {
+		_, err := ReadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		if err == nil {
+			t.Errorf("ReadConfig() of a missing file, want an error")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *AppService)
+		// wantErr are the parts of the error, none when the configuration is valid
+		wantErr []string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *AppService) {},
+		},
+		{
+			name:    "missing KEY.USER",
+			modify:  func(c *AppService) { c.KeyData.User = "" },
+			wantErr: []string{"KEY.USER is required, set KEY_USER or KEY_USER_FILE"},
+		},
+		{
+			name:   "AES-128 KEY.USER",
+			modify: func(c *AppService) { c.KeyData.User = strings.Repeat("k", 16) },
+		},
+		{
+			name:   "AES-192 KEY.USER",
+			modify: func(c *AppService) { c.KeyData.User = strings.Repeat("k", 24) },
+		},
+		{
+			name:    "short KEY.USER",
+			modify:  func(c *AppService) { c.KeyData.User = strings.Repeat("k", 15) },
+			wantErr: []string{"KEY.USER must be 16, 24 or 32 bytes for AES, got 15"},
+		},
+		{
+			name:    "long KEY.USER",
+			modify:  func(c *AppService) { c.KeyData.User = strings.Repeat("k", 33) },
+			wantErr: []string{"KEY.USER must be 16, 24 or 32 bytes for AES, got 33"},
+		},
+		{
+			name:    "leaked KEY.USER",
+			modify:  func(c *AppService) { c.KeyData.User = "Qj8jR5KyU9SGxLZp3iE6QsW67TRV5lfN" },
+			wantErr: []string{"KEY.USER is a value once published in config/app.yaml, rotate it"},
+		},
+		{
+			name:    "leaked JWT secret",
+			modify:  func(c *AppService) { c.Authorization.JWT.AccessTokenSecretKey = "56LkR$q8Iv1bOYt2!" },
+			wantErr: []string{"AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY is a value once published"},
+		},
+		{
+			name: "leaked database password",
+			modify: func(c *AppService) {
+				c.DatabaseUser.Driver = DriverPostgres
+				db := DBDetailUser{URL: "localhost", Username: "postgres", Password: "junior34", DBName: "backend_user", Port: "5432"}
+				c.DatabaseUser.Read, c.DatabaseUser.Write = db, db
+			},
+			wantErr: []string{"DATABASE.READ.PASSWORD is a value once published", "DATABASE.WRITE.PASSWORD is a value once published"},
+		},
+		{
+			name:    "missing database password",
+			modify:  func(c *AppService) { c.DatabaseUser.Driver = DriverPostgres },
+			wantErr: []string{"DATABASE.READ.PASSWORD is required, set DATABASE_READ_PASSWORD or DATABASE_READ_PASSWORD_FILE", "DATABASE.WRITE.URL is required"},
+		},
+		{
+			name: "missing JWT secrets",
+			modify: func(c *AppService) {
+				c.Authorization.JWT.AccessTokenSecretKey, c.Authorization.JWT.RefreshTokenSecretKey = "", ""
+			},
+			wantErr: []string{"ACCESS_TOKEN_SECRET_KEY is required", "REFRESH_TOKEN_SECRET_KEY is required"},
+		},
+		{
+			name: "JWT secrets unused while inactive",
+			modify: func(c *AppService) {
+				c.Authorization.JWT.IsActive = false
+				c.Authorization.JWT.AccessTokenSecretKey, c.Authorization.JWT.RefreshTokenSecretKey = "", ""
+			},
+		},
+		{
+			name: "same JWT secrets",
+			modify: func(c *AppService) {
+				c.Authorization.JWT.RefreshTokenSecretKey = c.Authorization.JWT.AccessTokenSecretKey
+			},
+			wantErr: []string{"must differ"},
+		},
+		{
+			name:    "amqp publisher without url",
+			modify:  func(c *AppService) { c.Events.Publisher = "amqp" },
+			wantErr: []string{"AMQP.URL is required by the amqp publisher, set AMQP_URL or AMQP_URL_FILE"},
+		},
+		{
+			name:    "RS256 without key id",
+			modify:  func(c *AppService) { c.Authorization.JWT.SigningMethod = SigningMethodRS256 },
+			wantErr: []string{"AUTHORIZATION.JWT.SIGNING_KEY_ID is required by RS256"},
+		},
+		{
+			name:    "negative durations",
+			modify:  func(c *AppService) { c.Events.MaxAttempts, c.Shutdown.Timeout = -1, -1 },
+			wantErr: []string{"EVENTS intervals & sizes cannot be negative", "SHUTDOWN.TIMEOUT cannot be negative"},
+		},
+		{
+			name:    "unknown rate limit key",
+			modify:  func(c *AppService) { c.RateLimit.Default.Key = "session" },
+			wantErr: []string{`RATE_LIMIT.DEFAULT.KEY "session"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := loadTestConfig(t)
+			tt.modify(conf)
+
+			err := conf.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want valid", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateShippedConfig(t *testing.T) {
+	// the secrets left empty in config/app.yaml come from the environment
+	setenv(t, testSecrets)
+	setenv(t, map[string]string{
+		"DATABASE_READ_PASSWORD":  "read-password",
+		"DATABASE_WRITE_PASSWORD": "write-password",
+	})
+
+	conf, err := LoadConfig(filepath.Join("..", "..", DefaultConfigFile))
+	if err != nil {
+		t.Fatalf("LoadConfig(%s): %v", DefaultConfigFile, err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		t.Errorf("Validate() of %s = %v", DefaultConfigFile, err)
+	}
+
+	// without them it does not start
+	t.Setenv("KEY_USER", "")
+	conf, _ = LoadConfig(filepath.Join("..", "..", DefaultConfigFile))
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "KEY.USER is required") {
+		t.Errorf("Validate() without KEY_USER = %v, want KEY.USER required", err)
+	}
+}
@@ -0,0 +1,224 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// AES key sizes accepted for KEY.USER, AES-128, AES-192 & AES-256
+var aesKeySizes = map[int]bool{16: true, 24: true, 32: true}
+
+// leakedSecrets are the sha256 of the values once committed in config/app.yaml, they are public and refused
+var leakedSecrets = map[string]bool{
+	"73349d4f0c54f69a11dc5cb51594d356237c7e3c51a8fd0177878a7cafba1256": true,
+	"0e761300e3c7e5c613086e7164e437b5f4f538abe343c4e7ba79f9ba31562453": true,
+	"b840a5c42b7ab50530123e1a87d1bf09134e0b790d3a33881db58901b35996eb": true,
+	"2b8803d9beafc88116ab3ea839737108adad97dae1fff3b644e2bf6aa79e4bd0": true,
+	"3514104cb94a1929091a1931badc707a35cebc6a170679f14f1bce9131848b12": true,
+	"7c5362ec774d23570164e963d573d382116c714c0fac07e7c826b91d8d8a4ec5": true,
+	"e1fd106d94a31198186894851a72302960c9df1780c1893bf444ac6c8a3ce4fd": true,
+	"f68df36533889cfd42b655b75e3fb1b8fef44f122aaf5598d9087af854b8d328": true,
+	"75d279123bae10dac25a38836c2fc14a3d63337b780f6a0e6092a5080ae2870f": true,
+	"2105697d2fc71e6250521bb417812b3c0158571eba8f870d3cc2293fbb7cb2e2": true,
+}
+
+// Validate checks the configuration before the services start, all the problems are returned at once
+func (c *AppService) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	// app
+	check(c.App.Name != "", "APP.NAME is required")
+	check(validPort(c.App.Port), "APP.PORT %q is not a port", c.App.Port)
+	if c.App.PortClient != "" {
+		check(validPort(c.App.PortClient), "APP.PORT_CLIENT %q is not a port", c.App.PortClient)
+	}
+	if _, err := utils.ParseTrustedProxies(c.App.TrustedProxies); err != nil {
+		check(false, "APP.TRUSTED_PROXIES: %v", err)
+	}
+	if c.Log.Level != "" {
+		_, err := logrus.ParseLevel(c.Log.Level)
+		check(err == nil, "LOG.LEVEL %q is not debug, info, warn or error", c.Log.Level)
+	}
+
+	// database
+	switch c.DatabaseUser.Driver {
+	case "", DriverPostgres:
+		errs = append(errs, validateDatabase("DATABASE.READ", c.DatabaseUser.Read)...)
+		errs = append(errs, validateDatabase("DATABASE.WRITE", c.DatabaseUser.Write)...)
+	case DriverMemory:
+	default:
+		check(false, "DATABASE.DRIVER %q is not %s or %s", c.DatabaseUser.Driver, DriverPostgres, DriverMemory)
+	}
+
+	// redis is optional, its port is checked only once it is configured
+	if c.Redis.URL != "" {
+		check(c.Redis.Port > 0 && c.Redis.Port <= 65535, "REDIS.PORT %d is not a port", c.Redis.Port)
+		if c.Redis.Timeout != "" {
+			_, ok := parseRedisTimeout(c.Redis.Timeout)
+			check(ok, "REDIS.TIMEOUT %q is neither milliseconds nor a duration", c.Redis.Timeout)
+		}
+	}
+
+	// keys & secrets, none of them ships in the YAML, they come from the environment or a _FILE
+	check(c.KeyData.User != "", "KEY.USER is required, set %s", secretEnv("KEY.USER"))
+	check(c.KeyData.User == "" || aesKeySizes[len(c.KeyData.User)], "KEY.USER must be 16, 24 or 32 bytes for AES, got %d", len(c.KeyData.User))
+
+	jwt := c.Authorization.JWT
+	if jwt.IsActive {
+		check(jwt.AccessTokenSecretKey != "", "AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY is required, set %s", secretEnv("AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY"))
+		check(jwt.RefreshTokenSecretKey != "", "AUTHORIZATION.JWT.REFRESH_TOKEN_SECRET_KEY is required, set %s", secretEnv("AUTHORIZATION.JWT.REFRESH_TOKEN_SECRET_KEY"))
+		check(jwt.AccessTokenSecretKey == "" || jwt.AccessTokenSecretKey != jwt.RefreshTokenSecretKey,
+			"AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY & REFRESH_TOKEN_SECRET_KEY must differ")
+		check(jwt.AccessTokenDuration > 0, "AUTHORIZATION.JWT.ACCESS_TOKEN_DURATION must be positive minutes")
+		check(jwt.RefreshTokenDuration > 0, "AUTHORIZATION.JWT.REFRESH_TOKEN_DURATION must be positive days")
+	}
+
+	// "amqp" is event.PublisherAMQP, the event package depends on infra
+	if c.Events.Publisher == "amqp" {
+		check(c.AMQP.URL != "", "AMQP.URL is required by the amqp publisher, set %s", secretEnv("AMQP.URL"))
+	}
+
+	secrets := []struct{ key, value string }{
+		{"APP.KEY", c.App.SecretKey},
+		{"DATABASE.READ.PASSWORD", c.DatabaseUser.Read.Password},
+		{"DATABASE.WRITE.PASSWORD", c.DatabaseUser.Write.Password},
+		{"REDIS.PASSWORD", c.Redis.Password},
+		{"AUTHORIZATION.JWT.ACCESS_TOKEN_SECRET_KEY", jwt.AccessTokenSecretKey},
+		{"AUTHORIZATION.JWT.REFRESH_TOKEN_SECRET_KEY", jwt.RefreshTokenSecretKey},
+		{"AUTHORIZATION.PUBLIC.SECRET_KEY", c.Authorization.Public.SecretKey},
+		{"AUTHORIZATION.ADMIN.SECRET_KEY", c.Authorization.Admin.SecretKey},
+		{"KEY.USER", c.KeyData.User},
+		{"MINIO.KEY", c.Minio.Key},
+		{"MINIO.SECRET", c.Minio.Secret},
+	}
+	for _, secret := range secrets {
+		check(secret.value == "" || !leakedSecrets[utils.Hash256(secret.value)], "%s is a value once published in config/app.yaml, rotate it", secret.key)
+	}
+
+	check(jwt.MFATokenDuration >= 0, "AUTHORIZATION.JWT.MFA_TOKEN_DURATION cannot be negative")
+	switch jwt.SigningMethod {
+	case "", SigningMethodHS256:
+	case SigningMethodRS256, SigningMethodEdDSA:
+		check(jwt.SigningKeyID != "", "AUTHORIZATION.JWT.SIGNING_KEY_ID is required by %s", jwt.SigningMethod)
+	default:
+		check(false, "AUTHORIZATION.JWT.SIGNING_METHOD %q is not %s, %s or %s", jwt.SigningMethod, SigningMethodHS256, SigningMethodRS256, SigningMethodEdDSA)
+	}
+
+	// durations
+	user := c.User
+	check(user.Retention.PurgeAfterDays >= 0, "USER.RETENTION.PURGE_AFTER_DAYS cannot be negative")
+	check(user.Retention.Interval >= 0, "USER.RETENTION.INTERVAL cannot be negative")
+	check(user.Batch.MaxSize >= 0, "USER.BATCH.MAX_SIZE cannot be negative")
+	check(user.PasswordReset.TTL >= 0, "USER.PASSWORD_RESET.TTL cannot be negative")
+	check(user.Verification.TTL >= 0, "USER.EMAIL_VERIFICATION.TTL cannot be negative")
+	check(user.Verification.GracePeriod >= 0, "USER.EMAIL_VERIFICATION.GRACE_PERIOD cannot be negative")
+	switch user.Verification.LoginPolicy {
+	case "", LoginPolicyAllow, LoginPolicyRefuse, LoginPolicyGrace:
+	default:
+		check(false, "USER.EMAIL_VERIFICATION.LOGIN_POLICY %q is not %s, %s or %s", user.Verification.LoginPolicy, LoginPolicyAllow, LoginPolicyRefuse, LoginPolicyGrace)
+	}
+	check(user.Lockout.Threshold >= 0 && user.Lockout.IPThreshold >= 0, "USER.LOCKOUT thresholds cannot be negative")
+	check(user.Lockout.BaseDelay >= 0 && user.Lockout.Window >= 0, "USER.LOCKOUT delays cannot be negative")
+	check(user.Lockout.MaxDelay == 0 || user.Lockout.MaxDelay >= user.Lockout.BaseDelay, "USER.LOCKOUT.MAX_DELAY is shorter than BASE_DELAY")
+
+	check(c.Cache.UserTTL >= 0, "CACHE.USER_TTL cannot be negative")
+	check(c.Events.RelayInterval >= 0 && c.Events.BatchSize >= 0 && c.Events.RetentionDays >= 0 && c.Events.MaxAttempts >= 0, "EVENTS intervals & sizes cannot be negative")
+	check(c.Health.Interval >= 0 && c.Health.Timeout >= 0, "HEALTH.INTERVAL & TIMEOUT cannot be negative")
+	check(c.Shutdown.Timeout >= 0, "SHUTDOWN.TIMEOUT cannot be negative")
+	check(c.Shutdown.DrainDelay >= 0 && (c.Shutdown.Timeout == 0 || c.Shutdown.DrainDelay < c.Shutdown.Timeout),
+		"SHUTDOWN.DRAIN_DELAY must be shorter than SHUTDOWN.TIMEOUT")
+
+	// rate limit
+	errs = append(errs, validateRateLimitRule("RATE_LIMIT.DEFAULT", c.RateLimit.Default)...)
+	for method, rule := range c.RateLimit.Methods {
+		errs = append(errs, validateRateLimitRule("RATE_LIMIT.METHODS."+method, rule)...)
+	}
+
+	// observability
+	if c.Metrics.Enabled {
+		check(validPort(c.Metrics.Port), "METRICS.PORT %q is not a port", c.Metrics.Port)
+	}
+	switch c.Tracing.Exporter {
+	case "", TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		check(c.Tracing.Endpoint != "", "TRACING.ENDPOINT is required by the otlp exporter")
+	default:
+		check(false, "TRACING.EXPORTER %q is not %s, %s or %s", c.Tracing.Exporter, TracingExporterOTLP, TracingExporterStdout, TracingExporterNone)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING.SAMPLE_RATIO must be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+// validateDatabase checks the connection of one postgres database, section is e.g. DATABASE.READ
+func validateDatabase(section string, db DBDetailUser) []error {
+	var errs []error
+	if db.URL == "" {
+		errs = append(errs, fmt.Errorf("%s.URL is required", section))
+	}
+	if db.Username == "" {
+		errs = append(errs, fmt.Errorf("%s.USERNAME is required", section))
+	}
+	if db.Password == "" {
+		errs = append(errs, fmt.Errorf("%s.PASSWORD is required, set %s", section, secretEnv(section+".PASSWORD")))
+	}
+	if db.DBName == "" {
+		errs = append(errs, fmt.Errorf("%s.DB_NAME is required", section))
+	}
+	if !validPort(db.Port) {
+		errs = append(errs, fmt.Errorf("%s.PORT %q is not a port", section, db.Port))
+	}
+	if seconds, err := strconv.Atoi(db.Timeout); db.Timeout != "" && (err != nil || seconds < 0) {
+		errs = append(errs, fmt.Errorf("%s.TIMEOUT %q is not seconds", section, db.Timeout))
+	}
+	if db.MaxIdleConns < 0 || db.MaxOpenConns < 0 || db.MaxLifeTime < 0 {
+		errs = append(errs, fmt.Errorf("%s pool sizes & MAXLIFETIME cannot be negative", section))
+	}
+
+	return errs
+}
+
+// validateRateLimitRule checks one token bucket, name is the key of the rule
+func validateRateLimitRule(name string, rule RateLimitRule) []error {
+	var errs []error
+	if rule.Limit < 0 || rule.Period < 0 || rule.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%s LIMIT, PERIOD & BURST cannot be negative", name))
+	}
+
+	switch rule.Key {
+	case "", RateLimitKeyUser, RateLimitKeyIP, RateLimitKeyAPIKey:
+	default:
+		errs = append(errs, fmt.Errorf("%s.KEY %q is not %s, %s or %s", name, rule.Key, RateLimitKeyUser, RateLimitKeyIP, RateLimitKeyAPIKey))
+	}
+
+	return errs
+}
+
+// secretEnv names the environment variables setting key, e.g. KEY_USER or KEY_USER_FILE
+func secretEnv(key string) string {
+	fields := reflect.TypeOf(SectionService{})
+	for i := 0; i < fields.NumField(); i++ {
+		if field := fields.Field(i); field.Tag.Get("config") == key {
+			env := field.Tag.Get("json")
+			return env + " or " + env + "_FILE"
+		}
+	}
+
+	return key
+}
+
+// validPort reports whether value is a TCP port number
+func validPort(value string) bool {
+	port, err := strconv.Atoi(value)
+	return err == nil && port > 0 && port <= 65535
+}
@@ -23,12 +23,17 @@ type Database interface {
 	// DriverName() string
 
 	Begin() (*sql.Tx, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	Conn(ctx context.Context) (*sql.Conn, error)
 	In(query string, params ...interface{}) (string, []interface{}, error)
 	Rebind(query string) string
 	Select(dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row
 	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	PingContext(ctx context.Context) error
+	Stats() sql.DBStats
 }
 
 type DatabaseList struct {
@@ -102,6 +107,11 @@ func (d *DBHandler) Select(dest interface{}, query string, args ...interface{})
 	return err
 }
 
+func (d *DBHandler) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	err := d.DB.SelectContext(ctx, dest, query, args...)
+	return err
+}
+
 func (d *DBHandler) Get(dest interface{}, query string, args ...interface{}) error {
 	err := d.DB.Get(dest, query, args...)
 	return err
@@ -120,6 +130,15 @@ func (d *DBHandler) Begin() (*sql.Tx, error) {
 	return d.DB.Begin()
 }
 
+func (d *DBHandler) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return d.DB.BeginTx(ctx, opts)
+}
+
+// Conn returns a single dedicated connection, needed by session scoped statements like advisory locks
+func (d *DBHandler) Conn(ctx context.Context) (*sql.Conn, error) {
+	return d.DB.Conn(ctx)
+}
+
 func (d *DBHandler) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
 	return d.DB.QueryRowContext(ctx, query, args...)
 }
@@ -133,3 +152,13 @@ func (d *DBHandler) QueryContext(ctx context.Context, query string, args ...inte
 	result, err := d.DB.QueryContext(ctx, query, args...)
 	return result, err
 }
+
+// PingContext checks the database is reachable, used by the health checker
+func (d *DBHandler) PingContext(ctx context.Context) error {
+	return d.DB.PingContext(ctx)
+}
+
+// Stats returns the connection pool statistics, exposed as metrics
+func (d *DBHandler) Stats() sql.DBStats {
+	return d.DB.Stats()
+}
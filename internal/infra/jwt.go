@@ -1,67 +1,197 @@
 package infra
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/febriandani/backend-user-service/internal/utils"
 )
 
 const (
 	issuer      = "pharmaniaga-loyalty-backend"
 	renewClaims = "ddc20ad0"
+	mfaAudience = "mfa"
+
+	defaultMFATokenDuration = 5 * time.Minute
 )
 
-var jwtCfg JWT
+var (
+	jwtCfg           JWT
+	jwtDurations     atomic.Pointer[tokenDurations]
+	sessionValidator SessionValidator
+)
 
 type JWT struct {
-	atSecretKey []byte        //Access Token Secret Key
-	atd         time.Duration //Access Token Duration
-	rtSecretKey []byte        //Refresh Token Secret Key
-	rtd         time.Duration //Refresh Token Duration
+	atSecretKey []byte             //Access Token Secret Key
+	rtSecretKey []byte             //Refresh Token Secret Key
+	signingKey  *jwtKey            //Access Token asymmetric signing key, nil when signing with HS256
+	verifyKeys  map[string]*jwtKey //Access Token verification keys by kid
+	keyIDs      []string           //kid of the verification keys in configuration order
+	mfaKey      []byte             //MFA challenge token secret key, derived from the Refresh Token Secret Key
+}
+
+// tokenDurations are kept apart from the keys, they are reloaded without restart
+type tokenDurations struct {
+	atd  time.Duration //Access Token Duration
+	rtd  time.Duration //Refresh Token Duration
+	mfad time.Duration //MFA challenge token duration
 }
 
+// Claims is the payload of Access Token & Refresh Token
+// The jti of an Access Token and the Family of a Refresh Token are the id of the session they belong to
 type Claims struct {
 	jwt.StandardClaims
 	Session string `json:"session"`
 	Renew   string `json:"renew,omitempty"`
+	Family  string `json:"family,omitempty"`
 }
 
-func InitJWTConfig(cfg JWTCredential) {
-	jwtCfg = JWT{
+// TokenPair is the Access Token & Refresh Token issued together
+// Family is shared by every Refresh Token rotated from the same login and is used as the session id
+type TokenPair struct {
+	AccessToken        string
+	AccessTokenExpired time.Time
+	RenewToken         string
+	RenewTokenID       string
+	RenewTokenExpired  time.Time
+	Family             string
+}
+
+// InitJWTConfig loads the token secrets, durations and the asymmetric keys
+// Access Token is signed with the key SigningKeyID when SigningMethod is RS256 or EdDSA, every configured key can verify
+func InitJWTConfig(cfg JWTCredential) error {
+	config := JWT{
 		atSecretKey: []byte(cfg.AccessTokenSecretKey),
-		atd:         time.Duration(cfg.AccessTokenDuration) * time.Minute,
 		rtSecretKey: []byte(cfg.RefreshTokenSecretKey),
-		rtd:         time.Duration(cfg.RefreshTokenDuration) * 24 * time.Hour,
+		verifyKeys:  make(map[string]*jwtKey, len(cfg.Keys)),
+	}
+
+	// a distinct key, so a challenge token is never accepted as an Access Token or a Refresh Token
+	mac := hmac.New(sha256.New, config.rtSecretKey)
+	mac.Write([]byte(mfaAudience))
+	config.mfaKey = mac.Sum(nil)
+
+	for _, keyCfg := range cfg.Keys {
+		key, err := loadJWTKey(keyCfg)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := config.verifyKeys[key.id]; ok {
+			return fmt.Errorf("jwt key %s is duplicated", key.id)
+		}
+
+		config.verifyKeys[key.id] = key
+		config.keyIDs = append(config.keyIDs, key.id)
 	}
+
+	switch cfg.SigningMethod {
+	case "", SigningMethodHS256:
+	case SigningMethodRS256, SigningMethodEdDSA:
+		key, ok := config.verifyKeys[cfg.SigningKeyID]
+		if !ok {
+			return fmt.Errorf("jwt signing key %s is not configured", cfg.SigningKeyID)
+		}
+
+		if key.privateKey == nil {
+			return fmt.Errorf("jwt signing key %s has no private key", cfg.SigningKeyID)
+		}
+
+		if key.method.Alg() != cfg.SigningMethod {
+			return fmt.Errorf("jwt signing key %s cannot sign %s", cfg.SigningKeyID, cfg.SigningMethod)
+		}
+
+		config.signingKey = key
+	default:
+		return fmt.Errorf("jwt signing method %s is not supported", cfg.SigningMethod)
+	}
+
+	jwtCfg = config
+	SetJWTDurations(cfg)
+
+	return nil
+}
+
+// SetJWTDurations sets the durations of the tokens issued from now on, the tokens already issued keep theirs
+func SetJWTDurations(cfg JWTCredential) {
+	durations := &tokenDurations{
+		atd:  time.Duration(cfg.AccessTokenDuration) * time.Minute,
+		rtd:  time.Duration(cfg.RefreshTokenDuration) * 24 * time.Hour,
+		mfad: time.Duration(cfg.MFATokenDuration) * time.Minute,
+	}
+
+	if durations.mfad <= 0 {
+		durations.mfad = defaultMFATokenDuration
+	}
+
+	jwtDurations.Store(durations)
+}
+
+// SessionValidator reports whether a session is still active
+type SessionValidator interface {
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// InitSessionValidator sets the store used by CheckAccessToken to reject tokens of revoked sessions
+func InitSessionValidator(validator SessionValidator) {
+	sessionValidator = validator
 }
 
 // GenerateJWT will generate Access Token & Refresh Token
 // Use this when login authentication is success
-func GenerateJWT(session string) (string, string, error) {
+func GenerateJWT(session string) (*TokenPair, error) {
+	return generateTokenPair(session, utils.GenerateTokenID())
+}
+
+func generateTokenPair(session, family string) (*TokenPair, error) {
+	now := time.Now().UTC()
+	durations := jwtDurations.Load()
+
 	//Create Access Token
-	accessToken, err := generateAccessToken(session)
+	accessToken, err := generateAccessToken(session, family, now, durations.atd)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	//Create Refresh Token
-	refreshToken, err := generateRefreshToken(session)
+	renewTokenID := utils.GenerateTokenID()
+	refreshToken, err := generateRefreshToken(session, renewTokenID, family, now, durations.rtd)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return accessToken, refreshToken, nil
+	return &TokenPair{
+		AccessToken:        accessToken,
+		AccessTokenExpired: now.Add(durations.atd),
+		RenewToken:         refreshToken,
+		RenewTokenID:       renewTokenID,
+		RenewTokenExpired:  now.Add(durations.rtd),
+		Family:             family,
+	}, nil
 }
 
-func generateAccessToken(session string) (string, error) {
+func generateAccessToken(session, sessionID string, now time.Time, duration time.Duration) (string, error) {
 	accessClaims := Claims{
 		StandardClaims: jwt.StandardClaims{
+			Id:        sessionID,
 			Issuer:    issuer,
-			ExpiresAt: time.Now().UTC().Add(jwtCfg.atd).Unix(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
 		},
 		Session: session,
 	}
+	if jwtCfg.signingKey != nil {
+		accessToken := jwt.NewWithClaims(jwtCfg.signingKey.method, accessClaims)
+		accessToken.Header["kid"] = jwtCfg.signingKey.id
+		return accessToken.SignedString(jwtCfg.signingKey.privateKey)
+	}
+
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
 	accessSignedToken, err := accessToken.SignedString(jwtCfg.atSecretKey)
 	if err != nil {
@@ -71,14 +201,17 @@ func generateAccessToken(session string) (string, error) {
 	return accessSignedToken, nil
 }
 
-func generateRefreshToken(session string) (string, error) {
+func generateRefreshToken(session, id, family string, now time.Time, duration time.Duration) (string, error) {
 	refreshClaims := Claims{
 		StandardClaims: jwt.StandardClaims{
+			Id:        id,
 			Issuer:    issuer,
-			ExpiresAt: time.Now().UTC().Add(jwtCfg.rtd).Unix(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
 		},
 		Session: session,
 		Renew:   renewClaims,
+		Family:  family,
 	}
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS384, refreshClaims)
 	refreshSignedToken, err := refreshToken.SignedString(jwtCfg.rtSecretKey)
@@ -91,14 +224,8 @@ func generateRefreshToken(session string) (string, error) {
 
 // CheckAccessToken will check validity of access_token
 // This action will be used in middleware
-func CheckAccessToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Signing method invalid")
-		}
-
-		return jwtCfg.atSecretKey, nil
-	})
+func CheckAccessToken(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, accessTokenKey)
 	if err != nil {
 		return nil, err
 	}
@@ -113,13 +240,29 @@ func CheckAccessToken(tokenString string) (jwt.MapClaims, error) {
 		return nil, fmt.Errorf("Invalid Issuer")
 	}
 
+	if sessionValidator != nil {
+		sessionID, _ := claims["jti"].(string)
+		if sessionID == "" {
+			return nil, fmt.Errorf("Invalid JWT Payload")
+		}
+
+		isActive, err := sessionValidator.IsSessionActive(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isActive {
+			return nil, fmt.Errorf("Session Revoked")
+		}
+	}
+
 	return claims, nil
 }
 
-// RenewAccessToken will generate access_token as long as refresh_token still valid
-func RenewAccessToken(tokenString string) (string, error) {
-	//Validating Refresh Token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+// ParseRenewToken will check validity of refresh_token and return its claims
+func ParseRenewToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Signing method invalid")
 		}
@@ -127,27 +270,108 @@ func RenewAccessToken(tokenString string) (string, error) {
 		return jwtCfg.rtSecretKey, nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", fmt.Errorf("Invalid Token")
+	if !token.Valid {
+		return nil, fmt.Errorf("Invalid Token")
+	}
+
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("Invalid Issuer")
+	}
+
+	if claims.Renew != renewClaims || claims.Id == "" || claims.Family == "" {
+		return nil, fmt.Errorf("Invalid JWT Payload")
+	}
+
+	return claims, nil
+}
+
+// accessTokenKey returns the key verifying an Access Token
+// HMAC is only accepted while Access Token is signed with HS256, asymmetric tokens are looked up by kid
+func accessTokenKey(token *jwt.Token) (interface{}, error) {
+	if jwtCfg.signingKey == nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Signing method invalid")
+		}
+
+		return jwtCfg.atSecretKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtCfg.verifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id")
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Signing method invalid")
 	}
 
-	checker := fmt.Sprintf("%v", claims["renew"])
-	if checker != renewClaims {
-		return "", fmt.Errorf("Invalid JWT Payload")
+	return key.publicKey, nil
+}
+
+// GenerateMFAToken will generate the short-lived challenge token of a user whose password is checked but not the second factor yet
+func GenerateMFAToken(userID uint64) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(jwtDurations.Load().mfad)
+
+	mfaClaims := jwt.StandardClaims{
+		Id:        utils.GenerateTokenID(),
+		Subject:   strconv.FormatUint(userID, 10),
+		Audience:  mfaAudience,
+		Issuer:    issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
 	}
 
-	//Generate new access_token
-	session := fmt.Sprintf("%v", claims["session"])
-	accessToken, err := generateAccessToken(session)
+	mfaToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, mfaClaims).SignedString(jwtCfg.mfaKey)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return accessToken, nil
+	return mfaToken, expiresAt, nil
+}
+
+// ParseMFAToken will check validity of a challenge token and return the user id it was issued to
+func ParseMFAToken(tokenString string) (uint64, error) {
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Signing method invalid")
+		}
+
+		return jwtCfg.mfaKey, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if !token.Valid {
+		return 0, fmt.Errorf("Invalid Token")
+	}
+
+	if claims.Issuer != issuer {
+		return 0, fmt.Errorf("Invalid Issuer")
+	}
+
+	if claims.Audience != mfaAudience {
+		return 0, fmt.Errorf("Invalid JWT Payload")
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil || userID == 0 {
+		return 0, fmt.Errorf("Invalid JWT Payload")
+	}
+
+	return userID, nil
+}
+
+// RenewAccessToken will generate a new token pair in the same family as the given refresh_token claims
+// The claims must come from ParseRenewToken
+func RenewAccessToken(claims *Claims) (*TokenPair, error) {
+	return generateTokenPair(claims.Session, claims.Family)
 }
 
 func GetUserIDFromToken(session, secretKey string) (string, error) {
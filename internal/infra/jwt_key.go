@@ -0,0 +1,195 @@
+package infra
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Supported access token signing methods
+const (
+	SigningMethodHS256 = "HS256"
+	SigningMethodRS256 = "RS256"
+	SigningMethodEdDSA = "EdDSA"
+)
+
+// SigningMethodEd25519 signs tokens with Ed25519 keys, jwt-go v3 does not ship one
+var SigningMethodEd25519 = &signingMethodEd25519{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA, func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+type signingMethodEd25519 struct{}
+
+func (m *signingMethodEd25519) Alg() string {
+	return SigningMethodEdDSA
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// jwtKey is an asymmetric key identified by its kid
+// privateKey is nil for keys that are only kept to verify tokens issued before a rotation
+type jwtKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// loadJWTKey reads the PEM files of a configured key
+func loadJWTKey(cfg JWTKey) (*jwtKey, error) {
+	if cfg.KID == "" {
+		return nil, fmt.Errorf("jwt key id cannot be empty")
+	}
+
+	key := &jwtKey{id: cfg.KID}
+
+	if cfg.PrivateKeyFile != "" {
+		block, err := readPEM(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %s: %v", cfg.KID, err)
+			}
+		}
+
+		switch k := privateKey.(type) {
+		case *rsa.PrivateKey:
+			key.privateKey, key.publicKey = k, &k.PublicKey
+		case ed25519.PrivateKey:
+			key.privateKey, key.publicKey = k, k.Public()
+		default:
+			return nil, fmt.Errorf("jwt key %s: unsupported private key type %T", cfg.KID, privateKey)
+		}
+	}
+
+	if cfg.PublicKeyFile != "" {
+		block, err := readPEM(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %v", cfg.KID, err)
+		}
+
+		key.publicKey = publicKey
+	}
+
+	switch key.publicKey.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = SigningMethodEd25519
+	case nil:
+		return nil, fmt.Errorf("jwt key %s: private or public key file is required", cfg.KID)
+	default:
+		return nil, fmt.Errorf("jwt key %s: unsupported public key type %T", cfg.KID, key.publicKey)
+	}
+
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	return block, nil
+}
+
+// jwk converts the public part of the key into a JSON Web Key
+func (k *jwtKey) jwk() JWK {
+	result := JWK{
+		Kid: k.id,
+		Use: "sig",
+		Alg: k.method.Alg(),
+	}
+
+	switch publicKey := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		result.Kty = "RSA"
+		result.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		result.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		result.Kty = "OKP"
+		result.Crv = "Ed25519"
+		result.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+
+	return result
+}
+
+// PublicJWKS returns every verification key, empty when access tokens are signed with HS256
+func PublicJWKS() JWKS {
+	result := JWKS{Keys: make([]JWK, 0, len(jwtCfg.keyIDs))}
+	for _, kid := range jwtCfg.keyIDs {
+		result.Keys = append(result.Keys, jwtCfg.verifyKeys[kid].jwk())
+	}
+
+	return result
+}
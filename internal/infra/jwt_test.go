@@ -0,0 +1,323 @@
+package infra
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// testKeys are generated once, RSA keys are slow to generate
+var testKeys = struct {
+	rsa     *rsa.PrivateKey
+	rotated *rsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}{}
+
+func init() {
+	var err error
+	if testKeys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testKeys.rotated, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if _, testKeys.ed25519, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+}
+
+// writePEM writes a PEM file in the test directory and returns its path
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+
+	return path
+}
+
+// privateKeyFile writes a PKCS #8 private key
+func privateKeyFile(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	return writePEM(t, "private.pem", "PRIVATE KEY", der)
+}
+
+// publicKeyFile writes a PKIX public key
+func publicKeyFile(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	return writePEM(t, "public.pem", "PUBLIC KEY", der)
+}
+
+// initJWT configures the tokens with the given access token signing, failing the test on error
+func initJWT(t *testing.T, method, kid string, keys ...JWTKey) {
+	t.Helper()
+
+	err := InitJWTConfig(JWTCredential{
+		IsActive:              true,
+		AccessTokenSecretKey:  "access-token-secret-key",
+		AccessTokenDuration:   5,
+		RefreshTokenSecretKey: "refresh-token-secret-key",
+		RefreshTokenDuration:  1,
+		SigningMethod:         method,
+		SigningKeyID:          kid,
+		Keys:                  keys,
+	})
+	if err != nil {
+		t.Fatalf("InitJWTConfig(%s): %v", method, err)
+	}
+}
+
+// signAccessToken signs access token claims like generateAccessToken, with any method, key & kid
+func signAccessToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, Claims{
+		StandardClaims: jwt.StandardClaims{Id: "session-id", Issuer: issuer, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()},
+		Session:        "session",
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString(%s): %v", method.Alg(), err)
+	}
+
+	return signed
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		kid     string
+		keys    func(t *testing.T) []JWTKey
+		wantAlg string
+	}{
+		{
+			name:    "HS256",
+			method:  SigningMethodHS256,
+			keys:    func(t *testing.T) []JWTKey { return nil },
+			wantAlg: "HS256",
+		},
+		{
+			name:   "RS256",
+			method: SigningMethodRS256,
+			kid:    "rsa-1",
+			keys: func(t *testing.T) []JWTKey {
+				return []JWTKey{{KID: "rsa-1", PrivateKeyFile: privateKeyFile(t, testKeys.rsa)}}
+			},
+			wantAlg: "RS256",
+		},
+		{
+			name:   "EdDSA",
+			method: SigningMethodEdDSA,
+			kid:    "ed-1",
+			keys: func(t *testing.T) []JWTKey {
+				return []JWTKey{{KID: "ed-1", PrivateKeyFile: privateKeyFile(t, testKeys.ed25519)}}
+			},
+			wantAlg: "EdDSA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initJWT(t, tt.method, tt.kid, tt.keys(t)...)
+
+			pair, err := GenerateJWT("session")
+			if err != nil {
+				t.Fatalf("GenerateJWT(): %v", err)
+			}
+
+			token, _, err := new(jwt.Parser).ParseUnverified(pair.AccessToken, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified(): %v", err)
+			}
+			if token.Method.Alg() != tt.wantAlg || (tt.kid != "" && token.Header["kid"] != tt.kid) {
+				t.Errorf("access token header = %v, want alg %s kid %q", token.Header, tt.wantAlg, tt.kid)
+			}
+
+			claims, err := CheckAccessToken(context.Background(), pair.AccessToken)
+			if err != nil {
+				t.Fatalf("CheckAccessToken(): %v", err)
+			}
+			if claims["jti"] != pair.Family || claims["session"] != "session" {
+				t.Errorf("CheckAccessToken() claims = %v, want jti %s and the session", claims, pair.Family)
+			}
+
+			// the refresh token stays HMAC whatever signs the access token, one is never accepted as the other
+			if _, err := CheckAccessToken(context.Background(), pair.RenewToken); err == nil {
+				t.Errorf("CheckAccessToken() accepted a refresh token")
+			}
+			if _, err := ParseRenewToken(pair.RenewToken); err != nil {
+				t.Errorf("ParseRenewToken(): %v", err)
+			}
+		})
+	}
+}
+
+func TestAccessTokenRotatedKey(t *testing.T) {
+	oldPrivate := privateKeyFile(t, testKeys.rotated)
+	oldPublic := publicKeyFile(t, &testKeys.rotated.PublicKey)
+	newPrivate := privateKeyFile(t, testKeys.rsa)
+
+	initJWT(t, SigningMethodRS256, "rsa-old", JWTKey{KID: "rsa-old", PrivateKeyFile: oldPrivate})
+	pair, err := GenerateJWT("session")
+	if err != nil {
+		t.Fatalf("GenerateJWT(): %v", err)
+	}
+
+	// rotated, the old key is kept without its private key to verify the tokens it signed
+	initJWT(t, SigningMethodRS256, "rsa-new", JWTKey{KID: "rsa-new", PrivateKeyFile: newPrivate}, JWTKey{KID: "rsa-old", PublicKeyFile: oldPublic})
+
+	if _, err := CheckAccessToken(context.Background(), pair.AccessToken); err != nil {
+		t.Errorf("CheckAccessToken() of a token signed before the rotation: %v", err)
+	}
+
+	rotated, err := GenerateJWT("session")
+	if err != nil {
+		t.Fatalf("GenerateJWT() after the rotation: %v", err)
+	}
+	token, _, _ := new(jwt.Parser).ParseUnverified(rotated.AccessToken, jwt.MapClaims{})
+	if token.Header["kid"] != "rsa-new" {
+		t.Errorf("access token kid after the rotation = %v, want rsa-new", token.Header["kid"])
+	}
+
+	// a key without private key cannot sign
+	err = InitJWTConfig(JWTCredential{SigningMethod: SigningMethodRS256, SigningKeyID: "rsa-old", Keys: []JWTKey{{KID: "rsa-old", PublicKeyFile: oldPublic}}})
+	if err == nil {
+		t.Errorf("InitJWTConfig() accepted a signing key without private key")
+	}
+}
+
+func TestAccessTokenRefused(t *testing.T) {
+	initJWT(t, SigningMethodRS256, "rsa-1", JWTKey{KID: "rsa-1", PrivateKeyFile: privateKeyFile(t, testKeys.rsa)})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&testKeys.rsa.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "unknown kid", token: signAccessToken(t, jwt.SigningMethodRS256, "rsa-unknown", testKeys.rsa)},
+		{name: "missing kid", token: signAccessToken(t, jwt.SigningMethodRS256, "", testKeys.rsa)},
+		{name: "other key under a known kid", token: signAccessToken(t, jwt.SigningMethodRS256, "rsa-1", testKeys.rotated)},
+		{name: "HS256 signed with the public key", token: signAccessToken(t, jwt.SigningMethodHS256, "rsa-1", publicPEM)},
+		{name: "HS256 signed with the public key DER", token: signAccessToken(t, jwt.SigningMethodHS256, "rsa-1", publicDER)},
+		{name: "HS256 signed with the access secret", token: signAccessToken(t, jwt.SigningMethodHS256, "rsa-1", []byte("access-token-secret-key"))},
+		{name: "EdDSA under the RSA kid", token: signAccessToken(t, SigningMethodEd25519, "rsa-1", testKeys.ed25519)},
+		{name: "none", token: signAccessToken(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CheckAccessToken(context.Background(), tt.token); err == nil {
+				t.Errorf("CheckAccessToken() accepted the token")
+			}
+		})
+	}
+}
+
+func TestAccessTokenHS256RefusesAsymmetric(t *testing.T) {
+	initJWT(t, SigningMethodHS256, "", JWTKey{KID: "rsa-1", PrivateKeyFile: privateKeyFile(t, testKeys.rsa)})
+
+	// while signing with HS256 the configured keys do not verify access tokens
+	token := signAccessToken(t, jwt.SigningMethodRS256, "rsa-1", testKeys.rsa)
+	if _, err := CheckAccessToken(context.Background(), token); err == nil {
+		t.Errorf("CheckAccessToken() accepted a RS256 token while signing with HS256")
+	}
+}
+
+func TestPublicJWKS(t *testing.T) {
+	initJWT(t, SigningMethodEdDSA, "ed-1",
+		JWTKey{KID: "ed-1", PrivateKeyFile: privateKeyFile(t, testKeys.ed25519)},
+		JWTKey{KID: "rsa-old", PublicKeyFile: publicKeyFile(t, &testKeys.rsa.PublicKey)},
+	)
+
+	data, err := json.Marshal(PublicJWKS())
+	if err != nil {
+		t.Fatalf("Marshal(): %v", err)
+	}
+
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		t.Fatalf("Unmarshal(%s): %v", data, err)
+	}
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("PublicJWKS() = %s, want 2 keys", data)
+	}
+
+	ed := jwks.Keys[0]
+	publicKey := testKeys.ed25519.Public().(ed25519.PublicKey)
+	wantEd := map[string]string{"kty": "OKP", "kid": "ed-1", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(publicKey)}
+	if !equalJWK(ed, wantEd) {
+		t.Errorf("Ed25519 JWK = %v, want %v", ed, wantEd)
+	}
+
+	rsaJWK := jwks.Keys[1]
+	wantRSA := map[string]string{
+		"kty": "RSA", "kid": "rsa-old", "use": "sig", "alg": "RS256",
+		"n": base64.RawURLEncoding.EncodeToString(testKeys.rsa.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(testKeys.rsa.E)).Bytes()),
+	}
+	if !equalJWK(rsaJWK, wantRSA) {
+		t.Errorf("RSA JWK = %v, want %v", rsaJWK, wantRSA)
+	}
+
+	// the secret of HS256 is never published
+	initJWT(t, SigningMethodHS256, "")
+	if keys := PublicJWKS().Keys; len(keys) != 0 {
+		t.Errorf("PublicJWKS() with HS256 = %v, want no key", keys)
+	}
+}
+
+func equalJWK(got, want map[string]string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+
+	for k, v := range want {
+		if got[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,183 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RequestIDKey is the grpc metadata & the log field of the correlation id of a request
+const RequestIDKey = "x-request-id"
+
+// Redacted replaces the value of the sensitive fields in the logs
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the parts of a field name whose value is never logged
+var sensitiveKeys = []string{"password", "token", "secret", "authorization"}
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a context carrying the correlation id of the request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestID returns the correlation id of the request, empty outside a request
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// RequestIDHook adds the request_id field to the lines logged with WithContext
+type RequestIDHook struct{}
+
+// NewRequestIDHook creates the hook adding the request id of the context
+func NewRequestIDHook() *RequestIDHook {
+	return &RequestIDHook{}
+}
+
+func (h *RequestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *RequestIDHook) Fire(entry *logrus.Entry) error {
+	requestID := RequestID(entry.Context)
+	if requestID != "" {
+		entry.Data["request_id"] = requestID
+	}
+
+	return nil
+}
+
+// RedactHook masks the password, token & secret fields of the protos, maps, structs and JSON strings logged
+type RedactHook struct{}
+
+// NewRedactHook creates the hook masking the secrets before a line is written
+func NewRedactHook() *RedactHook {
+	return &RedactHook{}
+}
+
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	for key, value := range entry.Data {
+		if key == logrus.ErrorKey {
+			continue
+		}
+
+		if isSensitive(key) {
+			entry.Data[key] = Redacted
+			continue
+		}
+
+		entry.Data[key] = Redact(value)
+	}
+
+	return nil
+}
+
+// Redact returns a copy of value with the sensitive fields masked, value itself is left untouched
+// Protos, structs and JSON strings are returned as maps or slices
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case proto.Message:
+		data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(v)
+		if err != nil {
+			return Redacted
+		}
+
+		return redactJSON(data)
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+			return v
+		}
+
+		var decoded interface{}
+		if json.Unmarshal([]byte(trimmed), &decoded) != nil {
+			return v
+		}
+
+		return Redact(decoded)
+	case map[string]string:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if isSensitive(key) {
+				result[key] = Redacted
+				continue
+			}
+			result[key] = item
+		}
+
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if isSensitive(key) {
+				result[key] = Redacted
+				continue
+			}
+			result[key] = Redact(item)
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = Redact(item)
+		}
+
+		return result
+	case error:
+		return v
+	}
+
+	// other structs & maps are masked through their JSON form
+	kind := reflect.Indirect(reflect.ValueOf(value)).Kind()
+	if kind == reflect.Struct || kind == reflect.Map || kind == reflect.Slice {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return Redacted
+		}
+
+		return redactJSON(data)
+	}
+
+	return value
+}
+
+// redactJSON masks the sensitive fields of a JSON document, a document that does not parse is masked entirely
+func redactJSON(data []byte) interface{} {
+	var decoded interface{}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return Redacted
+	}
+
+	return Redact(decoded)
+}
+
+// isSensitive reports whether a field name holds a password, a token or a secret
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/febriandani/backend-user-service/internal/utils"
+	users "github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+)
+
+// secrets never expected in a log line
+var loggedSecrets = []string{"p4ssw0rd", "r3p4ssw0rd", "renew.token.value"}
+
+// newRedactedLogger returns a JSON logger with the hooks of NewLogger writing to buf
+func newRedactedLogger(buf *bytes.Buffer) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(NewRequestIDHook())
+	logger.AddHook(NewRedactHook())
+
+	return logger
+}
+
+func TestRedactHook(t *testing.T) {
+	login := &users.PayloadWithSingleUser{User: &users.User{
+		Username:   "john",
+		Email:      "john@example.com",
+		Password:   "p4ssw0rd",
+		Repassword: "r3p4ssw0rd",
+	}}
+	tokens := &users.JWTAccess{AccessTokenExpired: "2026-01-01", RenewToken: "renew.token.value"}
+
+	tests := []struct {
+		name   string
+		value  interface{}
+		masked []string
+		kept   []string
+	}{
+		{
+			name:   "login request proto",
+			value:  login,
+			masked: []string{"user.password", "user.repassword"},
+			kept:   []string{"user.username", "user.email"},
+		},
+		{
+			name:   "token proto",
+			value:  tokens,
+			masked: []string{"renew_token", "access_token_expired"},
+		},
+		{
+			name:   "StructToString JSON",
+			value:  utils.StructToString(login),
+			masked: []string{"user.password", "user.repassword"},
+			kept:   []string{"user.username", "user.email"},
+		},
+		{
+			name:   "map[string]string",
+			value:  map[string]string{"username": "john", "password": "p4ssw0rd", "repassword": "r3p4ssw0rd", "renew_token": "renew.token.value"},
+			masked: []string{"password", "repassword", "renew_token"},
+			kept:   []string{"username"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			newRedactedLogger(&buf).WithContext(WithRequestID(context.Background(), "req-1")).WithField("request", tt.value).Error("LoginUser | Failed to login")
+
+			line := buf.String()
+			for _, secret := range loggedSecrets {
+				if strings.Contains(line, secret) {
+					t.Errorf("logged %q in %s", secret, line)
+				}
+			}
+
+			var entry map[string]interface{}
+			err := json.Unmarshal(buf.Bytes(), &entry)
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", line, err)
+			}
+			if entry["request_id"] != "req-1" {
+				t.Errorf("request_id = %v, want req-1", entry["request_id"])
+			}
+
+			for _, path := range tt.masked {
+				if got := field(entry["request"], path); got != Redacted {
+					t.Errorf("%s = %v, want %s", path, got, Redacted)
+				}
+			}
+			for _, path := range tt.kept {
+				if got := field(entry["request"], path); got == nil || got == Redacted {
+					t.Errorf("%s = %v, want it logged", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactHookSensitiveKey(t *testing.T) {
+	var buf bytes.Buffer
+	newRedactedLogger(&buf).WithField("renew_token", "renew.token.value").WithField("password", "p4ssw0rd").Error("failed")
+
+	for _, secret := range loggedSecrets {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("logged %q in %s", secret, buf.String())
+		}
+	}
+}
+
+func TestRedactLeavesValue(t *testing.T) {
+	value := map[string]string{"password": "p4ssw0rd"}
+	Redact(value)
+
+	if value["password"] != "p4ssw0rd" {
+		t.Errorf("Redact() modified its argument: %v", value)
+	}
+}
+
+// field returns the value at the dotted path of a decoded JSON document
+func field(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	return value
+}
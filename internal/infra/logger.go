@@ -1,17 +1,19 @@
 package infra
 
 import (
+	"io"
 	"os"
 
 	"github.com/febriandani/backend-user-service/internal/utils"
 	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
-	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 )
 
 var logger *logrus.Logger
 
+// NewLogger creates the JSON logger writing to stdout & the daily files of log/
+// Every line carries the request id of its context and the secrets of its fields are masked
 func NewLogger(conf *AppService) *logrus.Logger {
 	if logger == nil {
 		path := "log/"
@@ -39,38 +41,37 @@ func NewLogger(conf *AppService) *logrus.Logger {
 		}
 
 		logger = logrus.New()
-
-		// TODO: Active this code if later it's needed to limit the log
-		// // Set Log level that need to show or stored
-		// if conf.App.Environtment == constants.EnvProd {
-		// 	logger.SetLevel(logrus.WarnLevel)
-		// } else {
-		// 	logger.SetLevel(logrus.DebugLevel)
-		// }
-
-		// Set Hook with writer & formatter for log file
-		logger.Hooks.Add(lfshook.NewHook(
-			writer,
-			&logrus.TextFormatter{
-				DisableColors:   false,
-				FullTimestamp:   true,
-				TimestampFormat: general.FullTimeFormat,
-			},
-		))
-
-		// Set formatter for os.Stdout
-		logger.SetFormatter(&logrus.TextFormatter{
-			DisableColors:   false,
-			FullTimestamp:   true,
+		logger.SetOutput(io.MultiWriter(os.Stdout, writer))
+		logger.SetLevel(LogLevel(conf))
+		logger.SetFormatter(&logrus.JSONFormatter{
 			TimestampFormat: general.FullTimeFormat,
 		})
 
+		logger.AddHook(NewRequestIDHook())
+		logger.AddHook(NewRedactHook())
+
 		return logger
 	}
 
 	return logger
 }
 
+// LogLevel returns LOG.LEVEL, or warn in production and debug elsewhere when it is empty or unknown
+func LogLevel(conf *AppService) logrus.Level {
+	if conf.Log.Level != "" {
+		level, err := logrus.ParseLevel(conf.Log.Level)
+		if err == nil {
+			return level
+		}
+	}
+
+	if conf.App.Environtment == general.EnvProd {
+		return logrus.WarnLevel
+	}
+
+	return logrus.DebugLevel
+}
+
 func TestNewLogger() *logrus.Logger {
 	logger := logrus.New()
 	return logger
@@ -1 +1,74 @@
 package infra
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRedisTimeout = 200 * time.Millisecond
+
+// NewRedisClient connects to REDIS and pings it, the client is nil when REDIS.URL is empty
+func NewRedisClient(conf RedisUser, logger *logrus.Logger) (*redis.Client, error) {
+	if conf.URL == "" {
+		return nil, nil
+	}
+
+	timeout := redisTimeout(conf.Timeout)
+
+	options := &redis.Options{
+		Addr:         fmt.Sprintf("%s:%d", conf.URL, conf.Port),
+		Username:     conf.Username,
+		Password:     conf.Password,
+		MinIdleConns: conf.MinIdleConns,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+	if conf.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: conf.URL}
+	}
+
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		logger.Error(ConnectRedisFail + " | " + err.Error())
+		return nil, err
+	}
+
+	logger.Info(ConnectRedisSuccess)
+
+	return client, nil
+}
+
+// redisTimeout reads REDIS.TIMEOUT as milliseconds or as a duration such as 500ms
+func redisTimeout(value string) time.Duration {
+	if timeout, ok := parseRedisTimeout(value); ok {
+		return timeout
+	}
+
+	return defaultRedisTimeout
+}
+
+// parseRedisTimeout returns the positive timeout of REDIS.TIMEOUT, false when it is neither milliseconds nor a duration
+func parseRedisTimeout(value string) (time.Duration, bool) {
+	if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+
+	if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+		return timeout, true
+	}
+
+	return 0, false
+}
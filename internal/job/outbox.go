@@ -0,0 +1,177 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/event"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRelayInterval   = 5 * time.Second
+	defaultRelayBatchSize  = 100
+	defaultOutboxRetention = 7
+	defaultMaxAttempts     = 10
+	outboxPurgeInterval    = time.Hour
+)
+
+// outboxStore is the part of the repository used by the relay
+type outboxStore interface {
+	Begin(ctx context.Context) (db.Tx, error)
+	db.OutboxRepository
+}
+
+// OutboxRelay publishes the events of each user in their write order with at-least-once delivery
+// An event is marked published in the transaction that claimed it, after the broker confirmed it,
+// so an event whose mark is lost is published again by the next run.
+// An event refused EVENTS.MAX_ATTEMPTS times is dead-lettered so the later events of its user go on,
+// the events of the other users never wait for it
+type OutboxRelay struct {
+	db        outboxStore
+	publisher event.Publisher
+	log       *logrus.Logger
+	conf      *infra.AppService
+}
+
+// NewOutboxRelay creates the outbox relay
+func NewOutboxRelay(db outboxStore, publisher event.Publisher, logger *logrus.Logger, conf *infra.AppService) *OutboxRelay {
+	return &OutboxRelay{
+		db:        db,
+		publisher: publisher,
+		log:       logger,
+		conf:      conf,
+	}
+}
+
+// Run publishes the pending events every interval until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) {
+	conf := r.conf.Events
+
+	interval := time.Duration(conf.RelayInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultRelayInterval
+	}
+
+	batchSize := conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRelayBatchSize
+	}
+
+	retentionDays := conf.RetentionDays
+	if retentionDays <= 0 {
+		retentionDays = defaultOutboxRetention
+	}
+
+	maxAttempts := conf.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+
+	for {
+		// a full batch means more events are waiting, they are relayed without waiting for the ticker
+		for ctx.Err() == nil {
+			if r.relay(ctx, batchSize, maxAttempts) < batchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) >= outboxPurgeInterval {
+			r.purge(ctx, retentionDays)
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes a batch of events and returns how many were published
+// A failure stops the events of its user until the next run, they must not be published out of order
+func (r *OutboxRelay) relay(ctx context.Context, batchSize, maxAttempts int) int {
+	//start transaction db
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.log.WithError(err).Errorf("OutboxRelay | Failed to begin transaction")
+		return 0
+	}
+
+	events, err := r.db.ClaimOutboxEvents(ctx, tx, batchSize)
+	if err != nil {
+		tx.Rollback()
+		r.log.WithError(err).Errorf("OutboxRelay | Failed to claim events")
+		return 0
+	}
+
+	published := 0
+	blocked := make(map[uint64]bool)
+	for _, outboxEvent := range events {
+		if blocked[outboxEvent.UserID] {
+			continue
+		}
+
+		err = r.publisher.Publish(ctx, event.NewMessage(outboxEvent))
+		if err != nil {
+			attempts := outboxEvent.Attempts + 1
+			deadLetter := attempts >= maxAttempts
+			r.log.WithField("event_id", outboxEvent.EventID).WithField("attempts", attempts).WithField("dead_letter", deadLetter).WithError(err).Errorf("OutboxRelay | Failed to publish event")
+
+			err = r.db.MarkOutboxEventFailed(ctx, tx, outboxEvent.ID, err.Error(), deadLetter)
+			if err != nil {
+				tx.Rollback()
+				r.log.WithField("event_id", outboxEvent.EventID).WithError(err).Errorf("OutboxRelay | Failed to mark event failed")
+				return 0
+			}
+
+			// a dead-lettered event no longer holds back the next ones, they wait for the next run all the same
+			blocked[outboxEvent.UserID] = true
+			continue
+		}
+
+		err = r.db.MarkOutboxEventPublished(ctx, tx, outboxEvent.ID)
+		if err != nil {
+			tx.Rollback()
+			r.log.WithField("event_id", outboxEvent.EventID).WithError(err).Errorf("OutboxRelay | Failed to mark event published")
+			return 0
+		}
+
+		published++
+	}
+
+	//commit transaction db
+	err = tx.Commit()
+	if err != nil {
+		r.log.WithError(err).Errorf("OutboxRelay | Failed to commit transaction")
+		return 0
+	}
+
+	if published > 0 {
+		r.log.Infof("OutboxRelay | Published %d events", published)
+	}
+
+	return published
+}
+
+func (r *OutboxRelay) purge(ctx context.Context, retentionDays int) {
+	publishedBefore := time.Now().UTC().AddDate(0, 0, -retentionDays)
+
+	purged, err := r.db.PurgeOutboxEvents(ctx, publishedBefore)
+	if err != nil {
+		r.log.WithField("published_before", publishedBefore).WithError(err).Errorf("OutboxRelay | Failed to purge published events")
+		return
+	}
+
+	if purged > 0 {
+		r.log.WithField("published_before", publishedBefore).Infof("OutboxRelay | Purged %d published events", purged)
+	}
+}
@@ -0,0 +1,182 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/event"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+// rejectingPublisher refuses the events of rejected like a broker refusing a bad payload, the others are kept
+type rejectingPublisher struct {
+	*event.MemoryPublisher
+	rejected map[string]bool
+}
+
+func (p *rejectingPublisher) Publish(ctx context.Context, msg event.Message) error {
+	if p.rejected[msg.ID] {
+		return errors.New("rejected by the broker")
+	}
+
+	return p.MemoryPublisher.Publish(ctx, msg)
+}
+
+// newRelayTest returns a relay on an in-memory outbox with the events of users,
+// named after their user & write order, e.g. u1-e2 is the second event of user 1
+func newRelayTest(t *testing.T, events ...string) (*OutboxRelay, *db.MemoryDB, *rejectingPublisher) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	memory := db.NewMemoryDB(logger)
+	for _, eventID := range events {
+		var userID uint64
+		_, err := fmt.Sscanf(eventID, "u%d-", &userID)
+		if err != nil {
+			t.Fatalf("event id %q: %v", eventID, err)
+		}
+
+		err = memory.SaveOutboxEvent(context.Background(), nil, &db.OutboxEvent{
+			EventID:   eventID,
+			Type:      "user.updated",
+			UserID:    userID,
+			Payload:   []byte(`{}`),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("SaveOutboxEvent(%s): %v", eventID, err)
+		}
+	}
+
+	publisher := &rejectingPublisher{MemoryPublisher: event.NewMemoryPublisher(), rejected: map[string]bool{}}
+
+	return NewOutboxRelay(memory, publisher, logger, &infra.AppService{}), memory, publisher
+}
+
+// publishedIDs returns the ids of the published events in their publication order
+func publishedIDs(p *rejectingPublisher) []string {
+	ids := make([]string, 0)
+	for _, msg := range p.Messages() {
+		ids = append(ids, msg.ID)
+	}
+
+	return ids
+}
+
+// pending returns the events still waiting in the outbox by id
+func pending(t *testing.T, memory *db.MemoryDB) map[string]db.OutboxEvent {
+	t.Helper()
+
+	events, err := memory.ClaimOutboxEvents(context.Background(), nil, 100)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents(): %v", err)
+	}
+
+	result := make(map[string]db.OutboxEvent)
+	for _, e := range events {
+		result[e.EventID] = e
+	}
+
+	return result
+}
+
+func TestOutboxRelayPublishes(t *testing.T) {
+	relay, memory, publisher := newRelayTest(t, "u1-e1", "u2-e1", "u1-e2", "u2-e2")
+
+	published := relay.relay(context.Background(), 100, 3)
+	if published != 4 {
+		t.Errorf("relay() = %d, want 4 published", published)
+	}
+
+	want := []string{"u1-e1", "u1-e2", "u2-e1", "u2-e2"}
+	if got := publishedIDs(publisher); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want the write order of each user %v", got, want)
+	}
+
+	if left := pending(t, memory); len(left) > 0 {
+		t.Errorf("events left pending after publication: %v", left)
+	}
+}
+
+func TestOutboxRelayBatchSize(t *testing.T) {
+	relay, _, publisher := newRelayTest(t, "u1-e1", "u1-e2", "u1-e3")
+
+	if published := relay.relay(context.Background(), 2, 3); published != 2 {
+		t.Errorf("relay() = %d, want a batch of 2", published)
+	}
+	if published := relay.relay(context.Background(), 2, 3); published != 1 {
+		t.Errorf("relay() = %d, want the last event", published)
+	}
+
+	want := []string{"u1-e1", "u1-e2", "u1-e3"}
+	if got := publishedIDs(publisher); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestOutboxRelayFailure(t *testing.T) {
+	relay, memory, publisher := newRelayTest(t, "u1-e1", "u1-e2", "u2-e1")
+	publisher.rejected["u1-e1"] = true
+
+	published := relay.relay(context.Background(), 100, 3)
+	if published != 1 {
+		t.Errorf("relay() = %d, want 1 published", published)
+	}
+
+	// the failure holds back the next event of its user only
+	want := []string{"u2-e1"}
+	if got := publishedIDs(publisher); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+
+	left := pending(t, memory)
+	failed, ok := left["u1-e1"]
+	if !ok || failed.Attempts != 1 || failed.LastError.String == "" || failed.FailedAt.Valid {
+		t.Errorf("failed event = %+v, want pending with 1 attempt and its error", failed)
+	}
+	if next, ok := left["u1-e2"]; !ok || next.Attempts != 0 {
+		t.Errorf("next event of the user = %+v, want pending and never attempted", next)
+	}
+
+	// the broker accepts it again, the user's events go out in order
+	delete(publisher.rejected, "u1-e1")
+	relay.relay(context.Background(), 100, 3)
+
+	want = []string{"u2-e1", "u1-e1", "u1-e2"}
+	if got := publishedIDs(publisher); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestOutboxRelayDeadLetter(t *testing.T) {
+	relay, memory, publisher := newRelayTest(t, "u1-e1", "u1-e2")
+	publisher.rejected["u1-e1"] = true
+
+	for i := 0; i < 2; i++ {
+		relay.relay(context.Background(), 100, 2)
+	}
+
+	// dead-lettered after 2 attempts, it is no longer claimed and the next event of the user goes on
+	if _, ok := pending(t, memory)["u1-e1"]; ok {
+		t.Errorf("event still pending after the max attempts")
+	}
+	if got := publishedIDs(publisher); len(got) > 0 {
+		t.Errorf("published %v before the dead-letter, want nothing", got)
+	}
+
+	relay.relay(context.Background(), 100, 2)
+
+	want := []string{"u1-e2"}
+	if got := publishedIDs(publisher); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v after the dead-letter, want %v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/db"
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+// Retention hard deletes users that have been soft deleted longer than the configured retention period
+type Retention struct {
+	db   db.UserRepository
+	log  *logrus.Logger
+	conf *infra.AppService
+}
+
+// NewRetention creates the retention job
+func NewRetention(db db.UserRepository, logger *logrus.Logger, conf *infra.AppService) *Retention {
+	return &Retention{
+		db:   db,
+		log:  logger,
+		conf: conf,
+	}
+}
+
+// Run purges deleted users every interval until ctx is done, it does nothing when the purge is disabled
+func (r *Retention) Run(ctx context.Context) {
+	retention := r.conf.User.Retention
+	if retention.PurgeAfterDays <= 0 {
+		r.log.Info("Retention | Purge of deleted users is disabled")
+		return
+	}
+
+	interval := time.Duration(retention.Interval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.purge(ctx, retention.PurgeAfterDays)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Retention) purge(ctx context.Context, purgeAfterDays int) {
+	deletedBefore := time.Now().UTC().AddDate(0, 0, -purgeAfterDays)
+
+	purged, err := r.db.PurgeDeletedUsers(ctx, deletedBefore)
+	if err != nil {
+		r.log.WithField("deleted_before", deletedBefore).WithError(err).Errorf("Retention | Failed to purge deleted users")
+		return
+	}
+
+	if purged > 0 {
+		r.log.WithField("deleted_before", deletedBefore).Infof("Retention | Purged %d deleted users", purged)
+	}
+}
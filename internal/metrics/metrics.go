@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "users"
+
+// Results of the logins and the token refreshes
+const (
+	ResultSuccess     = "success"
+	ResultFailure     = "failure"
+	ResultMFARequired = "mfa_required"
+)
+
+// Reasons of the failed logins and token refreshes
+const (
+	ReasonNone             = ""
+	ReasonInvalidRequest   = "invalid_request"
+	ReasonLocked           = "locked"
+	ReasonUserNotFound     = "user_not_found"
+	ReasonInactive         = "inactive"
+	ReasonWrongPassword    = "wrong_password"
+	ReasonEmailNotVerified = "email_not_verified"
+	ReasonWrongCode        = "wrong_code"
+	ReasonInvalidToken     = "invalid_token"
+	ReasonTokenReused      = "token_reused"
+	ReasonError            = "error"
+)
+
+// Metrics holds the collectors of the service on its own registry
+// Every method does nothing on a nil *Metrics, metrics are optional
+type Metrics struct {
+	registry      *prometheus.Registry
+	rpcDuration   *prometheus.HistogramVec
+	rpcHandled    *prometheus.CounterVec
+	registrations prometheus.Counter
+	logins        *prometheus.CounterVec
+	refreshes     *prometheus.CounterVec
+}
+
+// New creates the collectors, together with the go runtime and process ones
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_duration_seconds",
+			Help:      "Latency of the rpc by method and status code.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"method", "code"}),
+		rpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_handled_total",
+			Help:      "Rpc completed by method and status code.",
+		}, []string{"method", "code"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Users registered.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Logins by result and failure reason, a login needing MFA is counted again once the code is verified.",
+		}, []string{"result", "reason"}),
+		refreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_refreshes_total",
+			Help:      "Token refreshes by result and failure reason.",
+		}, []string{"result", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.rpcDuration,
+		m.rpcHandled,
+		m.registrations,
+		m.logins,
+		m.refreshes,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// AddDBPool exposes the connection pool statistics of a database, pool names the database in the labels
+func (m *Metrics) AddDBPool(pool string, stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(newDBStatsCollector(pool, stats))
+}
+
+// ObserveRPC records a completed rpc, method is the full method name
+func (m *Metrics) ObserveRPC(method, code string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.rpcDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+	m.rpcHandled.WithLabelValues(method, code).Inc()
+}
+
+// Registration counts a registered user
+func (m *Metrics) Registration() {
+	if m == nil {
+		return
+	}
+
+	m.registrations.Inc()
+}
+
+// Login counts a login, reason is ReasonNone unless it failed
+func (m *Metrics) Login(result, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.logins.WithLabelValues(result, reason).Inc()
+}
+
+// TokenRefresh counts a token refresh, reason is ReasonNone unless it failed
+func (m *Metrics) TokenRefresh(result, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.refreshes.WithLabelValues(result, reason).Inc()
+}
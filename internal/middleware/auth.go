@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"strings"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxAdminIDLength is the size of the updated_by column an admin id is written to
+const maxAdminIDLength = 100
+
+// Auth enforces a valid access token on every rpc except the public ones
+// Admin rpc are called by support staff with the admin key instead of an access token,
+// the rpc shared by users & staff take the admin key when x-admin-key is sent and an access token otherwise
+type Auth struct {
+	conf   *infra.AppService
+	log    *logrus.Logger
+	public map[string]bool
+	admin  map[string]bool
+	shared map[string]bool
+}
+
+// NewAuth creates the authentication interceptors, publicMethods, adminMethods & sharedMethods are full method names
+func NewAuth(conf *infra.AppService, logger *logrus.Logger, publicMethods, adminMethods, sharedMethods []string) *Auth {
+	return &Auth{
+		conf:   conf,
+		log:    logger,
+		public: methodSet(publicMethods),
+		admin:  methodSet(adminMethods),
+		shared: methodSet(sharedMethods),
+	}
+}
+
+func methodSet(methods []string) map[string]bool {
+	set := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		set[method] = true
+	}
+
+	return set
+}
+
+// UnaryInterceptor returns the grpc unary interceptor checking the Authorization metadata
+func (a *Auth) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor returns the grpc stream interceptor checking the Authorization metadata
+func (a *Auth) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate validates the access token and puts the session credential on the context
+func (a *Auth) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if a.public[fullMethod] {
+		return ctx, nil
+	}
+
+	if a.admin[fullMethod] || (a.shared[fullMethod] && utils.GetMetadata(ctx, general.APIHeaderAdminKey) != "") {
+		return a.authenticateAdmin(ctx, fullMethod)
+	}
+
+	token := utils.GetBearerToken(ctx)
+	if token == "" {
+		return ctx, status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	claims, err := infra.CheckAccessToken(ctx, token)
+	if err != nil {
+		a.log.WithContext(ctx).WithField("method", fullMethod).WithError(err).Errorf("Auth | Failed to check access token")
+		return ctx, status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	sessionID, _ := claims["jti"].(string)
+	session, _ := claims["session"].(string)
+	if sessionID == "" || session == "" {
+		return ctx, status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	payload, err := utils.GetDecrypt([]byte(a.conf.KeyData.User), session)
+	if err != nil {
+		a.log.WithContext(ctx).WithField("method", fullMethod).WithError(err).Errorf("Auth | Failed to decrypt session")
+		return ctx, status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	var credential users.CredentialData
+	err = json.Unmarshal([]byte(payload), &credential)
+	if err != nil || credential.GetId() == 0 {
+		a.log.WithContext(ctx).WithField("method", fullMethod).WithError(err).Errorf("Auth | Failed to read session")
+		return ctx, status.Error(codes.Unauthenticated, general.HandlerErrorAuthInvalid)
+	}
+
+	ctx = context.WithValue(ctx, general.SessionContextKey, &credential)
+	ctx = context.WithValue(ctx, general.SessionIDContextKey, sessionID)
+
+	return ctx, nil
+}
+
+// authenticateAdmin checks the x-admin-key metadata against the configured admin key
+// The x-admin-id metadata names the operator behind the shared key, it is recorded by the rpc changing an user
+func (a *Auth) authenticateAdmin(ctx context.Context, fullMethod string) (context.Context, error) {
+	secretKey := a.conf.Authorization.Admin.SecretKey
+	adminKey := utils.GetMetadata(ctx, general.APIHeaderAdminKey)
+
+	if secretKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(secretKey)) != 1 {
+		a.log.WithContext(ctx).WithField("method", fullMethod).Errorf("Auth | Failed to check admin key")
+		return ctx, status.Error(codes.PermissionDenied, general.HandlerErrorAuthInvalid)
+	}
+
+	ctx = context.WithValue(ctx, general.AdminContextKey, true)
+
+	adminID := strings.TrimSpace(utils.GetMetadata(ctx, general.APIHeaderAdminID))
+	if len(adminID) > maxAdminIDLength {
+		a.log.WithContext(ctx).WithField("method", fullMethod).Errorf("Auth | Failed to check admin id, longer than %d", maxAdminIDLength)
+		return ctx, status.Errorf(codes.InvalidArgument, "%s is longer than %d characters", general.APIHeaderAdminID, maxAdminIDLength)
+	}
+	if adminID != "" {
+		ctx = context.WithValue(ctx, general.AdminIDContextKey, adminID)
+	}
+
+	return ctx, nil
+}
+
+// IsAdmin returns true when the rpc was authenticated with the admin key
+func IsAdmin(ctx context.Context) bool {
+	isAdmin, _ := ctx.Value(general.AdminContextKey).(bool)
+	return isAdmin
+}
+
+// GetAdminID returns the operator named by x-admin-id on an admin rpc, empty when none was sent
+func GetAdminID(ctx context.Context) string {
+	adminID, _ := ctx.Value(general.AdminIDContextKey).(string)
+	return adminID
+}
+
+// GetCredential returns the credential of the caller put on the context by the Auth interceptor
+func GetCredential(ctx context.Context) (*users.CredentialData, string, bool) {
+	credential, ok := ctx.Value(general.SessionContextKey).(*users.CredentialData)
+	if !ok || credential == nil {
+		return nil, "", false
+	}
+
+	sessionID, _ := ctx.Value(general.SessionIDContextKey).(string)
+
+	return credential, sessionID, true
+}
+
+// serverStream overrides the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
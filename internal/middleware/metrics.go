@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics records the latency and the status code of every rpc
+// It must run before Auth & RateLimit, the rpc they refuse are recorded too
+type Metrics struct {
+	metrics *metrics.Metrics
+}
+
+// NewMetrics creates the metrics interceptors
+func NewMetrics(m *metrics.Metrics) *Metrics {
+	return &Metrics{metrics: m}
+}
+
+// UnaryInterceptor returns the grpc unary interceptor recording every rpc
+func (m *Metrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), time.Since(start))
+
+		return resp, err
+	}
+}
+
+// StreamInterceptor returns the grpc stream interceptor recording every stream once it ends
+func (m *Metrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), time.Since(start))
+
+		return err
+	}
+}
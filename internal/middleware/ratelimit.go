@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/ratelimit"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Rate limit response headers, forwarded as is by the gateway
+const (
+	HeaderRateLimitLimit     = "x-ratelimit-limit"
+	HeaderRateLimitRemaining = "x-ratelimit-remaining"
+	HeaderRateLimitReset     = "x-ratelimit-reset"
+	HeaderRetryAfter         = "retry-after"
+)
+
+// RateLimit refuses the rpc over their token bucket with ResourceExhausted
+// UnaryInterceptor & StreamInterceptor run before Auth, every call takes a token from its ip or api key bucket
+// so failed authentications are limited too. UserUnaryInterceptor & UserStreamInterceptor run after Auth,
+// the rules keyed by user take a token from the bucket of the credential when there is one
+type RateLimit struct {
+	limiter ratelimit.Limiter
+	log     *logrus.Logger
+	rules   atomic.Pointer[rateLimitRules]
+}
+
+// rateLimitRules are swapped as a whole when the config is reloaded
+type rateLimitRules struct {
+	enabled     bool
+	methods     map[string]infra.RateLimitRule
+	defaultRule infra.RateLimitRule
+}
+
+// NewRateLimit creates the rate limit interceptors, the rules of conf.Methods are matched by rpc name ignoring the case
+func NewRateLimit(conf infra.RateLimitConfig, limiter ratelimit.Limiter, logger *logrus.Logger) *RateLimit {
+	r := &RateLimit{
+		limiter: limiter,
+		log:     logger,
+	}
+	r.Update(conf)
+
+	return r
+}
+
+// Update replaces the rules from the next rpc on, a bucket whose capacity changed starts full again
+func (r *RateLimit) Update(conf infra.RateLimitConfig) {
+	methods := make(map[string]infra.RateLimitRule, len(conf.Methods))
+	for name, rule := range conf.Methods {
+		methods[strings.ToLower(name)] = rule
+	}
+
+	r.rules.Store(&rateLimitRules{
+		enabled:     conf.Enabled,
+		methods:     methods,
+		defaultRule: conf.Default,
+	})
+}
+
+// UnaryInterceptor returns the grpc unary interceptor taking a token from the ip or api key bucket of every rpc
+func (r *RateLimit) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, header, err := r.takeCaller(ctx, info.FullMethod)
+		if err != nil {
+			grpc.SetHeader(ctx, header)
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor returns the grpc stream interceptor taking a token from the ip or api key bucket of every stream
+func (r *RateLimit) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, header, err := r.takeCaller(ss.Context(), info.FullMethod)
+		if err != nil {
+			ss.SetHeader(header)
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UserUnaryInterceptor returns the grpc unary interceptor taking a token from the user bucket of the authenticated rpc
+func (r *RateLimit) UserUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		header, err := r.takeUser(ctx, info.FullMethod)
+		if header != nil {
+			grpc.SetHeader(ctx, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// UserStreamInterceptor returns the grpc stream interceptor taking a token from the user bucket of the authenticated stream
+func (r *RateLimit) UserStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		header, err := r.takeUser(ss.Context(), info.FullMethod)
+		if header != nil {
+			ss.SetHeader(header)
+		}
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// takeCaller takes a token from the ip or api key bucket of the caller,
+// the headers are put on the returned context for takeUser to send once the bucket of the rpc is known
+func (r *RateLimit) takeCaller(ctx context.Context, fullMethod string) (context.Context, metadata.MD, error) {
+	name, rule, ok := r.rule(fullMethod)
+	if !ok {
+		return ctx, nil, nil
+	}
+
+	header, err := r.take(ctx, fullMethod, name+":"+r.subject(ctx, rule.Key), rule)
+	if err != nil {
+		return ctx, header, err
+	}
+
+	return context.WithValue(ctx, general.RateLimitHeaderContextKey, header), header, nil
+}
+
+// takeUser takes a token from the user bucket when the rule is keyed by user and the caller has a credential,
+// it returns the headers of that bucket, or the headers of takeCaller otherwise
+func (r *RateLimit) takeUser(ctx context.Context, fullMethod string) (metadata.MD, error) {
+	callerHeader, _ := ctx.Value(general.RateLimitHeaderContextKey).(metadata.MD)
+
+	name, rule, ok := r.rule(fullMethod)
+	if !ok || rule.Key != infra.RateLimitKeyUser {
+		return callerHeader, nil
+	}
+
+	credential, _, ok := GetCredential(ctx)
+	if !ok {
+		return callerHeader, nil
+	}
+
+	header, err := r.take(ctx, fullMethod, name+":user:"+strconv.FormatUint(credential.GetId(), 10), rule)
+	if header == nil {
+		header = callerHeader
+	}
+
+	return header, err
+}
+
+// rule returns the lower case rpc name and its rule, false when the rpc is not limited
+func (r *RateLimit) rule(fullMethod string) (string, infra.RateLimitRule, bool) {
+	rules := r.rules.Load()
+	if !rules.enabled {
+		return "", infra.RateLimitRule{}, false
+	}
+
+	name := strings.ToLower(fullMethod[strings.LastIndex(fullMethod, "/")+1:])
+
+	rule, ok := rules.methods[name]
+	if !ok {
+		rule = rules.defaultRule
+	}
+
+	return name, rule, rule.Limit > 0
+}
+
+// take takes a token from the bucket of key and returns the rate limit headers
+// The rpc is allowed when the limiter fails, an outage must not refuse every request
+func (r *RateLimit) take(ctx context.Context, fullMethod, key string, rule infra.RateLimitRule) (metadata.MD, error) {
+	period := time.Duration(rule.Period) * time.Second
+	if period <= 0 {
+		period = time.Second
+	}
+
+	result, err := r.limiter.Allow(ctx, key, ratelimit.Rule{Limit: rule.Limit, Period: period, Burst: rule.Burst})
+	if err != nil {
+		r.log.WithContext(ctx).WithField("method", fullMethod).WithError(err).Errorf("RateLimit | Failed to take token")
+		return nil, nil
+	}
+
+	header := metadata.Pairs(
+		HeaderRateLimitLimit, strconv.Itoa(result.Limit),
+		HeaderRateLimitRemaining, strconv.Itoa(result.Remaining),
+		HeaderRateLimitReset, ceilSeconds(result.Reset),
+	)
+
+	if !result.Allowed {
+		header.Set(HeaderRetryAfter, ceilSeconds(result.RetryAfter))
+		r.log.WithContext(ctx).WithField("method", fullMethod).WithField("key", key).Errorf("RateLimit | Rate limit exceeded")
+		return header, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	return header, nil
+}
+
+// subject returns who the bucket of takeCaller belongs to, the x-api-key for the rules keyed by api key, the client ip otherwise
+// The ip comes from X-Forwarded-For only through APP.TRUSTED_PROXIES, a caller cannot pick a fresh bucket with a forged header
+func (r *RateLimit) subject(ctx context.Context, key string) string {
+	if key == infra.RateLimitKeyAPIKey {
+		if apiKey := utils.GetMetadata(ctx, general.APIHeaderAPIKey); apiKey != "" {
+			// the key itself is a secret, it must not end up in redis
+			return "api_key:" + utils.Hash256(apiKey)
+		}
+	}
+
+	return "ip:" + utils.GetClientIP(ctx)
+}
+
+// ceilSeconds formats a duration as whole seconds rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
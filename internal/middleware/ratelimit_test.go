@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/ratelimit"
+	"github.com/febriandani/backend-user-service/internal/utils/constant/general"
+	"github.com/febriandani/backend-user-service/protogen/golang/users"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// chain runs the interceptors in the order of the server around an empty handler
+func chain(interceptors ...grpc.UnaryServerInterceptor) func(ctx context.Context, method string) error {
+	return func(ctx context.Context, method string) error {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, next)
+			}
+		}
+
+		_, err := handler(ctx, nil)
+		return err
+	}
+}
+
+// callerContext returns the context of a call from ip with the given metadata
+func callerContext(ip string, kv ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+}
+
+func newRateLimitTest(rule infra.RateLimitRule) *RateLimit {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return NewRateLimit(infra.RateLimitConfig{Enabled: true, Default: rule}, ratelimit.NewLocalLimiter(), logger)
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	conf := &infra.AppService{}
+	conf.Authorization.Admin.SecretKey = "admin-secret-key"
+	auth := NewAuth(conf, logger, nil, []string{"/Users/UnlockUser"}, nil)
+
+	r := newRateLimitTest(infra.RateLimitRule{Limit: 2, Period: 3600, Key: infra.RateLimitKeyUser})
+	call := chain(r.UnaryInterceptor(), auth.UnaryInterceptor(), r.UserUnaryInterceptor())
+
+	// wrong admin key guesses are refused by Auth, then by the ip bucket once it is empty
+	wantCodes := []codes.Code{codes.PermissionDenied, codes.PermissionDenied, codes.ResourceExhausted}
+	for i, want := range wantCodes {
+		err := call(callerContext("203.0.113.7", general.APIHeaderAdminKey, "guess"), "/Users/UnlockUser")
+		if status.Code(err) != want {
+			t.Errorf("guess #%d error = %v, want %v", i+1, err, want)
+		}
+	}
+
+	// another client keeps its own bucket
+	err := call(callerContext("203.0.113.8", general.APIHeaderAdminKey, "guess"), "/Users/UnlockUser")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("guess of another ip error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestRateLimitUserBucket(t *testing.T) {
+	r := newRateLimitTest(infra.RateLimitRule{Limit: 2, Period: 3600, Key: infra.RateLimitKeyUser})
+
+	// Auth is replaced by the credential it puts on the context
+	authenticated := func(userID uint64) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(context.WithValue(ctx, general.SessionContextKey, &users.CredentialData{Id: userID}), req)
+		}
+	}
+
+	alice := chain(r.UnaryInterceptor(), authenticated(1), r.UserUnaryInterceptor())
+	bob := chain(r.UnaryInterceptor(), authenticated(2), r.UserUnaryInterceptor())
+
+	// alice empties her user bucket from one ip, she is still limited from another
+	for i := 0; i < 2; i++ {
+		if err := alice(callerContext("203.0.113.1"), "/Users/GetUser"); err != nil {
+			t.Fatalf("call #%d error = %v", i+1, err)
+		}
+	}
+	if err := alice(callerContext("203.0.113.2"), "/Users/GetUser"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call from another ip error = %v, want ResourceExhausted of the user bucket", err)
+	}
+
+	// bob has his own user bucket
+	if err := bob(callerContext("203.0.113.3"), "/Users/GetUser"); err != nil {
+		t.Errorf("call of another user error = %v", err)
+	}
+}
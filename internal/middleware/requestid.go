@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/febriandani/backend-user-service/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// maxRequestIDLength bounds the correlation id accepted from the caller, a longer one is replaced
+const maxRequestIDLength = 128
+
+// RequestID puts the correlation id of the caller on the context of every rpc, or a new one when the caller sent none
+// The id is returned in the x-request-id header and carried by the lines logged with WithContext
+type RequestID struct{}
+
+// NewRequestID creates the request id interceptors
+func NewRequestID() *RequestID {
+	return &RequestID{}
+}
+
+// UnaryInterceptor returns the grpc unary interceptor setting the request id
+func (r *RequestID) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := requestIDFrom(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(infra.RequestIDKey, requestID))
+
+		return handler(infra.WithRequestID(ctx, requestID), req)
+	}
+}
+
+// StreamInterceptor returns the grpc stream interceptor setting the request id
+func (r *RequestID) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		requestID := requestIDFrom(ss.Context())
+		ss.SetHeader(metadata.Pairs(infra.RequestIDKey, requestID))
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: infra.WithRequestID(ss.Context(), requestID)})
+	}
+}
+
+// requestIDFrom returns the x-request-id metadata of the caller, or a new id when it is missing or too long
+func requestIDFrom(ctx context.Context) string {
+	requestID := utils.GetMetadata(ctx, infra.RequestIDKey)
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return utils.GenerateTokenID()
+	}
+
+	return requestID
+}
@@ -0,0 +1,356 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDir is where the create command writes new migrations, relative to the repository root
+const DefaultDir = "internal/migrate/migrations"
+
+// lockKey is the pg_advisory_lock key held while migrating, so two instances never migrate at once
+const lockKey int64 = 7120534180
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change with its up & down sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration with the time it was applied, AppliedAt is nil when it is pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the embedded migrations and records them in public.schema_migrations
+type Migrator struct {
+	db         infra.Database
+	log        *logrus.Logger
+	migrations []Migration
+}
+
+// New returns a migrator for the migrations embedded in the binary
+func New(db infra.Database, log *logrus.Logger) (*Migrator, error) {
+	migrations, err := load(embedded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		log:        log,
+		migrations: migrations,
+	}, nil
+}
+
+// load reads the migrations of the fs, every version needs both an up & a down file
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		base := filepath.Base(file)
+		match := fileNamePattern.FindStringSubmatch(base)
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 000001_create_table.up.sql", base)
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d: up & down files have different names", version)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d: up & down files are both required", migration.Version)
+		}
+
+		result = append(result, *migration)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
+
+// Up applies the pending migrations in order, all of them when steps <= 0
+// Returns the number of applied migrations
+func (m *Migrator) Up(ctx context.Context, steps int) (int, error) {
+	applied := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if steps > 0 && applied >= steps {
+				break
+			}
+
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			m.log.WithField("version", migration.Version).Infof("Migrate | Applying %s", migration.Name)
+
+			err = run(ctx, conn, migration.Up, `INSERT INTO public.schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`, migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the latest applied migrations, one when steps <= 0
+// Returns the number of reverted migrations
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	reverted := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			m.log.WithField("version", migration.Version).Infof("Migrate | Reverting %s", migration.Name)
+
+			err = run(ctx, conn, migration.Down, `DELETE FROM public.schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns every known migration with the time it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var result []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		result = make([]Status, 0, len(m.migrations))
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+
+			result = append(result, status)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// Pending returns the migrations that are not applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Migration, 0)
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			result = append(result, status.Migration)
+		}
+	}
+
+	return result, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+		if err != nil {
+			m.log.WithError(err).Errorf("Migrate | Failed to release advisory lock")
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns the applied versions with their applied time
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM public.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result[version] = appliedAt
+	}
+
+	return result, rows.Err()
+}
+
+// run executes the migration sql & the bookkeeping statement in one transaction
+func run(ctx context.Context, conn *sql.Conn, migration, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create writes an empty up & down migration in dir, numbered after the latest one
+// Returns the paths of the created files
+func Create(dir, name string) (string, string, error) {
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return "", "", fmt.Errorf("migration name must only contain lowercase letters, digits and underscores")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	var latest int64
+	for _, file := range files {
+		match := fileNamePattern.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		if version > latest {
+			latest = version
+		}
+	}
+
+	prefix := filepath.Join(dir, fmt.Sprintf("%06d_%s", latest+1, name))
+	up, down := prefix+".up.sql", prefix+".down.sql"
+
+	err = os.WriteFile(up, []byte("-- "+name+" up\n"), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.WriteFile(down, []byte("-- "+name+" down\n"), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+
+	return up, down, nil
+}
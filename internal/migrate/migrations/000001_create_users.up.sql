@@ -0,0 +1,31 @@
+-- the table the service always used, deployments created it before the migrations existed
+CREATE TABLE IF NOT EXISTS public.users (
+    user_id    BIGSERIAL PRIMARY KEY,
+    username   VARCHAR(100) NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    password   VARCHAR(255) NOT NULL,
+    is_active  BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_by VARCHAR(100) NOT NULL DEFAULT '',
+    updated_by VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+-- optimistic locking & soft delete, added to the existing tables as well as the new ones
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- usernames & emails are only unique among users that are not soft deleted,
+-- a UNIQUE constraint of an existing table would keep the name of a deleted user taken
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON public.users (username) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON public.users (email) WHERE deleted_at IS NULL;
+
+-- ListUsers prefix search & sorting
+CREATE INDEX IF NOT EXISTS users_lower_username_idx ON public.users (lower(username) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_lower_email_idx ON public.users (lower(email) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON public.users (created_at, user_id);
+
+-- retention purge of soft deleted users
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON public.users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.user_sessions (
+    session_id   VARCHAR(64) PRIMARY KEY,
+    user_id      BIGINT NOT NULL,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip_address   VARCHAR(64) NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON public.user_sessions (user_id) WHERE revoked_at IS NULL;
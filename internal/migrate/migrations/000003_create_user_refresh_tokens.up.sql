@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.user_refresh_tokens (
+    token_id   VARCHAR(64) PRIMARY KEY,
+    family     VARCHAR(64) NOT NULL,
+    user_id    BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_refresh_tokens_family_idx ON public.user_refresh_tokens (family);
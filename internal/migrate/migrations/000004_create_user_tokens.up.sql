@@ -0,0 +1,11 @@
+-- single-use tokens sent to users (password reset, ...), only the sha256 of the token is stored
+CREATE TABLE IF NOT EXISTS public.user_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    purpose    VARCHAR(32) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_tokens_user_id_purpose_idx ON public.user_tokens (user_id, purpose) WHERE used_at IS NULL;
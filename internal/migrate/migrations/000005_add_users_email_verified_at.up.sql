@@ -0,0 +1,4 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- users registered before email verification existed are trusted
+UPDATE public.users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
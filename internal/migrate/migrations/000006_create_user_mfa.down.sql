@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.user_recovery_codes;
+DROP TABLE IF EXISTS public.user_totp;
@@ -0,0 +1,17 @@
+-- authenticator app of a user, the secret is encrypted with KEY.USER and enabled once a first code is confirmed
+CREATE TABLE IF NOT EXISTS public.user_totp (
+    user_id      BIGINT PRIMARY KEY,
+    secret       TEXT NOT NULL,
+    last_counter BIGINT NOT NULL DEFAULT 0,
+    enabled_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- single-use recovery codes replacing a TOTP code, only the sha256 of the code is stored
+CREATE TABLE IF NOT EXISTS public.user_recovery_codes (
+    user_id    BIGINT NOT NULL,
+    code_hash  VARCHAR(64) NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,9 @@
+-- failed logins per account (scope user, subject user_id) and per client ip (scope ip)
+CREATE TABLE IF NOT EXISTS public.login_attempts (
+    scope          VARCHAR(16) NOT NULL,
+    subject        VARCHAR(64) NOT NULL,
+    failures       INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ NOT NULL,
+    locked_until   TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,15 @@
+-- domain events written in the transaction of the change they describe, published by the outbox relay
+CREATE TABLE IF NOT EXISTS public.outbox_events (
+    id           BIGSERIAL PRIMARY KEY,
+    event_id     VARCHAR(32) NOT NULL UNIQUE,
+    event_type   VARCHAR(64) NOT NULL,
+    user_id      BIGINT NOT NULL,
+    payload      JSONB NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    attempts     INT NOT NULL DEFAULT 0,
+    last_error   TEXT,
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON public.outbox_events (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON public.outbox_events (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.idx_outbox_events_failed_at;
+DROP INDEX IF EXISTS public.idx_outbox_events_pending;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON public.outbox_events (id) WHERE published_at IS NULL;
+ALTER TABLE public.outbox_events DROP COLUMN IF EXISTS failed_at;
@@ -0,0 +1,7 @@
+-- events refused by the broker EVENTS.MAX_ATTEMPTS times are dead-lettered, kept for inspection and never claimed again
+ALTER TABLE public.outbox_events ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+-- the relay claims the pending events user by user, in their write order
+DROP INDEX IF EXISTS public.idx_outbox_events_pending;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON public.outbox_events (user_id, id) WHERE published_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_failed_at ON public.outbox_events (failed_at) WHERE failed_at IS NOT NULL;
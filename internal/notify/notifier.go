@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/febriandani/backend-user-service/internal/infra"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier drivers
+const (
+	DriverLog  = "log"
+	DriverFile = "file"
+)
+
+// Message is a notification sent to a user
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users, e.g. by email
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the notifier selected by NOTIFY.DRIVER, the log notifier by default
+func New(conf infra.NotifyConfig, logger *logrus.Logger) (Notifier, error) {
+	switch conf.Driver {
+	case "", DriverLog:
+		return NewLogNotifier(logger), nil
+	case DriverFile:
+		return NewFileNotifier(conf.FilePath)
+	}
+
+	return nil, fmt.Errorf("unknown notifier driver %s", conf.Driver)
+}
+
+// LogNotifier writes messages to the service log, for local development only
+type LogNotifier struct {
+	log *logrus.Logger
+}
+
+// NewLogNotifier creates a notifier writing to the service log
+func NewLogNotifier(logger *logrus.Logger) *LogNotifier {
+	return &LogNotifier{log: logger}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	n.log.WithField("to", msg.To).WithField("subject", msg.Subject).Infof("Notify | %s", msg.Body)
+	return nil
+}
+
+// FileNotifier appends messages as JSON lines to a file, for local development only
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileNotifier creates a notifier appending to path, the directory is created when missing
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if path == "" {
+		return nil, fmt.Errorf("notify file path cannot be empty")
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileNotifier{path: path}, nil
+}
+
+func (n *FileNotifier) Send(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(struct {
+		Message
+		SentAt time.Time `json:"sent_at"`
+	}{msg, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+
+	return err
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Rule is a token bucket refilled with Limit tokens every Period and holding at most Burst tokens
+type Rule struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// rate returns the tokens added per second
+func (r Rule) rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// capacity returns the size of the bucket, Limit when Burst is not set
+func (r Rule) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+
+	return float64(r.Limit)
+}
+
+// Result is the state of a bucket after taking a token
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // wait before the next token when the request is refused
+	Reset      time.Duration // wait before the bucket is full again
+}
+
+// Limiter takes one token from the bucket of key
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (Result, error)
+}
+
+var (
+	_ Limiter = (*LocalLimiter)(nil)
+	_ Limiter = (*RedisLimiter)(nil)
+)
+
+// newResult computes the result from the tokens left in the bucket
+func newResult(allowed bool, tokens float64, rule Rule) Result {
+	rate := rule.rate()
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(rule.capacity()),
+		Remaining: int(tokens),
+		Reset:     time.Duration((rule.capacity() - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const localSweepInterval = time.Minute
+
+// LocalLimiter keeps the buckets in process, each instance of the service limits on its own
+type LocalLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	fullAt   time.Time
+	capacity float64
+}
+
+// NewLocalLimiter creates an empty in-process limiter
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *LocalLimiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	capacity := rule.capacity()
+
+	b, ok := l.buckets[key]
+	if !ok || b.capacity != capacity {
+		b = &bucket{tokens: capacity, updated: now, capacity: capacity}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rule.rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	result := newResult(allowed, b.tokens, rule)
+	b.fullAt = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// sweep removes the buckets full again, they are the same as a missing bucket, l.mu must be held
+func (l *LocalLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < localSweepInterval {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if !b.fullAt.After(now) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
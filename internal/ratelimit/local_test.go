@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalLimiterDeniesEmptyBucket(t *testing.T) {
+	l := NewLocalLimiter()
+	rule := Rule{Limit: 1, Period: time.Hour, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		result, err := l.Allow(context.Background(), "ip:10.0.0.1", rule)
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Errorf("Allow() #%d = %+v, want allowed with %d remaining of 3", i+1, result, 2-i)
+		}
+	}
+
+	result, _ := l.Allow(context.Background(), "ip:10.0.0.1", rule)
+	if result.Allowed || result.Remaining != 0 {
+		t.Errorf("Allow() on an empty bucket = %+v, want denied", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Hour {
+		t.Errorf("Allow() retry after = %v, want up to the period", result.RetryAfter)
+	}
+
+	// the buckets are per key
+	if result, _ := l.Allow(context.Background(), "ip:10.0.0.2", rule); !result.Allowed {
+		t.Errorf("Allow() of another key = %+v, want allowed", result)
+	}
+}
+
+func TestLocalLimiterRefills(t *testing.T) {
+	l := NewLocalLimiter()
+	rule := Rule{Limit: 1, Period: 50 * time.Millisecond}
+
+	if result, _ := l.Allow(context.Background(), "user:1", rule); !result.Allowed {
+		t.Fatalf("Allow() = %+v, want allowed", result)
+	}
+	if result, _ := l.Allow(context.Background(), "user:1", rule); result.Allowed {
+		t.Fatalf("Allow() = %+v, want denied before the refill", result)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if result, _ := l.Allow(context.Background(), "user:1", rule); !result.Allowed {
+		t.Errorf("Allow() after a period = %+v, want allowed", result)
+	}
+}
+
+func TestLocalLimiterCapacityChange(t *testing.T) {
+	l := NewLocalLimiter()
+
+	l.Allow(context.Background(), "user:1", Rule{Limit: 1, Period: time.Hour})
+
+	// a reloaded rule with another capacity starts a full bucket
+	result, _ := l.Allow(context.Background(), "user:1", Rule{Limit: 5, Period: time.Hour})
+	if !result.Allowed || result.Remaining != 4 {
+		t.Errorf("Allow() after a capacity change = %+v, want allowed with 4 remaining", result)
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// tokenBucket takes a token from the bucket KEYS[1], timed by the redis clock so every instance shares the same time
+// ARGV: tokens per millisecond, capacity, ttl of an idle bucket in milliseconds
+// Returns {1 when allowed else 0, tokens left}
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+  tokens = capacity
+  updated = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter shares the buckets between the instances of the service through redis
+// The fallback limiter is used while redis cannot be reached
+type RedisLimiter struct {
+	client   *redis.Client
+	fallback Limiter
+	log      *logrus.Logger
+}
+
+// NewRedisLimiter creates a limiter storing the buckets in redis under the ratelimit: prefix
+func NewRedisLimiter(client *redis.Client, fallback Limiter, logger *logrus.Logger) *RedisLimiter {
+	return &RedisLimiter{
+		client:   client,
+		fallback: fallback,
+		log:      logger,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	rate := rule.rate() / 1000
+	capacity := rule.capacity()
+	// an idle bucket is full again after this long and can be dropped
+	ttl := int64(capacity/rate) + 1000
+
+	values, err := tokenBucket.Run(ctx, l.client, []string{"ratelimit:" + key},
+		strconv.FormatFloat(rate, 'f', -1, 64), strconv.FormatFloat(capacity, 'f', -1, 64), ttl).Slice()
+	if err == nil && len(values) != 2 {
+		err = fmt.Errorf("unexpected token bucket reply %v", values)
+	}
+	if err != nil {
+		l.log.WithField("key", key).WithError(err).Errorf("RateLimit | Failed to take token from redis, using local limiter")
+		return l.fallback.Allow(ctx, key, rule)
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensLeft, _ := values[1].(string)
+
+	tokens, err := strconv.ParseFloat(tokensLeft, 64)
+	if err != nil {
+		l.log.WithField("key", key).WithError(err).Errorf("RateLimit | Failed to read token bucket from redis, using local limiter")
+		return l.fallback.Allow(ctx, key, rule)
+	}
+
+	return newResult(allowed == 1, tokens, rule), nil
+}
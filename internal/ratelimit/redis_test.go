@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// newRedisTest returns a redis limiter on miniredis, the clock of miniredis is fixed and moved with SetTime
+func newRedisTest(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return NewRedisLimiter(client, NewLocalLimiter(), logger), mr
+}
+
+func TestRedisLimiter(t *testing.T) {
+	l, mr := newRedisTest(t)
+	rule := Rule{Limit: 2, Period: time.Second, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		result, err := l.Allow(context.Background(), "ip:10.0.0.1", rule)
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		if !result.Allowed || result.Remaining != 1-i {
+			t.Errorf("Allow() #%d = %+v, want allowed with %d remaining", i+1, result, 1-i)
+		}
+	}
+
+	result, _ := l.Allow(context.Background(), "ip:10.0.0.1", rule)
+	if result.Allowed {
+		t.Errorf("Allow() on an empty bucket = %+v, want denied", result)
+	}
+	if result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Allow() retry after = %v, want 500ms for 2 tokens a second", result.RetryAfter)
+	}
+
+	if !mr.Exists("ratelimit:ip:10.0.0.1") {
+		t.Fatalf("bucket not stored under the ratelimit: prefix")
+	}
+	if ttl := mr.TTL("ratelimit:ip:10.0.0.1"); ttl <= 0 || ttl > 2*time.Second {
+		t.Errorf("bucket ttl = %v, want the time to refill plus a second", ttl)
+	}
+
+	// the script reads the redis clock, half a second brings one token back
+	mr.SetTime(time.Date(2024, 1, 1, 0, 0, 0, int(500*time.Millisecond), time.UTC))
+
+	result, _ = l.Allow(context.Background(), "ip:10.0.0.1", rule)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Allow() after a refill = %+v, want allowed with 0 remaining", result)
+	}
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	l, mr := newRedisTest(t)
+	rule := Rule{Limit: 1, Period: time.Hour}
+	mr.Close()
+
+	// redis is down, the local limiter takes over and still limits
+	result, err := l.Allow(context.Background(), "ip:10.0.0.1", rule)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Allow() with redis down = %+v, %v, want allowed by the local limiter", result, err)
+	}
+
+	result, _ = l.Allow(context.Background(), "ip:10.0.0.1", rule)
+	if result.Allowed {
+		t.Errorf("Allow() with redis down = %+v, want denied by the local limiter", result)
+	}
+}
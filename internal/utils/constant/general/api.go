@@ -5,6 +5,9 @@ const (
 	APIHeaderBorzoToken    string = "X-DV-Auth-Token"
 	APIHeaderJetClientKey  string = "clientkey"
 	APIHeaderAuthorization string = "Authorization"
+	APIHeaderAdminKey      string = "x-admin-key"
+	APIHeaderAdminID       string = "x-admin-id"
+	APIHeaderAPIKey        string = "x-api-key"
 )
 
 const (
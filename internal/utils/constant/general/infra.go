@@ -39,5 +39,9 @@ const (
 )
 
 const (
-	SessionContextKey = "session"
+	SessionContextKey         = "session"
+	SessionIDContextKey       = "session_id"
+	AdminContextKey           = "admin"
+	AdminIDContextKey         = "admin_id"
+	RateLimitHeaderContextKey = "ratelimit_header"
 )
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"strconv"
@@ -11,9 +13,13 @@ import (
 
 	mr "math/rand"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var tracer = otel.Tracer("github.com/febriandani/backend-user-service/internal/utils")
+
 func BasicAuth(username, password string) string {
 	auth := username + ":" + password
 	return base64.StdEncoding.EncodeToString([]byte(auth))
@@ -24,16 +30,26 @@ func Hash256(data string) string {
 	return fmt.Sprintf("%x", hash[:])
 }
 
-func GeneratePassword(password string) (string, error) {
+// GeneratePassword hashes a password with bcrypt, traced since it is the slowest step of a registration
+func GeneratePassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GeneratePassword")
+	defer span.End()
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return "", err
 	}
 
 	return string(hashedPassword), nil
 }
 
-func ComparePassword(savedPass, incomingPass string) (bool, error) {
+// ComparePassword checks a password against its bcrypt hash, traced since it is the slowest step of a login
+func ComparePassword(ctx context.Context, savedPass, incomingPass string) (bool, error) {
+	_, span := tracer.Start(ctx, "bcrypt.ComparePassword")
+	defer span.End()
+
 	err := bcrypt.CompareHashAndPassword([]byte(savedPass), []byte(incomingPass))
 	if err != nil {
 		return false, nil
@@ -89,3 +105,23 @@ func GenerateRandIntegerSixthLengthString() string {
 
 	return strconv.Itoa(randomInt)
 }
+
+// GenerateTokenID returns a random 128 bit identifier encoded as hex, used for jti and token families
+func GenerateTokenID() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// GenerateSecretToken returns a random 256 bit token encoded as base64url, sent to users & stored only as Hash256
+func GenerateSecretToken() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// GetMetadata returns the first value of an incoming grpc metadata key
+func GetMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// GetBearerToken returns the token of the Authorization metadata, with or without the Bearer prefix
+func GetBearerToken(ctx context.Context) string {
+	auth := strings.TrimSpace(GetMetadata(ctx, "authorization"))
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return auth
+}
+
+// trustedProxies are the networks whose X-Forwarded-For hops are believed, none until SetTrustedProxies
+var trustedProxies atomic.Pointer[[]*net.IPNet]
+
+// SetTrustedProxies sets the proxies allowed to name the client in X-Forwarded-For, by ip or CIDR, e.g. the gateway
+func SetTrustedProxies(proxies []string) error {
+	networks, err := ParseTrustedProxies(proxies)
+	if err != nil {
+		return err
+	}
+
+	trustedProxies.Store(&networks)
+
+	return nil
+}
+
+// ParseTrustedProxies parses ips & CIDRs, an ip is a network of its own
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is neither an ip nor a CIDR", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is neither an ip nor a CIDR", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// GetClientIP returns the caller ip, the grpc peer unless it is a trusted proxy
+// Behind trusted proxies it is the rightmost X-Forwarded-For hop not added by one of them,
+// the hops on its left are written by the caller and never believed
+func GetClientIP(ctx context.Context) string {
+	ip := peerIP(ctx)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var hops []string
+	for _, value := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// a proxy writes ips only, the last trusted hop is the best known client
+			break
+		}
+
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// peerIP returns the ip of the grpc connection
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// isTrustedProxy reports whether ip belongs to a trusted proxy
+func isTrustedProxy(ip string) bool {
+	networks := trustedProxies.Load()
+	parsed := net.ParseIP(ip)
+	if networks == nil || parsed == nil {
+		return false
+	}
+
+	for _, network := range *networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetUserAgent returns the caller user agent, the gateway forwards it as grpcgateway-user-agent
+func GetUserAgent(ctx context.Context) string {
+	userAgent := GetMetadata(ctx, "grpcgateway-user-agent")
+	if userAgent != "" {
+		return userAgent
+	}
+
+	return GetMetadata(ctx, "user-agent")
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238, the defaults of every authenticator app
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	// TOTPSkew is the number of time steps accepted before and after the current one
+	TOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160 bit TOTP secret encoded as base32 without padding
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	return totpEncoding.EncodeToString(b)
+}
+
+// TOTPCounter returns the RFC 6238 time step of t
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// GenerateTOTP returns the code of a base32 secret for a time step (RFC 4226 HOTP with HMAC-SHA1)
+func GenerateTOTP(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, code%mod), nil
+}
+
+// ValidateTOTP checks a code against the time steps around now and returns the matching time step
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool, error) {
+	if len(code) != TOTPDigits {
+		return 0, false, nil
+	}
+
+	current := TOTPCounter(now)
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		expected, err := GenerateTOTP(secret, counter)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// TOTPURI returns the otpauth:// URI of a secret, shown as a QR code to enroll an authenticator app
+func TOTPURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
+
+// GenerateRecoveryCode returns a random 50 bit recovery code formatted as xxxxx-xxxxx
+func GenerateRecoveryCode() string {
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+
+	return code[:5] + "-" + code[5:]
+}
+
+// NormalizeRecoveryCode removes the separators and the case of a recovery code typed by a user, the result is what is hashed
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
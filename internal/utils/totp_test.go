@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, the last 6 of the 8 digits of the RFC
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := GenerateTOTP(rfc6238Secret, TOTPCounter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("GenerateTOTP() at %d error = %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("GenerateTOTP() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateTOTPSecretEncodings(t *testing.T) {
+	// authenticator apps give the secret in lower case or padded
+	for _, secret := range []string{strings.ToLower(rfc6238Secret), rfc6238Secret + "===="} {
+		got, err := GenerateTOTP(secret, TOTPCounter(time.Unix(59, 0)))
+		if err != nil || got != "287082" {
+			t.Errorf("GenerateTOTP(%q) = %s, %v, want 287082", secret, got, err)
+		}
+	}
+
+	if _, err := GenerateTOTP("not base32!", 1); err == nil {
+		t.Errorf("GenerateTOTP() accepted a secret that is not base32")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPCounter(now)
+
+	tests := []struct {
+		name        string
+		counter     int64
+		wantValid   bool
+		wantCounter int64
+	}{
+		{name: "current step", counter: current, wantValid: true, wantCounter: current},
+		{name: "previous step", counter: current - 1, wantValid: true, wantCounter: current - 1},
+		{name: "next step", counter: current + 1, wantValid: true, wantCounter: current + 1},
+		{name: "two steps ago", counter: current - 2},
+		{name: "two steps ahead", counter: current + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := GenerateTOTP(rfc6238Secret, tt.counter)
+			if err != nil {
+				t.Fatalf("GenerateTOTP() error = %v", err)
+			}
+
+			counter, isValid, err := ValidateTOTP(rfc6238Secret, code, now)
+			if err != nil {
+				t.Fatalf("ValidateTOTP() error = %v", err)
+			}
+			if isValid != tt.wantValid || counter != tt.wantCounter {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", counter, isValid, tt.wantCounter, tt.wantValid)
+			}
+		})
+	}
+
+	for _, code := range []string{"", "05047", "0504710", "abcdef"} {
+		if _, isValid, _ := ValidateTOTP(rfc6238Secret, code, now); isValid {
+			t.Errorf("ValidateTOTP(%q) is valid", code)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("backend-user-service", "alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("TOTPURI() does not parse: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/backend-user-service:alice@example.com" {
+		t.Errorf("TOTPURI() = %s, want otpauth://totp/issuer:account", uri)
+	}
+
+	query := uri.Query()
+	if query.Get("secret") != rfc6238Secret || query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("TOTPURI() parameters = %v", query)
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code := GenerateRecoveryCode()
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("GenerateRecoveryCode() = %q, want xxxxx-xxxxx", code)
+	}
+	if GenerateRecoveryCode() == code {
+		t.Errorf("GenerateRecoveryCode() returned the same code twice")
+	}
+
+	// a code typed in upper case or without the separator is the same code
+	normalized := NormalizeRecoveryCode(code)
+	for _, typed := range []string{strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), code[:5] + " " + code[6:]} {
+		if NormalizeRecoveryCode(typed) != normalized {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, NormalizeRecoveryCode(typed), normalized)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package validate
 import (
 	"net/mail"
 
+	"github.com/febriandani/backend-user-service/internal/db"
 	"github.com/febriandani/backend-user-service/protogen/golang/users"
 )
 
@@ -63,3 +64,73 @@ func ValidateUserLogin(u *users.User) map[string]string {
 
 	return nil
 }
+
+func ValidateUserUpdate(req *users.UpdateUserRequest) map[string]string {
+	if req.GetUser() == nil || req.GetUser().GetUserId() == 0 {
+		return map[string]string{
+			"en": "User id cannot be empty",
+			"id": "User id tidak boleh kosong",
+		}
+	}
+
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return map[string]string{
+			"en": "Update mask cannot be empty",
+			"id": "Update mask tidak boleh kosong",
+		}
+	}
+
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		if _, ok := db.UpdatableUserFields[path]; !ok {
+			return map[string]string{
+				"en": "Field " + path + " cannot be updated",
+				"id": "Field " + path + " tidak dapat diubah",
+			}
+		}
+
+		switch path {
+		case "username":
+			if req.User.Username == "" {
+				return map[string]string{
+					"en": "Username cannot be empty",
+					"id": "Username tidak boleh kosong",
+				}
+			}
+		case "email":
+			_, err := mail.ParseAddress(req.User.Email)
+			if err != nil {
+				return map[string]string{
+					"en": "Incorrect email format",
+					"id": "Format email salah",
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func ValidatePasswordReset(req *users.ConfirmPasswordResetRequest) map[string]string {
+	if req.Token == "" {
+		return map[string]string{
+			"en": "Token cannot be empty",
+			"id": "Token tidak boleh kosong",
+		}
+	}
+
+	if req.Password == "" {
+		return map[string]string{
+			"en": "Password cannot be empty",
+			"id": "Kata sandi tidak boleh kosong",
+		}
+	}
+
+	if req.Password != req.Repassword {
+		return map[string]string{
+			"en": "Password and re-password are not the same.",
+			"id": "Kata sandi dan kata sandi ulang tidak sama.",
+		}
+	}
+
+	return nil
+}